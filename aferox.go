@@ -1,8 +1,8 @@
 package aferox
 
 import (
-	"path/filepath"
-	"strings"
+	"errors"
+	"os"
 
 	"github.com/spf13/afero"
 )
@@ -15,15 +15,49 @@ type Aferox struct {
 
 	// Fs is the working directory aware filesystem.
 	Fs *Fsx
+
+	// Env is the environment used in place of the current process's
+	// environment variables. When nil, for example when the Aferox was not
+	// created with NewAferox, a copy of the current process's environment is
+	// used, and it can't be modified.
+	Env *Env
+
+	// commands are the virtual commands that can be run with Command.
+	commands *commandRegistry
 }
 
 // NewAferox creates a wrapper around a filesystem representation with
-// an independent working directory.
+// an independent working directory. The environment is initialized with a copy
+// of the current process's environment variables, use NewAferoxWithEnv to
+// start from a different environment.
 func NewAferox(dir string, fs afero.Fs) Aferox {
-	wrapper := NewFsx(dir, fs)
+	return NewAferoxWithEnv(dir, fs, NewEnv(os.Environ()))
+}
+
+// NewAferoxWithEnv creates a wrapper around a filesystem representation with
+// an independent working directory and environment.
+func NewAferoxWithEnv(dir string, fs afero.Fs, env *Env) Aferox {
+	return newAferox(NewFsx(dir, fs), env)
+}
+
+// NewVirtualAferox creates a wrapper around a filesystem representation with
+// an independent working directory, which is resolved purely lexically against
+// the root of the filesystem. See NewVirtualFsx for details.
+func NewVirtualAferox(dir string, fs afero.Fs) (Aferox, error) {
+	wrapper, err := NewVirtualFsx(dir, fs)
+	if err != nil {
+		return Aferox{}, err
+	}
+	return newAferox(wrapper, NewEnv(os.Environ())), nil
+}
+
+// newAferox creates an Aferox for a wrapped filesystem and environment.
+func newAferox(wrapper *Fsx, env *Env) Aferox {
 	return Aferox{
-		Afero: &afero.Afero{Fs: wrapper},
-		Fs:    wrapper,
+		Afero:    &afero.Afero{Fs: wrapper},
+		Fs:       wrapper,
+		Env:      env,
+		commands: newCommandRegistry(),
 	}
 }
 
@@ -34,15 +68,159 @@ func (a Aferox) Getwd() string {
 }
 
 // Chdir changes the current working directory to the named directory.
+// If the directory does not exist, or is not a directory, the working directory
+// is not changed and the error will be of type *os.PathError.
 // Use in place of os.Chdir.
-func (a Aferox) Chdir(dir string) {
-	a.Fs.Chdir(dir)
+func (a Aferox) Chdir(dir string) error {
+	return a.Fs.Chdir(dir)
+}
+
+// ChdirUnchecked changes the current working directory to the named directory
+// without checking that it exists. Use when the directory is created later.
+func (a Aferox) ChdirUnchecked(dir string) {
+	a.Fs.ChdirUnchecked(dir)
+}
+
+// ChdirPrevious changes the current working directory to the previous
+// working directory, like "cd -".
+func (a Aferox) ChdirPrevious() error {
+	return a.Fs.ChdirPrevious()
+}
+
+// Oldwd returns the previous working directory, like OLDPWD. It is empty when
+// the working directory has not been changed.
+func (a Aferox) Oldwd() string {
+	return a.Fs.Oldwd()
+}
+
+// Pushd saves the current working directory on the directory stack and then
+// changes the working directory to the named directory, like "pushd".
+func (a Aferox) Pushd(dir string) error {
+	return a.Fs.Pushd(dir)
+}
+
+// Popd removes the top directory from the directory stack and changes the
+// working directory to it, like "popd".
+func (a Aferox) Popd() error {
+	return a.Fs.Popd()
+}
+
+// Dirs returns the current working directory followed by the directory stack,
+// from the most recently pushed directory to the oldest, like "dirs".
+func (a Aferox) Dirs() []string {
+	return a.Fs.Dirs()
+}
+
+// InDir changes the working directory to the named directory, calls fn, and
+// then changes back to the original working directory, even if fn panics.
+func (a Aferox) InDir(dir string, fn func() error) error {
+	return a.Fs.InDir(dir, fn)
 }
 
 func (a Aferox) Chown(name string, uid int, gid int) error {
 	return a.Fs.Chown(name, uid, gid)
 }
 
+// errNoEnv is returned when modifying the environment of an Aferox without an
+// Env.
+var errNoEnv = errors.New("the environment can't be modified, use NewAferox to create the Aferox")
+
+// env returns the environment, or a copy of the current process's
+// environment when Env is nil.
+func (a Aferox) env() *Env {
+	if a.Env != nil {
+		return a.Env
+	}
+	env := NewEnv(os.Environ())
+	env.SetPathStyle(a.Fs.PathStyle())
+	return env
+}
+
+// Getenv retrieves the value of the environment variable named by the key.
+// Use in place of os.Getenv.
+func (a Aferox) Getenv(key string) string {
+	return a.env().Getenv(key)
+}
+
+// LookupEnv retrieves the value of the environment variable named by the key,
+// and reports whether the variable is present.
+// Use in place of os.LookupEnv.
+func (a Aferox) LookupEnv(key string) (string, bool) {
+	return a.env().LookupEnv(key)
+}
+
+// Setenv sets the value of the environment variable named by the key.
+// Use in place of os.Setenv.
+func (a Aferox) Setenv(key, value string) error {
+	if a.Env == nil {
+		return errNoEnv
+	}
+	return a.Env.Setenv(key, value)
+}
+
+// Unsetenv unsets a single environment variable.
+// Use in place of os.Unsetenv.
+func (a Aferox) Unsetenv(key string) error {
+	if a.Env == nil {
+		return errNoEnv
+	}
+	return a.Env.Unsetenv(key)
+}
+
+// Environ returns a copy of strings representing the environment,
+// in the form "key=value".
+// Use in place of os.Environ.
+func (a Aferox) Environ() []string {
+	return a.env().Environ()
+}
+
+// ExpandEnv replaces ${var} or $var in the string according to the values
+// of the environment variables.
+// Use in place of os.ExpandEnv.
+func (a Aferox) ExpandEnv(s string) string {
+	return a.env().ExpandEnv(s)
+}
+
+// UserHomeDir returns the current user's home directory, based on the
+// environment. The returned path is resolved against the working directory.
+// Use in place of os.UserHomeDir.
+func (a Aferox) UserHomeDir() (string, error) {
+	home, err := a.env().UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return a.Abs(home), nil
+}
+
+// SetPathStyle changes how paths are interpreted by Fs, and which operating
+// system's conventions are followed by Env, see PathStyle for details. Abs,
+// LookPath and TempDir all follow the chosen style, regardless of the
+// operating system the program is running on.
+func (a Aferox) SetPathStyle(style PathStyle) {
+	a.Fs.SetPathStyle(style)
+	if a.Env != nil {
+		a.Env.SetPathStyle(style)
+	}
+}
+
+// SetWritePolicy restricts which paths can be modified. See WritePolicy for
+// details.
+func (a Aferox) SetWritePolicy(policy *WritePolicy) {
+	a.Fs.SetWritePolicy(policy)
+}
+
+// SetRecorder records every call made through Fs. See Recorder for details.
+func (a Aferox) SetRecorder(recorder *Recorder) {
+	a.Fs.SetRecorder(recorder)
+}
+
+// EnablePathExpansion expands a leading tilde and environment variables in
+// every path, using the home directory and environment variables from Env.
+// See PathExpansion for details, and Fsx.SetPathExpansion for more control.
+func (a Aferox) EnablePathExpansion() {
+	a.Fs.SetPathExpansion(&PathExpansion{Env: a.env()})
+}
+
 // Abs returns an absolute representation of path. If the path is not absolute
 // it will be joined with the current working directory to turn it into an
 // absolute path. The absolute path name for a given file is not guaranteed to
@@ -57,55 +235,65 @@ func (a Aferox) Abs(path string) string {
 // Use in place of exec.LookPath when you need need an independent check that a file
 // exists in a path list, for example you do not want to use the current process's
 // environment variables.
+//
+// PATH and PATHEXT are read from Env by default: when path is empty, PATH
+// from Env is searched, and when pathExt is empty, the extensions are found
+// like LookPathExec, from PATHEXT in Env for Windows paths only. So
+// LookPath(cmd, "", "") searches the environment of the Aferox.
+//
+// Whether the command name is matched ignoring case depends on the
+// filesystem, wrap a case-sensitive filesystem with CaseInsensitiveFs to
+// match names like Windows and macOS.
 func (a Aferox) LookPath(cmd string, path string, pathExt string) (string, bool) {
-	// Use a list of common filepath extensions only when an extension isn't present
-	exts := []string{""}
-	if filepath.Ext(cmd) == "" {
-		exts = strings.Split(strings.ToLower(pathExt), ";")
+	if path == "" {
+		path = a.Getenv("PATH")
 	}
+	exts := a.pathExts()
+	if pathExt != "" {
+		exts = splitPathExt(pathExt)
+	}
+	style := a.Fs.PathStyle()
 
-	paths := filepath.SplitList(path)
+	paths := style.splitList(path)
 	for _, p := range paths {
-		files, err := a.ReadDir(p)
-		if err != nil {
-			continue
-		}
-
-		for _, f := range files {
-			if f.IsDir() {
+		for _, c := range executableCandidates(style, style.join(p, cmd), exts) {
+			fi, err := a.Stat(c.path)
+			if err != nil || fi.IsDir() {
 				continue
 			}
 
-			gotFileName := f.Name()
-
-			// Check for a matching file using each possible file extension
-			for _, ext := range exts {
-				wantFileName := cmd + ext
-
-				// Use a case insensitive check to determine if we have a match.
-				// This won't work where two files have the same name, e.g. MyFile and myfile
-				if strings.EqualFold(wantFileName, gotFileName) {
-					return filepath.Join(p, gotFileName), true
-				}
-			}
+			// Report the name as it is stored, which may differ by case on a case-insensitive filesystem
+			return style.join(p, fi.Name()), true
 		}
 	}
 
 	return "", false
 }
 
+// LookPathEnv checks if command is accessible using the PATH and PATHEXT
+// environment variables from Env.
+// See LookPath for details.
+func (a Aferox) LookPathEnv(cmd string) (string, bool) {
+	return a.LookPath(cmd, "", "")
+}
+
 // TempDir creates a new temporary directory in the directory dir
 // with a name beginning with prefix and returns the path of the
 // new directory.  If dir is the empty string, TempDir uses the
-// default directory for temporary files (see os.TempDir).
+// default directory for temporary files from Env (see Env.TempDir).
 // Multiple programs calling TempDir simultaneously
 // will not choose the same directory.  It is the caller's responsibility
 // to remove the directory when no longer needed.
 func (a Aferox) TempDir(dir string, prefix string) (string, error) {
-	if dir != "" {
-		dir = a.Abs(dir)
+	if dir == "" {
+		dir = a.env().TempDir()
+	}
+	dir = a.Abs(dir)
+	name, err := a.Afero.TempDir(dir, prefix)
+	if err != nil {
+		return "", err
 	}
-	return a.Afero.TempDir(dir, prefix)
+	return a.Abs(name), nil
 }
 
 // TempFile creates a new temporary file in the directory dir,
@@ -114,14 +302,15 @@ func (a Aferox) TempDir(dir string, prefix string) (string, error) {
 // string to the end. If pattern includes a "*", the random string
 // replaces the last "*".
 // If dir is the empty string, TempFile uses the default directory
-// for temporary files (see os.TempDir).
+// for temporary files from Env (see Env.TempDir).
 // Multiple programs calling TempFile simultaneously
 // will not choose the same file. The caller can use f.Name()
 // to find the pathname of the file. It is the caller's responsibility
 // to remove the file when no longer needed.
 func (a Aferox) TempFile(dir string, pattern string) (afero.File, error) {
-	if dir != "" {
-		dir = a.Abs(dir)
+	if dir == "" {
+		dir = a.env().TempDir()
 	}
+	dir = a.Abs(dir)
 	return a.Afero.TempFile(dir, pattern)
 }
@@ -1,9 +1,11 @@
 package aferox
 
 import (
+	"errors"
 	"os"
 	"path/filepath"
 	"strings"
+	"syscall"
 	"testing"
 
 	"github.com/spf13/afero"
@@ -17,6 +19,13 @@ func xplat(value string) string {
 	return value
 }
 
+// writeTestFiles writes each file, mapped from its path to its contents, to fs.
+func writeTestFiles(t *testing.T, fs afero.Fs, files map[string]string) {
+	for path, data := range files {
+		require.NoError(t, afero.WriteFile(fs, path, []byte(data), 0644), "WriteFile failed for %s", path)
+	}
+}
+
 func Test_Getwd(t *testing.T) {
 	a := NewAferox("/home", afero.NewMemMapFs())
 	f := NewFsx("/home", afero.NewMemMapFs())
@@ -31,14 +40,136 @@ func Test_Getwd(t *testing.T) {
 func Test_Chdir(t *testing.T) {
 	a := NewAferox("/home", afero.NewMemMapFs())
 	f := NewFsx("/home", afero.NewMemMapFs())
+	require.NoError(t, a.MkdirAll("/bin", 0755), "MkdirAll failed")
+	require.NoError(t, f.MkdirAll("/bin", 0755), "MkdirAll failed")
 
-	a.Chdir("/bin")
+	err := a.Chdir("/bin")
+	require.NoError(t, err, "Chdir failed")
 	pwd := a.Getwd()
 	assert.Equal(t, xplat("/bin"), pwd)
 
-	f.Chdir("/bin")
+	err = f.Chdir("/bin")
+	require.NoError(t, err, "Chdir failed")
 	pwd = f.Getwd()
 	assert.Equal(t, xplat("/bin"), pwd)
+
+	t.Run("missing directory", func(t *testing.T) {
+		err := a.Chdir("missing")
+		require.Error(t, err)
+		var pathErr *os.PathError
+		require.True(t, errors.As(err, &pathErr), "expected a *os.PathError")
+		assert.Equal(t, syscall.ENOENT, pathErr.Err)
+		assert.Equal(t, xplat("/bin"), a.Getwd(), "the working directory should not change")
+	})
+
+	t.Run("not a directory", func(t *testing.T) {
+		_, err := a.Create("/bin/go")
+		require.NoError(t, err, "Create failed")
+
+		err = a.Chdir("go")
+		require.Error(t, err)
+		var pathErr *os.PathError
+		require.True(t, errors.As(err, &pathErr), "expected a *os.PathError")
+		assert.Equal(t, syscall.ENOTDIR, pathErr.Err)
+		assert.Equal(t, xplat("/bin"), a.Getwd(), "the working directory should not change")
+	})
+
+	t.Run("unchecked", func(t *testing.T) {
+		a.ChdirUnchecked("/missing")
+		assert.Equal(t, xplat("/missing"), a.Getwd())
+	})
+}
+
+func TestAferox_ChdirPrevious(t *testing.T) {
+	a := NewAferox("/home", afero.NewMemMapFs())
+	require.NoError(t, a.MkdirAll("/home", 0755), "MkdirAll failed")
+	require.NoError(t, a.MkdirAll("/tmp", 0755), "MkdirAll failed")
+
+	err := a.ChdirPrevious()
+	require.Error(t, err)
+	assert.True(t, errors.Is(err, ErrNoPreviousDir), "expected ErrNoPreviousDir, got %v", err)
+
+	require.NoError(t, a.Chdir("/tmp"), "Chdir failed")
+	assert.Equal(t, xplat("/home"), a.Oldwd())
+
+	require.NoError(t, a.ChdirPrevious(), "ChdirPrevious failed")
+	assert.Equal(t, xplat("/home"), a.Getwd())
+	assert.Equal(t, xplat("/tmp"), a.Oldwd())
+
+	require.NoError(t, a.ChdirPrevious(), "ChdirPrevious failed")
+	assert.Equal(t, xplat("/tmp"), a.Getwd())
+}
+
+func TestAferox_Pushd(t *testing.T) {
+	a := NewAferox("/home", afero.NewMemMapFs())
+	require.NoError(t, a.MkdirAll("/home/me", 0755), "MkdirAll failed")
+	require.NoError(t, a.MkdirAll("/tmp", 0755), "MkdirAll failed")
+
+	require.NoError(t, a.Pushd("me"), "Pushd failed")
+	require.NoError(t, a.Pushd("/tmp"), "Pushd failed")
+	assert.Equal(t, []string{xplat("/tmp"), xplat("/home/me"), xplat("/home")}, a.Dirs())
+
+	err := a.Pushd("missing")
+	require.Error(t, err)
+	assert.True(t, os.IsNotExist(err), "expected a not exist error, got %v", err)
+	assert.Equal(t, []string{xplat("/tmp"), xplat("/home/me"), xplat("/home")}, a.Dirs(), "a failed Pushd should not change the stack")
+
+	require.NoError(t, a.Popd(), "Popd failed")
+	assert.Equal(t, xplat("/home/me"), a.Getwd())
+	assert.Equal(t, xplat("/tmp"), a.Oldwd())
+
+	require.NoError(t, a.Popd(), "Popd failed")
+	assert.Equal(t, xplat("/home"), a.Getwd())
+	assert.Equal(t, []string{xplat("/home")}, a.Dirs())
+
+	err = a.Popd()
+	assert.Equal(t, ErrDirStackEmpty, err)
+}
+
+func TestAferox_InDir(t *testing.T) {
+	a := NewAferox("/home", afero.NewMemMapFs())
+	require.NoError(t, a.MkdirAll("/home/me", 0755), "MkdirAll failed")
+
+	t.Run("success", func(t *testing.T) {
+		err := a.InDir("me", func() error {
+			assert.Equal(t, xplat("/home/me"), a.Getwd())
+			return a.WriteFile("mefile.txt", nil, 0644)
+		})
+		require.NoError(t, err, "InDir failed")
+		assert.Equal(t, xplat("/home"), a.Getwd())
+
+		exists, _ := a.Exists("/home/me/mefile.txt")
+		assert.True(t, exists)
+	})
+
+	t.Run("error", func(t *testing.T) {
+		wantErr := errors.New("oops")
+		err := a.InDir("me", func() error {
+			return wantErr
+		})
+		assert.Equal(t, wantErr, err)
+		assert.Equal(t, xplat("/home"), a.Getwd())
+	})
+
+	t.Run("panic", func(t *testing.T) {
+		assert.Panics(t, func() {
+			a.InDir("me", func() error {
+				panic("oops")
+			})
+		})
+		assert.Equal(t, xplat("/home"), a.Getwd())
+	})
+
+	t.Run("missing directory", func(t *testing.T) {
+		called := false
+		err := a.InDir("missing", func() error {
+			called = true
+			return nil
+		})
+		require.Error(t, err)
+		assert.False(t, called, "fn should not be called when the directory is invalid")
+		assert.Equal(t, xplat("/home"), a.Getwd())
+	})
 }
 
 func Test_Abs(t *testing.T) {
@@ -94,7 +225,7 @@ func TestAferox_LookPath(t *testing.T) {
 	})
 
 	t.Run("match with pathext", func(t *testing.T) {
-		f := NewAferox("/home", afero.NewMemMapFs())
+		f := NewAferox("/home", NewCaseInsensitiveFs(afero.NewMemMapFs()))
 
 		_, err := f.Create("/bin/powershell.exe")
 		require.NoError(t, err, "Create failed")
@@ -104,6 +235,96 @@ func TestAferox_LookPath(t *testing.T) {
 		require.True(t, hasCmd)
 		assert.Equal(t, "/bin/powershell.exe", cmdPath)
 	})
+
+	t.Run("default pathext for windows paths", func(t *testing.T) {
+		f := NewAferoxWithEnv("/", afero.NewMemMapFs(), NewEnv([]string{`PATH=C:\bin`}))
+		f.SetPathStyle(WindowsPathStyle)
+		require.NoError(t, f.WriteFile(`C:\bin\go.exe`, nil, 0755), "WriteFile failed")
+
+		cmdPath, hasCmd := f.LookPath("go", "", "")
+		require.True(t, hasCmd, "LookPath should use the default PATHEXT like LookPathExec")
+		assert.Equal(t, `C:\bin\go.exe`, cmdPath)
+	})
+
+	t.Run("case-sensitive filesystem", func(t *testing.T) {
+		f := NewAferox("/home", afero.NewMemMapFs())
+
+		_, err := f.Create("/bin/powershell.exe")
+		require.NoError(t, err, "Create failed")
+
+		_, hasCmd := f.LookPath("POWERSHELL", "/bin", ".EXE")
+		assert.False(t, hasCmd, "names should only be matched ignoring case by a case-insensitive filesystem")
+		cmdPath, hasCmd := f.LookPath("powershell", "/bin", ".EXE")
+		require.True(t, hasCmd)
+		assert.Equal(t, "/bin/powershell.exe", cmdPath)
+	})
+}
+
+func TestAferox_LookPathEnv(t *testing.T) {
+	path := strings.Join([]string{"/home/bin", "/bin"}, string(os.PathListSeparator))
+	a := NewAferoxWithEnv("/home", afero.NewMemMapFs(), NewEnv([]string{"PATH=" + path}))
+
+	_, err := a.Create("/bin/go")
+	require.NoError(t, err, "Create failed")
+
+	cmdPath, hasCmd := a.LookPathEnv("go")
+	require.True(t, hasCmd)
+	assert.Equal(t, "/bin/go", cmdPath)
+
+	cmdPath, hasCmd = a.LookPath("go", "", "")
+	require.True(t, hasCmd, "LookPath should default to PATH from Env")
+	assert.Equal(t, "/bin/go", cmdPath)
+
+	// PATHEXT only applies to Windows paths, like LookPathExec
+	require.NoError(t, a.Setenv("PATHEXT", ".EXE"), "Setenv failed")
+	require.NoError(t, a.WriteFile("/bin/go.exe", nil, 0755), "WriteFile failed")
+	a.SetPathStyle(POSIXPathStyle)
+	cmdPath, hasCmd = a.LookPath("go", "/bin", "")
+	require.True(t, hasCmd)
+	assert.Equal(t, "/bin/go", cmdPath, "PATHEXT should be ignored for POSIX paths")
+	a.SetPathStyle(HostPathStyle)
+
+	require.NoError(t, a.Setenv("PATH", "/home/bin"), "Setenv failed")
+	_, hasCmd = a.LookPathEnv("go")
+	assert.False(t, hasCmd, "LookPathEnv should use the updated PATH")
+}
+
+func TestAferox_Env(t *testing.T) {
+	a := NewAferoxWithEnv("/home", afero.NewMemMapFs(), NewEnv(nil))
+
+	require.NoError(t, a.Setenv("AFEROX_TEST", "value"), "Setenv failed")
+	assert.Equal(t, "value", a.Getenv("AFEROX_TEST"))
+	_, inProcess := os.LookupEnv("AFEROX_TEST")
+	assert.False(t, inProcess, "Setenv should not change the process environment")
+
+	assert.Equal(t, []string{"AFEROX_TEST=value"}, a.Environ())
+	assert.Equal(t, "value/bin", a.ExpandEnv("${AFEROX_TEST}/bin"))
+
+	require.NoError(t, a.Unsetenv("AFEROX_TEST"), "Unsetenv failed")
+	_, ok := a.LookupEnv("AFEROX_TEST")
+	assert.False(t, ok)
+}
+
+func TestAferox_NilEnv(t *testing.T) {
+	fsx := NewFsx("/home", afero.NewMemMapFs())
+	a := Aferox{Afero: &afero.Afero{Fs: fsx}, Fs: fsx}
+
+	assert.Equal(t, os.Getenv("PATH"), a.Getenv("PATH"), "Getenv should read the process environment")
+	assert.NotEmpty(t, a.Environ())
+	assert.Error(t, a.Setenv("AFEROX_TEST", "value"), "Setenv should fail without an Env")
+	assert.Error(t, a.Unsetenv("PATH"), "Unsetenv should fail without an Env")
+
+	dir, err := a.TempDir("", "aferox")
+	require.NoError(t, err, "TempDir failed")
+	assert.Contains(t, dir, xplat(filepath.Join(os.TempDir(), "aferox")))
+}
+
+func TestAferox_UserHomeDir(t *testing.T) {
+	a := NewAferoxWithEnv("/home", afero.NewMemMapFs(), NewEnv([]string{"HOME=me", "USERPROFILE=me", "home=me"}))
+
+	home, err := a.UserHomeDir()
+	require.NoError(t, err, "UserHomeDir failed")
+	assert.Equal(t, xplat("/home/me"), home)
 }
 
 func TestAferox_ReadDir(t *testing.T) {
@@ -194,6 +415,15 @@ func TestAferox_TempDir(t *testing.T) {
 		wantTmp := "/etc/aferox"
 		assert.Contains(t, gotTmp, xplat(wantTmp))
 	})
+
+	t.Run("env", func(t *testing.T) {
+		a := NewAferoxWithEnv("/home", afero.NewMemMapFs(), NewEnv([]string{"TMPDIR=/scratch", "TMP=/scratch"}))
+		gotTmp, err := a.TempDir("", "aferox")
+		require.NoError(t, err)
+
+		wantTmp := "/scratch/aferox"
+		assert.Contains(t, gotTmp, xplat(wantTmp))
+	})
 }
 
 func TestAferox_TempFile(t *testing.T) {
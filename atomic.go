@@ -0,0 +1,150 @@
+package aferox
+
+import (
+	"io"
+	"os"
+	"syscall"
+
+	"github.com/spf13/afero"
+)
+
+var _ io.WriteCloser = &AtomicWriter{}
+
+// AtomicWriter writes a file atomically, so that readers, and the file left
+// behind by a crash, see either the previous contents of the file or the new
+// contents, never a partial write. The data is written to a temporary file in
+// the same directory as the file, which is synced and then renamed over the
+// file when the AtomicWriter is closed. Call Abort to discard the data
+// instead, it is safe to defer Abort after checking the error from Close.
+//
+// Use Aferox.NewAtomicWriter to create an AtomicWriter.
+type AtomicWriter struct {
+	a Aferox
+
+	// file is the temporary file that the data is written to.
+	file afero.File
+
+	// name of the file as given.
+	name string
+
+	// path is the absolute path of the file.
+	path string
+
+	// dir is the absolute path of the directory containing the file.
+	dir string
+
+	// perm is the permissions of the file once it is written.
+	perm os.FileMode
+
+	// closed is set once the AtomicWriter is closed or aborted.
+	closed bool
+}
+
+// NewAtomicWriter creates a temporary file next to the named file, resolved
+// against the working directory, to write its new contents. The contents are
+// only visible once Close is called. If the file exists, its permissions are
+// kept, otherwise the file is created with exactly perm. Unlike
+// ioutil.WriteFile, the umask is not applied, because the permissions are set
+// with Chmod before the temporary file is renamed.
+func (a Aferox) NewAtomicWriter(name string, perm os.FileMode) (*AtomicWriter, error) {
+	style := a.Fs.PathStyle()
+	path := a.Abs(name)
+	dir := a.Abs(style.join(path, ".."))
+	segments := style.split(path)
+	if style.equal(path, dir) || len(segments) == 0 {
+		return nil, &os.PathError{Op: "open", Path: name, Err: syscall.EISDIR}
+	}
+
+	if fi, err := a.Stat(path); err == nil {
+		perm = fi.Mode().Perm()
+	}
+
+	file, err := a.TempFile(dir, "."+segments[len(segments)-1]+".tmp*")
+	if err != nil {
+		return nil, err
+	}
+	return &AtomicWriter{a: a, file: file, name: name, path: path, dir: dir, perm: perm}, nil
+}
+
+// Write writes to the temporary file.
+func (w *AtomicWriter) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, &os.PathError{Op: "write", Path: w.name, Err: os.ErrClosed}
+	}
+	return w.file.Write(p)
+}
+
+// Close commits the data, by syncing the temporary file, renaming it over the
+// file and then syncing the directory. When the data cannot be committed, the
+// temporary file is removed and the file is not changed.
+func (w *AtomicWriter) Close() error {
+	if w.closed {
+		return &os.PathError{Op: "close", Path: w.name, Err: os.ErrClosed}
+	}
+	w.closed = true
+
+	tmp := w.file.Name()
+	err := w.a.Chmod(tmp, w.perm)
+	if err == nil {
+		err = w.file.Sync()
+	}
+	if closeErr := w.file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = w.a.Rename(tmp, w.path)
+	}
+	if err != nil {
+		w.a.Remove(tmp)
+		return err
+	}
+	return w.syncDir()
+}
+
+// syncDir makes the rename durable, by syncing the directory containing the
+// file. Directories can't be synced on Windows, where renames are already
+// durable.
+func (w *AtomicWriter) syncDir() error {
+	if w.a.Fs.PathStyle().windows() {
+		return nil
+	}
+
+	dir, err := w.a.Open(w.dir)
+	if err != nil {
+		return err
+	}
+	err = dir.Sync()
+	if closeErr := dir.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// Abort discards the data and removes the temporary file, leaving the file
+// unchanged. Abort does nothing once the AtomicWriter is closed.
+func (w *AtomicWriter) Abort() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+
+	w.file.Close()
+	return w.a.Remove(w.file.Name())
+}
+
+// WriteFileAtomic writes data to the named file atomically, see AtomicWriter
+// for details. If the file exists, its permissions are kept, otherwise the
+// file is created with exactly perm, the umask is not applied.
+// Use in place of ioutil.WriteFile when the file must never be left partially
+// written.
+func (a Aferox) WriteFileAtomic(name string, data []byte, perm os.FileMode) error {
+	w, err := a.NewAtomicWriter(name, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(data); err != nil {
+		w.Abort()
+		return err
+	}
+	return w.Close()
+}
@@ -0,0 +1,141 @@
+package aferox
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAferox_WriteFileAtomic(t *testing.T) {
+	a := NewAferox("/home/me", afero.NewMemMapFs())
+	require.NoError(t, a.MkdirAll("/home/me", 0755))
+
+	t.Run("new file", func(t *testing.T) {
+		require.NoError(t, a.WriteFileAtomic("state.json", []byte("v1"), 0600))
+
+		data, err := a.ReadFile("/home/me/state.json")
+		require.NoError(t, err)
+		assert.Equal(t, "v1", string(data))
+
+		fi, err := a.Stat("state.json")
+		require.NoError(t, err)
+		assert.Equal(t, os.FileMode(0600), fi.Mode().Perm())
+	})
+
+	t.Run("keep permissions", func(t *testing.T) {
+		require.NoError(t, a.Chmod("state.json", 0640))
+		require.NoError(t, a.WriteFileAtomic("state.json", []byte("v2"), 0600))
+
+		data, err := a.ReadFile("state.json")
+		require.NoError(t, err)
+		assert.Equal(t, "v2", string(data))
+
+		fi, err := a.Stat("state.json")
+		require.NoError(t, err)
+		assert.Equal(t, os.FileMode(0640), fi.Mode().Perm())
+	})
+
+	t.Run("no temporary files left", func(t *testing.T) {
+		names, err := a.ReadDir("/home/me")
+		require.NoError(t, err)
+		require.Len(t, names, 1)
+		assert.Equal(t, "state.json", names[0].Name())
+	})
+}
+
+func TestAtomicWriter(t *testing.T) {
+	a := NewAferox("/home/me", afero.NewMemMapFs())
+	require.NoError(t, a.WriteFile("state.json", []byte("v1"), 0644))
+
+	t.Run("abort", func(t *testing.T) {
+		w, err := a.NewAtomicWriter("state.json", 0644)
+		require.NoError(t, err)
+		_, err = w.Write([]byte("v2"))
+		require.NoError(t, err)
+		require.NoError(t, w.Abort())
+
+		data, err := a.ReadFile("state.json")
+		require.NoError(t, err)
+		assert.Equal(t, "v1", string(data), "expected the file to be unchanged")
+
+		names, err := a.ReadDir(".")
+		require.NoError(t, err)
+		assert.Len(t, names, 1, "expected the temporary file to be removed")
+
+		_, err = w.Write([]byte("v3"))
+		assert.True(t, errorsIsClosed(err), "expected the writer to be closed, got %v", err)
+	})
+
+	t.Run("commit on close", func(t *testing.T) {
+		w, err := a.NewAtomicWriter("state.json", 0644)
+		require.NoError(t, err)
+		defer w.Abort()
+
+		_, err = w.Write([]byte("v"))
+		require.NoError(t, err)
+		_, err = w.Write([]byte("2"))
+		require.NoError(t, err)
+
+		data, err := a.ReadFile("state.json")
+		require.NoError(t, err)
+		assert.Equal(t, "v1", string(data), "expected the data to only be visible once committed")
+
+		require.NoError(t, w.Close())
+		data, err = a.ReadFile("state.json")
+		require.NoError(t, err)
+		assert.Equal(t, "v2", string(data))
+
+		assert.True(t, errorsIsClosed(w.Close()), "expected the writer to be closed")
+		assert.NoError(t, w.Abort(), "abort after close should do nothing")
+	})
+}
+
+func errorsIsClosed(err error) bool {
+	pathErr, ok := err.(*os.PathError)
+	return ok && pathErr.Err == os.ErrClosed
+}
+
+func TestAferox_WriteFileAtomic_CrashSafe(t *testing.T) {
+	mem := afero.NewMemMapFs()
+	require.NoError(t, afero.WriteFile(mem, "/porter/state.json", []byte("v1"), 0644))
+	fs, err := NewCrashFs(mem)
+	require.NoError(t, err)
+	a := NewAferox("/porter", fs)
+	require.NoError(t, a.WriteFileAtomic("state.json", []byte("v2"), 0644))
+
+	// Both the file and the directory are synced, so the new contents survive
+	// any crash
+	var states []string
+	require.NoError(t, fs.CrashImages(func(image afero.Fs) error {
+		data, err := afero.ReadFile(image, "/porter/state.json")
+		states = append(states, string(data))
+		return err
+	}))
+	assert.Equal(t, []string{"v2"}, states)
+}
+
+func TestAferox_WriteFileAtomic_OsFs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "aferox")
+	require.NoError(t, err, "create temp directory failed")
+	defer os.RemoveAll(dir)
+
+	a := NewAferox(dir, afero.NewOsFs())
+	require.NoError(t, a.WriteFile("state.json", []byte("v1"), 0600))
+	require.NoError(t, a.WriteFileAtomic("state.json", []byte("v2"), 0644))
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, "state.json"))
+	require.NoError(t, err)
+	assert.Equal(t, "v2", string(data))
+
+	if runtime.GOOS != "windows" {
+		fi, err := os.Stat(filepath.Join(dir, "state.json"))
+		require.NoError(t, err)
+		assert.Equal(t, os.FileMode(0600), fi.Mode().Perm(), "expected the permissions to be kept")
+	}
+}
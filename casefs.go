@@ -0,0 +1,250 @@
+package aferox
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+var _ afero.Fs = &CaseInsensitiveFs{}
+var _ afero.Symlinker = &CaseInsensitiveFs{}
+
+// CaseInsensitiveFs emulates a case-insensitive, case-preserving filesystem,
+// such as APFS on macOS or NTFS on Windows, on top of a case-sensitive
+// filesystem, such as afero.MemMapFs. Combine it with Fsx to reproduce
+// problems with names that only differ by case, for example MyFile and
+// myfile, on Linux.
+//
+// Each component of a path is matched against the existing files ignoring
+// case, preferring an exact match. New files and directories keep the case
+// that they were created with, and renaming a file to a name that only
+// differs by case changes the case of the file. The FileInfo for a file
+// reports the name as it is stored.
+//
+// Absolute symbolic link targets are matched against the existing files when
+// the link is created, relative targets are stored as given. Paths are
+// compared lexically, so callers should use absolute paths, which is what Fsx
+// always provides.
+type CaseInsensitiveFs struct {
+	fs afero.Fs
+}
+
+// NewCaseInsensitiveFs creates a wrapper around a filesystem representation
+// that ignores the case of file names.
+func NewCaseInsensitiveFs(fs afero.Fs) *CaseInsensitiveFs {
+	return &CaseInsensitiveFs{fs: fs}
+}
+
+// isCaseInsensitiveFs determines if fs is a CaseInsensitiveFs, or a wrapper
+// from this package around it, by its type.
+func isCaseInsensitiveFs(fs afero.Fs) bool {
+	switch fs := fs.(type) {
+	case *CaseInsensitiveFs:
+		return true
+	case *CrashFs:
+		return isCaseInsensitiveFs(fs.fs)
+	case *FaultFs:
+		return isCaseInsensitiveFs(fs.fs)
+	case *SymlinkFs:
+		return isCaseInsensitiveFs(fs.fs)
+	case *MountFs:
+		return isCaseInsensitiveFs(fs.rootFs())
+	default:
+		return false
+	}
+}
+
+// resolve returns the path in the wrapped Fs for name, by matching each
+// component of the path against the existing files, ignoring case. The
+// components that don't exist yet are kept as given.
+func (c *CaseInsensitiveFs) resolve(name string) string {
+	path := filepath.Clean(name)
+	resolved, remaining := "", path
+	if filepath.IsAbs(path) {
+		resolved, remaining = HostPathStyle.splitRoot(path)
+	}
+
+	for remaining != "" {
+		var component string
+		if i := strings.IndexRune(remaining, filepath.Separator); i >= 0 {
+			component, remaining = remaining[:i], remaining[i+1:]
+		} else {
+			component, remaining = remaining, ""
+		}
+
+		match, ok := c.lookup(resolved, component)
+		if !ok {
+			return filepath.Join(resolved, component, remaining)
+		}
+		resolved = filepath.Join(resolved, match)
+	}
+	return resolved
+}
+
+// lookup finds the name of the file in dir that matches name, ignoring case.
+// The bool reports whether a matching file exists.
+func (c *CaseInsensitiveFs) lookup(dir string, name string) (string, bool) {
+	if name == "." || name == ".." {
+		return name, true
+	}
+	if _, err := c.lstat(filepath.Join(dir, name)); err == nil {
+		return name, true
+	}
+
+	dirPath := dir
+	if dirPath == "" {
+		dirPath = "."
+	}
+	d, err := c.fs.Open(dirPath)
+	if err != nil {
+		return "", false
+	}
+	defer d.Close()
+
+	names, err := d.Readdirnames(-1)
+	if err != nil {
+		return "", false
+	}
+	for _, n := range names {
+		if n == name {
+			return n, true
+		}
+	}
+	for _, n := range names {
+		if strings.EqualFold(n, name) {
+			return n, true
+		}
+	}
+	return "", false
+}
+
+// lstat describes the file in the wrapped Fs without following a symbolic
+// link, when supported.
+func (c *CaseInsensitiveFs) lstat(path string) (os.FileInfo, error) {
+	if lstater, ok := c.fs.(afero.Lstater); ok {
+		fi, _, err := lstater.LstatIfPossible(path)
+		return fi, err
+	}
+	return c.fs.Stat(path)
+}
+
+// Create creates or truncates the named file. When a file exists with a name
+// that only differs by case, that file is truncated instead.
+func (c *CaseInsensitiveFs) Create(name string) (afero.File, error) {
+	return c.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+}
+
+// Mkdir creates a new directory with the specified name and permission
+// bits (before umask).
+func (c *CaseInsensitiveFs) Mkdir(name string, perm os.FileMode) error {
+	return c.fs.Mkdir(c.resolve(name), perm)
+}
+
+// MkdirAll creates a directory named path, along with any necessary parents.
+func (c *CaseInsensitiveFs) MkdirAll(path string, perm os.FileMode) error {
+	return c.fs.MkdirAll(c.resolve(path), perm)
+}
+
+// Open opens the named file for reading.
+func (c *CaseInsensitiveFs) Open(name string) (afero.File, error) {
+	return c.OpenFile(name, os.O_RDONLY, 0)
+}
+
+// OpenFile opens a file using the given flags and the given mode. The
+// returned file reports the name that it was opened with.
+func (c *CaseInsensitiveFs) OpenFile(name string, flag int, perm os.FileMode) (afero.File, error) {
+	file, err := c.fs.OpenFile(c.resolve(name), flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return &namedFile{File: file, name: name}, nil
+}
+
+// Remove removes the named file or (empty) directory.
+func (c *CaseInsensitiveFs) Remove(name string) error {
+	return c.fs.Remove(c.resolve(name))
+}
+
+// RemoveAll removes path and any children it contains.
+func (c *CaseInsensitiveFs) RemoveAll(path string) error {
+	return c.fs.RemoveAll(c.resolve(path))
+}
+
+// Rename renames (moves) oldname to newname. When both names refer to the
+// same file, only differing by case, the case of the file is changed.
+func (c *CaseInsensitiveFs) Rename(oldname, newname string) error {
+	oldpath := c.resolve(oldname)
+	newpath := c.resolve(newname)
+	if oldpath == newpath {
+		// Keep the case of the new name
+		newpath = filepath.Join(filepath.Dir(newpath), filepath.Base(filepath.Clean(newname)))
+		if oldpath == newpath {
+			_, err := c.lstat(oldpath)
+			return err
+		}
+	}
+	return c.fs.Rename(oldpath, newpath)
+}
+
+// Stat returns a FileInfo describing the named file.
+func (c *CaseInsensitiveFs) Stat(name string) (os.FileInfo, error) {
+	return c.fs.Stat(c.resolve(name))
+}
+
+// LstatIfPossible returns a FileInfo describing the named file, without
+// following a symbolic link when supported by the wrapped Fs.
+func (c *CaseInsensitiveFs) LstatIfPossible(name string) (os.FileInfo, bool, error) {
+	path := c.resolve(name)
+	if lstater, ok := c.fs.(afero.Lstater); ok {
+		return lstater.LstatIfPossible(path)
+	}
+	fi, err := c.fs.Stat(path)
+	return fi, false, err
+}
+
+// SymlinkIfPossible creates newname as a symbolic link to oldname, when
+// supported by the wrapped Fs.
+func (c *CaseInsensitiveFs) SymlinkIfPossible(oldname, newname string) error {
+	linker, ok := c.fs.(afero.Linker)
+	if !ok {
+		return &os.LinkError{Op: "symlink", Old: oldname, New: newname, Err: afero.ErrNoSymlink}
+	}
+	target := oldname
+	if filepath.IsAbs(target) {
+		target = c.resolve(target)
+	}
+	return linker.SymlinkIfPossible(target, c.resolve(newname))
+}
+
+// ReadlinkIfPossible returns the destination of the named symbolic link, when
+// supported by the wrapped Fs.
+func (c *CaseInsensitiveFs) ReadlinkIfPossible(name string) (string, error) {
+	reader, ok := c.fs.(afero.LinkReader)
+	if !ok {
+		return "", &os.PathError{Op: "readlink", Path: name, Err: afero.ErrNoReadlink}
+	}
+	return reader.ReadlinkIfPossible(c.resolve(name))
+}
+
+// Name of this FileSystem.
+func (c *CaseInsensitiveFs) Name() string {
+	return "CaseInsensitiveFs"
+}
+
+// Chmod changes the mode of the named file to mode.
+func (c *CaseInsensitiveFs) Chmod(name string, mode os.FileMode) error {
+	return c.fs.Chmod(c.resolve(name), mode)
+}
+
+// Chown changes the uid and gid of the named file.
+func (c *CaseInsensitiveFs) Chown(name string, uid, gid int) error {
+	return c.fs.Chown(c.resolve(name), uid, gid)
+}
+
+// Chtimes changes the access and modification times of the named file.
+func (c *CaseInsensitiveFs) Chtimes(name string, atime time.Time, mtime time.Time) error {
+	return c.fs.Chtimes(c.resolve(name), atime, mtime)
+}
@@ -0,0 +1,81 @@
+package aferox
+
+import (
+	"os"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCaseInsensitiveFs(t *testing.T) {
+	mem := afero.NewMemMapFs()
+	fs := NewCaseInsensitiveFs(mem)
+	a := NewAferox("/", fs)
+	require.NoError(t, a.MkdirAll("/Users/Me", 0755))
+	require.NoError(t, a.WriteFile("/users/me/MyFile.txt", []byte("hello"), 0644))
+
+	t.Run("case is preserved", func(t *testing.T) {
+		exists, _ := afero.Exists(mem, "/Users/Me/MyFile.txt")
+		assert.True(t, exists, "expected the file to be created using the case of the existing directories")
+	})
+
+	t.Run("lookups ignore case", func(t *testing.T) {
+		data, err := a.ReadFile("/USERS/me/myfile.TXT")
+		require.NoError(t, err)
+		assert.Equal(t, "hello", string(data))
+
+		fi, err := a.Stat("/users/me/myfile.txt")
+		require.NoError(t, err)
+		assert.Equal(t, "MyFile.txt", fi.Name())
+	})
+
+	t.Run("names collide", func(t *testing.T) {
+		require.NoError(t, a.WriteFile("/Users/Me/myfile.txt", []byte("bye"), 0644))
+		infos, err := a.ReadDir("/Users/Me")
+		require.NoError(t, err)
+		require.Len(t, infos, 1)
+		assert.Equal(t, "MyFile.txt", infos[0].Name())
+
+		err = a.Mkdir("/users/ME", 0755)
+		assert.True(t, os.IsExist(err), "expected the directory to exist, got %v", err)
+	})
+
+	t.Run("file name", func(t *testing.T) {
+		f, err := a.Open("/users/me/myfile.txt")
+		require.NoError(t, err)
+		defer f.Close()
+		assert.Equal(t, "/users/me/myfile.txt", f.Name())
+	})
+
+	t.Run("rename changes case", func(t *testing.T) {
+		require.NoError(t, a.Rename("/users/me/myfile.txt", "/users/me/MYFILE.txt"))
+		infos, err := a.ReadDir("/Users/Me")
+		require.NoError(t, err)
+		require.Len(t, infos, 1)
+		assert.Equal(t, "MYFILE.txt", infos[0].Name())
+
+		require.NoError(t, a.Rename("/users/me/myfile.txt", "/Users/Me/MYFILE.txt"))
+	})
+
+	t.Run("remove", func(t *testing.T) {
+		require.NoError(t, a.RemoveAll("/USERS"))
+		exists, _ := afero.Exists(mem, "/Users")
+		assert.False(t, exists)
+	})
+}
+
+func TestCaseInsensitiveFs_Symlink(t *testing.T) {
+	fs := NewCaseInsensitiveFs(NewSymlinkFs(afero.NewMemMapFs()))
+	require.NoError(t, afero.WriteFile(fs, "/Data/Config.yaml", []byte("debug: true"), 0644))
+
+	require.NoError(t, fs.SymlinkIfPossible("/data/config.yaml", "/Link"))
+	target, err := fs.ReadlinkIfPossible("/link")
+	require.NoError(t, err)
+	assert.Equal(t, "/Data/Config.yaml", target)
+
+	data, err := afero.ReadFile(fs, "/LINK")
+	require.NoError(t, err)
+	assert.Equal(t, "debug: true", string(data))
+}
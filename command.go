@@ -0,0 +1,434 @@
+package aferox
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/spf13/afero"
+)
+
+// ErrCommandNotRegistered is returned when a command is found on a filesystem
+// that is not backed by the OS, or inside a jail that doesn't allow processes,
+// and no virtual command was registered for it.
+var ErrCommandNotRegistered = errors.New("no virtual command registered")
+
+// CommandFunc implements a virtual command. Return an *ExitError to exit with
+// a specific exit code, any other error exits with code 1.
+type CommandFunc func(ctx *CommandContext) error
+
+// CommandContext is the simulated process that a virtual command runs in.
+type CommandContext struct {
+	// Aferox has the same filesystem as the Aferox that ran the command, with
+	// its own working directory and environment from Cmd.Dir and Cmd.Env.
+	// Changes to the working directory or environment are not visible to the
+	// caller, just like a real process.
+	Aferox
+
+	// Args holds the command line arguments, including the command as Args[0].
+	Args []string
+
+	// Stdin is the standard input of the command. It is never nil.
+	Stdin io.Reader
+
+	// Stdout is the standard output of the command. It is never nil.
+	Stdout io.Writer
+
+	// Stderr is the standard error of the command. It is never nil.
+	Stderr io.Writer
+}
+
+// ExitError reports an unsuccessful exit by a virtual command.
+type ExitError struct {
+	// Code is the exit code of the command.
+	Code int
+
+	// Err is the error returned by the command, if any.
+	Err error
+}
+
+func (e *ExitError) Error() string {
+	if e.Err != nil {
+		return "exit status " + strconv.Itoa(e.Code) + ": " + e.Err.Error()
+	}
+	return "exit status " + strconv.Itoa(e.Code)
+}
+
+func (e *ExitError) Unwrap() error {
+	return e.Err
+}
+
+// commandRegistry holds the virtual commands, keyed by Aferox.commandKey.
+type commandRegistry struct {
+	mu       sync.RWMutex
+	commands map[string]CommandFunc
+}
+
+func newCommandRegistry() *commandRegistry {
+	return &commandRegistry{commands: make(map[string]CommandFunc)}
+}
+
+func (r *commandRegistry) get(path string) (CommandFunc, bool) {
+	if r == nil {
+		return nil, false
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	fn, ok := r.commands[path]
+	return fn, ok
+}
+
+func (r *commandRegistry) set(path string, fn CommandFunc) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.commands[path] = fn
+}
+
+// RegisterCommand registers fn as the implementation of the virtual command
+// at the specified path, which is resolved against the working directory.
+// When the file doesn't exist, an empty executable file is created for it so
+// that the command can be found with LookPathExec.
+func (a Aferox) RegisterCommand(path string, fn CommandFunc) error {
+	if a.commands == nil {
+		return errors.New("virtual commands are not supported, use NewAferox to create the Aferox")
+	}
+
+	path = a.Abs(path)
+	exists, err := a.Exists(path)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		if err := a.MkdirAll(a.Fs.PathStyle().dir(path), 0755); err != nil {
+			return err
+		}
+		if err := a.WriteFile(path, nil, 0755); err != nil {
+			return err
+		}
+	}
+
+	a.commands.set(a.commandKey(path), fn)
+	return nil
+}
+
+// commandKey returns the key in the registry for the command at the absolute
+// path. Paths are compared ignoring case with Windows paths, or on a
+// case-insensitive filesystem, such as CaseInsensitiveFs, so that a command
+// is found through any path that refers to it. The filesystem isn't called,
+// so that looking up a command doesn't show up as calls that the caller
+// never made, for example in a Recorder.
+func (a Aferox) commandKey(path string) string {
+	if a.Fs.PathStyle().windows() || isCaseInsensitiveFs(a.Fs.fs) {
+		return strings.ToLower(path)
+	}
+	return path
+}
+
+// Cmd represents a command being prepared or run, like exec.Cmd.
+// A Cmd cannot be reused after calling its Run, Output or CombinedOutput
+// methods.
+type Cmd struct {
+	// Path is the path of the command to run, as resolved by LookPathExec.
+	Path string
+
+	// Args holds command line arguments, including the command as Args[0].
+	Args []string
+
+	// Env specifies the environment of the command, in the form "key=value".
+	// It defaults to the environment of the Aferox that created the Cmd. When
+	// set to nil, the command uses the environment of the Aferox as well, like
+	// exec.Cmd uses the environment of the current process.
+	Env []string
+
+	// Dir specifies the working directory of the command, relative paths are
+	// resolved against the working directory of the Aferox. It defaults to
+	// the working directory of the Aferox that created the Cmd.
+	Dir string
+
+	// Stdin specifies the command's standard input. When nil, the command
+	// reads from an empty input.
+	Stdin io.Reader
+
+	// Stdout and Stderr specify the command's standard output and error.
+	// When nil, the output is discarded.
+	Stdout io.Writer
+	Stderr io.Writer
+
+	a         Aferox
+	lookErr   error
+	fn        CommandFunc
+	proc      *exec.Cmd
+	done      chan error
+	exitCode  int
+	started   bool
+	completed bool
+}
+
+// Command returns a Cmd to run the named program with the given arguments,
+// like exec.Command. The program is found with LookPathExec. When a virtual
+// command is registered at the resolved path, it is called when the Cmd is
+// run. Otherwise, when the file is on the host filesystem, a real process is
+// started; on any other filesystem, running the Cmd fails with
+// ErrCommandNotRegistered. When the Fsx is jailed, running a real process
+// fails the same way, unless the jail allows processes, see Jail.
+func (a Aferox) Command(name string, arg ...string) *Cmd {
+	cmd := &Cmd{
+		Path:     name,
+		Args:     append([]string{name}, arg...),
+		Env:      a.Environ(),
+		Dir:      a.Getwd(),
+		a:        a,
+		exitCode: -1,
+	}
+
+	path, err := a.LookPathExec(name)
+	if err != nil {
+		cmd.lookErr = err
+		return cmd
+	}
+	cmd.Path = path
+
+	if fn, ok := a.commands.get(a.commandKey(a.Abs(path))); ok {
+		cmd.fn = fn
+	} else if r := a.Fs.resolver(); r.jail != nil && !r.jail.AllowProcesses {
+		cmd.lookErr = &exec.Error{Name: name, Err: ErrCommandNotRegistered}
+	} else if realPath, err := r.realPath("exec", path); err != nil || !isHostFile(a.Fs.fs, realPath) {
+		cmd.lookErr = &exec.Error{Name: name, Err: ErrCommandNotRegistered}
+	}
+	return cmd
+}
+
+// isHostFile determines if the file at path in fs is the file at the same path
+// on the host, so that it can be run by the OS. Checking the file itself,
+// instead of the type of fs, works through any wrapper that keeps the paths of
+// the host, such as afero.ReadOnlyFs.
+func isHostFile(fs afero.Fs, path string) bool {
+	fi, err := fs.Stat(path)
+	if err != nil {
+		return false
+	}
+	hostFi, err := os.Stat(path)
+	return err == nil && os.SameFile(fi, hostFi)
+}
+
+// environ returns the environment of the command, which is the environment of
+// the Aferox when Env is nil.
+func (c *Cmd) environ() []string {
+	if c.Env != nil {
+		return c.Env
+	}
+	return c.a.Environ()
+}
+
+// String returns a human-readable description of the command.
+func (c *Cmd) String() string {
+	var b bytes.Buffer
+	b.WriteString(c.Path)
+	for i, arg := range c.Args {
+		if i == 0 {
+			continue
+		}
+		b.WriteString(" ")
+		b.WriteString(arg)
+	}
+	return b.String()
+}
+
+// Run starts the command and waits for it to complete.
+// When the command exits with a non-zero exit code, the error is an
+// *ExitError for a virtual command, or an *exec.ExitError for a real process.
+func (c *Cmd) Run() error {
+	if err := c.Start(); err != nil {
+		return err
+	}
+	return c.Wait()
+}
+
+// Start starts the command but does not wait for it to complete.
+func (c *Cmd) Start() error {
+	if c.lookErr != nil {
+		return c.lookErr
+	}
+	if c.started {
+		return errors.New("aferox: already started")
+	}
+
+	if c.fn == nil {
+		// Use the paths in the wrapped filesystem, which are different when
+		// the Fsx is jailed
+		r := c.a.Fs.resolver()
+		path, err := r.realPath("exec", c.Path)
+		if err != nil {
+			return err
+		}
+		dir, err := r.realPath("chdir", c.Dir)
+		if err != nil {
+			return err
+		}
+
+		c.proc = exec.Command(path)
+		c.proc.Args = c.Args
+		c.proc.Env = c.environ()
+		c.proc.Dir = dir
+		c.proc.Stdin = c.Stdin
+		c.proc.Stdout = c.Stdout
+		c.proc.Stderr = c.Stderr
+		if err := c.proc.Start(); err != nil {
+			return err
+		}
+		c.started = true
+		return nil
+	}
+
+	dir := c.a.Abs(c.Dir)
+	ctx := &CommandContext{
+		Aferox: c.a.fork(dir, NewEnv(c.environ())),
+		Args:   c.Args,
+		Stdin:  c.Stdin,
+		Stdout: c.Stdout,
+		Stderr: c.Stderr,
+	}
+	if err := ctx.Chdir(dir); err != nil {
+		return err
+	}
+	if ctx.Stdin == nil {
+		ctx.Stdin = bytes.NewReader(nil)
+	}
+	if ctx.Stdout == nil {
+		ctx.Stdout = ioutil.Discard
+	}
+	if ctx.Stderr == nil {
+		ctx.Stderr = ioutil.Discard
+	}
+
+	c.started = true
+	c.done = make(chan error, 1)
+	go func() {
+		c.done <- runCommandFunc(c.fn, ctx)
+	}()
+	return nil
+}
+
+// runCommandFunc calls the virtual command, converting errors and panics to
+// an exit code.
+func runCommandFunc(fn CommandFunc, ctx *CommandContext) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			fmt.Fprintf(ctx.Stderr, "panic: %v\n", r)
+			err = &ExitError{Code: 2, Err: fmt.Errorf("panic: %v", r)}
+		}
+	}()
+
+	err = fn(ctx)
+	if err == nil {
+		return nil
+	}
+
+	var exitErr *ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr
+	}
+	fmt.Fprintf(ctx.Stderr, "%s: %v\n", ctx.Args[0], err)
+	return &ExitError{Code: 1, Err: err}
+}
+
+// Wait waits for the command to exit. The command must have been started
+// by Start.
+func (c *Cmd) Wait() error {
+	if !c.started {
+		return errors.New("aferox: not started")
+	}
+	if c.completed {
+		return errors.New("aferox: Wait was already called")
+	}
+	c.completed = true
+
+	if c.proc != nil {
+		err := c.proc.Wait()
+		c.exitCode = c.proc.ProcessState.ExitCode()
+		return err
+	}
+
+	err := <-c.done
+	c.exitCode = 0
+	if exitErr, ok := err.(*ExitError); ok {
+		c.exitCode = exitErr.Code
+		if exitErr.Code == 0 {
+			return nil
+		}
+	}
+	return err
+}
+
+// ExitCode returns the exit code of the exited command, or -1 if the command
+// hasn't exited.
+func (c *Cmd) ExitCode() int {
+	return c.exitCode
+}
+
+// Output runs the command and returns its standard output.
+func (c *Cmd) Output() ([]byte, error) {
+	if c.Stdout != nil {
+		return nil, errors.New("aferox: Stdout already set")
+	}
+	var stdout bytes.Buffer
+	c.Stdout = &stdout
+	err := c.Run()
+	return stdout.Bytes(), err
+}
+
+// CombinedOutput runs the command and returns its combined standard output
+// and standard error.
+func (c *Cmd) CombinedOutput() ([]byte, error) {
+	if c.Stdout != nil {
+		return nil, errors.New("aferox: Stdout already set")
+	}
+	if c.Stderr != nil {
+		return nil, errors.New("aferox: Stderr already set")
+	}
+	var output bytes.Buffer
+	w := &lockedWriter{w: &output}
+	c.Stdout = w
+	c.Stderr = w
+	err := c.Run()
+	return output.Bytes(), err
+}
+
+// lockedWriter serializes writes from stdout and stderr to the same writer.
+type lockedWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (w *lockedWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.w.Write(p)
+}
+
+// fork creates an Aferox with the same filesystem and virtual commands, and
+// its own working directory and environment.
+func (a Aferox) fork(dir string, env *Env) Aferox {
+	wrapper := a.Fs.fork(dir)
+	env.SetPathStyle(wrapper.PathStyle())
+	if exp := wrapper.expansion; exp != nil && exp.Env == a.Env {
+		// Expand paths using the environment of the new process
+		forked := *exp
+		forked.Env = env
+		wrapper.expansion = &forked
+	}
+	return Aferox{
+		Afero:    &afero.Afero{Fs: wrapper},
+		Fs:       wrapper,
+		Env:      env,
+		commands: a.commands,
+	}
+}
@@ -0,0 +1,270 @@
+package aferox
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAferox_Command(t *testing.T) {
+	a := NewAferoxWithEnv("/home", afero.NewMemMapFs(), NewEnv([]string{"PATH=/bin", "GREETING=hello"}))
+	require.NoError(t, a.MkdirAll("/home/me", 0755), "MkdirAll failed")
+
+	err := a.RegisterCommand("/bin/greet", func(ctx *CommandContext) error {
+		input, err := ioutil.ReadAll(ctx.Stdin)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(ctx.Stdout, "%s %s from %s", ctx.Getenv("GREETING"), strings.Join(ctx.Args[1:], " "), ctx.Getwd())
+		return ctx.WriteFile("greeting.txt", input, 0644)
+	})
+	require.NoError(t, err, "RegisterCommand failed")
+
+	t.Run("run", func(t *testing.T) {
+		cmd := a.Command("greet", "world")
+		cmd.Stdin = strings.NewReader("stdin")
+		output, err := cmd.Output()
+		require.NoError(t, err, "Output failed")
+		assert.Equal(t, "hello world from "+xplat("/home"), string(output))
+		assert.Equal(t, 0, cmd.ExitCode())
+		assert.Equal(t, "/bin/greet", cmd.Path)
+
+		contents, err := a.ReadFile("/home/greeting.txt")
+		require.NoError(t, err, "the command should write to the same filesystem")
+		assert.Equal(t, "stdin", string(contents))
+	})
+
+	t.Run("dir and env", func(t *testing.T) {
+		cmd := a.Command("greet", "me")
+		cmd.Dir = "me"
+		cmd.Env = append(cmd.Env, "GREETING=hi")
+		output, err := cmd.Output()
+		require.NoError(t, err, "Output failed")
+		assert.Equal(t, "hi me from "+xplat("/home/me"), string(output))
+		assert.Equal(t, xplat("/home"), a.Getwd(), "the caller's working directory should not change")
+		assert.Equal(t, "hello", a.Getenv("GREETING"), "the caller's environment should not change")
+	})
+
+	t.Run("invalid dir", func(t *testing.T) {
+		cmd := a.Command("greet")
+		cmd.Dir = "missing"
+		err := cmd.Run()
+		assert.True(t, os.IsNotExist(err), "expected a not exist error, got %v", err)
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		err := a.Command("missing").Run()
+		assert.True(t, errors.Is(err, exec.ErrNotFound), "expected exec.ErrNotFound, got %v", err)
+	})
+
+	t.Run("not registered", func(t *testing.T) {
+		require.NoError(t, a.WriteFile("/bin/other", nil, 0755))
+		err := a.Command("other").Run()
+		assert.True(t, errors.Is(err, ErrCommandNotRegistered), "expected ErrCommandNotRegistered, got %v", err)
+	})
+}
+
+func TestAferox_Command_ExitCode(t *testing.T) {
+	a := NewAferoxWithEnv("/home", afero.NewMemMapFs(), NewEnv([]string{"PATH=/bin"}))
+	require.NoError(t, a.MkdirAll("/bin", 0755), "MkdirAll failed")
+	require.NoError(t, a.MkdirAll("/home", 0755), "MkdirAll failed")
+	require.NoError(t, a.RegisterCommand("/bin/exit3", func(ctx *CommandContext) error {
+		fmt.Fprint(ctx.Stdout, "out\n")
+		return &ExitError{Code: 3}
+	}))
+	require.NoError(t, a.RegisterCommand("/bin/fail", func(ctx *CommandContext) error {
+		return errors.New("oops")
+	}))
+	require.NoError(t, a.RegisterCommand("/bin/crash", func(ctx *CommandContext) error {
+		panic("boom")
+	}))
+
+	t.Run("exit code", func(t *testing.T) {
+		cmd := a.Command("exit3")
+		output, err := cmd.CombinedOutput()
+		var exitErr *ExitError
+		require.True(t, errors.As(err, &exitErr), "expected an *ExitError, got %v", err)
+		assert.Equal(t, 3, exitErr.Code)
+		assert.Equal(t, 3, cmd.ExitCode())
+		assert.Equal(t, "out\n", string(output))
+	})
+
+	t.Run("error", func(t *testing.T) {
+		var stderr bytes.Buffer
+		cmd := a.Command("fail")
+		cmd.Stderr = &stderr
+		err := cmd.Run()
+		require.Error(t, err)
+		assert.Equal(t, 1, cmd.ExitCode())
+		assert.Equal(t, "fail: oops\n", stderr.String())
+	})
+
+	t.Run("panic", func(t *testing.T) {
+		cmd := a.Command("crash")
+		output, err := cmd.CombinedOutput()
+		require.Error(t, err)
+		assert.Equal(t, 2, cmd.ExitCode())
+		assert.Contains(t, string(output), "panic: boom")
+	})
+}
+
+func TestAferox_Command_OsFs(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("pwd is not available on Windows")
+	}
+
+	tmp, err := ioutil.TempDir("", "aferox")
+	require.NoError(t, err, "create temp directory failed")
+	defer os.RemoveAll(tmp)
+
+	a := NewAferox(tmp, afero.NewOsFs())
+	output, err := a.Command("pwd").Output()
+	require.NoError(t, err, "Output failed")
+
+	// The temp directory may be behind a symlink, e.g. on macOS
+	wantDir, err := os.Stat(tmp)
+	require.NoError(t, err)
+	gotDir, err := os.Stat(strings.TrimSpace(string(output)))
+	require.NoError(t, err)
+	assert.True(t, os.SameFile(wantDir, gotDir), "expected the process to run in the working directory")
+}
+
+func TestAferox_Command_NilEnv(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("env is not available on Windows")
+	}
+
+	a := NewAferoxWithEnv("/", afero.NewMemMapFs(), NewEnv([]string{"PATH=/bin", "GREETING=hello"}))
+	err := a.RegisterCommand("/bin/env", func(ctx *CommandContext) error {
+		fmt.Fprint(ctx.Stdout, strings.Join(ctx.Environ(), "\n"))
+		return nil
+	})
+	require.NoError(t, err, "RegisterCommand failed")
+	cmd := a.Command("env")
+	cmd.Env = nil
+	output, err := cmd.Output()
+	require.NoError(t, err, "Output failed")
+	assert.Contains(t, strings.Split(string(output), "\n"), "GREETING=hello", "expected a virtual command to use the environment of the Aferox")
+
+	tmp, err := ioutil.TempDir("", "aferox")
+	require.NoError(t, err, "create temp directory failed")
+	defer os.RemoveAll(tmp)
+
+	a = NewAferox(tmp, afero.NewOsFs())
+	require.NoError(t, a.Setenv("GREETING", "hello"), "Setenv failed")
+	cmd = a.Command("env")
+	cmd.Env = nil
+	output, err = cmd.Output()
+	require.NoError(t, err, "Output failed")
+	assert.Contains(t, strings.Split(string(output), "\n"), "GREETING=hello", "expected a process to use the environment of the Aferox")
+}
+
+func TestAferox_Command_OsFsWrapper(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("pwd is not available on Windows")
+	}
+
+	tmp, err := ioutil.TempDir("", "aferox")
+	require.NoError(t, err, "create temp directory failed")
+	defer os.RemoveAll(tmp)
+
+	// Wrappers that keep the paths of the host run real processes
+	a := NewAferox(tmp, afero.NewReadOnlyFs(afero.NewOsFs()))
+	_, err = a.Command("pwd").Output()
+	require.NoError(t, err, "Output failed")
+
+	// Wrappers that change the paths don't
+	a = NewAferox("/", afero.NewBasePathFs(afero.NewOsFs(), tmp))
+	require.NoError(t, a.MkdirAll("/bin", 0755), "MkdirAll failed")
+	require.NoError(t, a.WriteFile("/bin/pwd", nil, 0755), "WriteFile failed")
+	require.NoError(t, a.Setenv("PATH", "/bin"), "Setenv failed")
+	_, err = a.Command("pwd").Output()
+	assert.True(t, errors.Is(err, ErrCommandNotRegistered), "expected ErrCommandNotRegistered, got %v", err)
+}
+
+func TestAferox_Command_Jail(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("shell scripts are not available on Windows")
+	}
+
+	tmp, err := ioutil.TempDir("", "aferox")
+	require.NoError(t, err, "create temp directory failed")
+	defer os.RemoveAll(tmp)
+
+	a, err := NewJailedAferox(Jail{Root: tmp, Strict: true}, "/", afero.NewOsFs())
+	require.NoError(t, err, "NewJailedAferox failed")
+	require.NoError(t, a.Setenv("PATH", "/bin"), "Setenv failed")
+	require.NoError(t, a.MkdirAll("/bin", 0755), "MkdirAll failed")
+	require.NoError(t, a.MkdirAll("/home", 0755), "MkdirAll failed")
+	require.NoError(t, a.Chdir("/home"), "Chdir failed")
+	require.NoError(t, a.WriteFile("/bin/pwd", []byte("#!/bin/sh\necho jailed\npwd\n"), 0755), "WriteFile failed")
+
+	_, err = a.Command("pwd").Output()
+	assert.True(t, errors.Is(err, ErrCommandNotRegistered), "expected processes to be rejected by default in a jail, got %v", err)
+
+	a, err = NewJailedAferox(Jail{Root: tmp, Strict: true, AllowProcesses: true}, "/home", afero.NewOsFs())
+	require.NoError(t, err, "NewJailedAferox failed")
+	require.NoError(t, a.Setenv("PATH", "/bin"), "Setenv failed")
+	output, err := a.Command("pwd").Output()
+	require.NoError(t, err, "Output failed")
+	lines := strings.Split(strings.TrimSpace(string(output)), "\n")
+	require.Len(t, lines, 2, "unexpected output %q", output)
+	assert.Equal(t, "jailed", lines[0], "expected the script inside the jail to run")
+
+	// The temp directory may be behind a symlink, e.g. on macOS
+	wantDir, err := os.Stat(filepath.Join(tmp, "home"))
+	require.NoError(t, err)
+	gotDir, err := os.Stat(lines[1])
+	require.NoError(t, err)
+	assert.True(t, os.SameFile(wantDir, gotDir), "expected the process to run in the working directory inside the jail")
+}
+
+func TestAferox_Command_CaseInsensitiveFs(t *testing.T) {
+	a := NewAferoxWithEnv("/", NewCaseInsensitiveFs(afero.NewMemMapFs()), NewEnv([]string{"PATH=/BIN"}))
+	require.NoError(t, a.RegisterCommand("/bin/Go", func(ctx *CommandContext) error {
+		fmt.Fprint(ctx.Stdout, "virtual")
+		return nil
+	}), "RegisterCommand failed")
+
+	output, err := a.Command("GO").Output()
+	require.NoError(t, err, "expected the command to be found through a path with a different case")
+	assert.Equal(t, "virtual", string(output))
+
+	// Finding the command only makes the calls of LookPathExec
+	lookRec, cmdRec := &Recorder{}, &Recorder{}
+	a.SetRecorder(lookRec)
+	_, err = a.LookPathExec("GO")
+	require.NoError(t, err, "LookPathExec failed")
+	a.SetRecorder(cmdRec)
+	a.Command("GO")
+	assert.Len(t, cmdRec.Operations(), len(lookRec.Operations()))
+}
+
+func TestAferox_RegisterCommand_WindowsPathStyle(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "aferox")
+	require.NoError(t, err, "create temp directory failed")
+	defer os.RemoveAll(tmp)
+
+	// The host filesystem requires the parent directory of the command to exist
+	a, err := NewJailedAferox(Jail{Root: tmp}, "/", afero.NewOsFs())
+	require.NoError(t, err, "NewJailedAferox failed")
+	a.SetPathStyle(WindowsPathStyle)
+
+	require.NoError(t, a.RegisterCommand(`C:\tools\greet.exe`, func(ctx *CommandContext) error {
+		return nil
+	}), "RegisterCommand failed")
+	fi, err := a.Stat(`C:\tools`)
+	require.NoError(t, err, "expected the parent directory to be created")
+	assert.True(t, fi.IsDir())
+}
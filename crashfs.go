@@ -0,0 +1,604 @@
+package aferox
+
+import (
+	"crypto/sha256"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+var _ afero.Fs = &CrashFs{}
+
+// CrashFs simulates a power loss, to test that files written through it
+// survive a crash. Every call is passed to the wrapped filesystem, which
+// holds what a running program sees, while CrashFs tracks which changes made
+// since the last checkpoint are durable.
+//
+// Changes are durable once they are synced, like on a POSIX filesystem:
+//
+//   - The contents of a file are durable once File.Sync is called on it.
+//   - Creating, removing or renaming a file or a directory changes the
+//     entries of its parent directory, which are durable once File.Sync is
+//     called on the directory, opened with Open.
+//
+// Changes that aren't durable may, or may not, survive a crash. The writes
+// to each file, and the changes to the entries of each directory, survive
+// in the order that they were made, but independently of the other files and
+// directories. Renaming a file within a directory is atomic, while renaming
+// it to another directory changes both directories independently.
+//
+// Only the contents of files and the entries of directories are tracked, the
+// mode of a file is always the last one that was set. Symbolic links are not
+// supported. The wrapped filesystem is read entirely by NewCrashFs and
+// Checkpoint, so it should be small, such as an afero.MemMapFs.
+type CrashFs struct {
+	fs afero.Fs
+
+	// mu protects the fields below, and orders the calls to fs with the
+	// changes that they make.
+	mu sync.Mutex
+
+	// inodes holds the files and directories, by their id. Ids start at 1,
+	// so that 0 is never a valid id.
+	inodes map[int]*crashInode
+
+	// nextID is the id of the next inode.
+	nextID int
+
+	// root is the id of the root directory.
+	root int
+
+	// paths maps the cleaned path of every file in the wrapped filesystem to
+	// its inode id.
+	paths map[string]int
+}
+
+// crashInode is a file or directory tracked by a CrashFs.
+type crashInode struct {
+	dir  bool
+	mode os.FileMode
+
+	// versions of the contents of a file, the oldest first. Each write adds a
+	// version.
+	versions [][]byte
+
+	// durable is the index of the oldest version that can survive a crash.
+	durable int
+
+	// entries of a directory at the last checkpoint, by name.
+	entries map[string]int
+
+	// changes to the entries of a directory since the last checkpoint. Each
+	// change is applied atomically.
+	changes [][]crashEntry
+
+	// synced is the number of changes that are durable.
+	synced int
+}
+
+// crashEntry sets the inode of a directory entry, an ino of 0 removes the
+// entry.
+type crashEntry struct {
+	name string
+	ino  int
+}
+
+// latest returns the current contents of a file.
+func (n *crashInode) latest() []byte {
+	return n.versions[len(n.versions)-1]
+}
+
+// NewCrashFs creates a wrapper around a filesystem representation that
+// simulates a crash. The current contents of fs are the first checkpoint.
+func NewCrashFs(fs afero.Fs) (*CrashFs, error) {
+	c := &CrashFs{fs: fs}
+	if err := c.Checkpoint(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Checkpoint makes every change durable, as if everything was synced, and
+// starts tracking changes from the current contents of the wrapped
+// filesystem.
+func (c *CrashFs) Checkpoint() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.inodes = make(map[int]*crashInode)
+	c.paths = make(map[string]int)
+	c.nextID = 1
+	root := string(filepath.Separator)
+	return afero.Walk(c.fs, root, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		node := &crashInode{dir: fi.IsDir(), mode: fi.Mode()}
+		if node.dir {
+			node.entries = make(map[string]int)
+		} else {
+			data, err := afero.ReadFile(c.fs, path)
+			if err != nil {
+				return err
+			}
+			node.versions = [][]byte{data}
+		}
+
+		ino := c.add(node)
+		c.paths[path] = ino
+		if path == root {
+			c.root = ino
+		} else if parent, ok := c.paths[filepath.Dir(path)]; ok {
+			c.inodes[parent].entries[filepath.Base(path)] = ino
+		}
+		return nil
+	})
+}
+
+// add tracks a new inode, and returns its id. The caller must hold mu.
+func (c *CrashFs) add(node *crashInode) int {
+	ino := c.nextID
+	c.nextID++
+	c.inodes[ino] = node
+	return ino
+}
+
+// change records a change to the entries of the parent directory of path.
+// The caller must hold mu.
+func (c *CrashFs) change(path string, ino int) {
+	parent, ok := c.paths[filepath.Dir(path)]
+	if !ok {
+		return
+	}
+	dir := c.inodes[parent]
+	dir.changes = append(dir.changes, []crashEntry{{name: filepath.Base(path), ino: ino}})
+}
+
+// create tracks a new file or directory at path. The caller must hold mu.
+func (c *CrashFs) create(path string, dir bool, mode os.FileMode) int {
+	node := &crashInode{dir: dir, mode: mode}
+	if dir {
+		node.entries = make(map[string]int)
+	} else {
+		node.versions = [][]byte{nil}
+	}
+	ino := c.add(node)
+	c.paths[path] = ino
+	c.change(path, ino)
+	return ino
+}
+
+// forget stops tracking path, and the files below it. The caller must hold
+// mu.
+func (c *CrashFs) forget(path string) {
+	for p := range c.paths {
+		if HostPathStyle.hasPathPrefix(p, path) {
+			delete(c.paths, p)
+		}
+	}
+}
+
+// Create creates or truncates the named file.
+func (c *CrashFs) Create(name string) (afero.File, error) {
+	return c.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+}
+
+// Mkdir creates a new directory with the specified name and permission
+// bits (before umask).
+func (c *CrashFs) Mkdir(name string, perm os.FileMode) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.fs.Mkdir(name, perm); err != nil {
+		return err
+	}
+	c.create(filepath.Clean(name), true, os.ModeDir|perm)
+	return nil
+}
+
+// MkdirAll creates a directory named path, along with any necessary parents.
+func (c *CrashFs) MkdirAll(path string, perm os.FileMode) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.fs.MkdirAll(path, perm); err != nil {
+		return err
+	}
+
+	path = filepath.Clean(path)
+	var dirs []string
+	for dir := path; ; dir = filepath.Dir(dir) {
+		if _, ok := c.paths[dir]; ok {
+			break
+		}
+		dirs = append(dirs, dir)
+		if filepath.Dir(dir) == dir {
+			break
+		}
+	}
+	for i := len(dirs) - 1; i >= 0; i-- {
+		c.create(dirs[i], true, os.ModeDir|perm)
+	}
+	return nil
+}
+
+// Open opens the named file for reading.
+func (c *CrashFs) Open(name string) (afero.File, error) {
+	return c.OpenFile(name, os.O_RDONLY, 0)
+}
+
+// OpenFile opens a file using the given flags and the given mode. Writes to
+// the returned file, and calls to Sync, are tracked.
+func (c *CrashFs) OpenFile(name string, flag int, perm os.FileMode) (afero.File, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	file, err := c.fs.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+
+	path := filepath.Clean(name)
+	ino, ok := c.paths[path]
+	if !ok {
+		if flag&os.O_CREATE == 0 {
+			// Created without going through CrashFs, so it can't be tracked
+			return file, nil
+		}
+		ino = c.create(path, false, perm)
+	} else if node := c.inodes[ino]; !node.dir && flag&os.O_TRUNC != 0 && isWriteFlag(flag) {
+		node.versions = append(node.versions, nil)
+	}
+	return &crashFile{File: file, fs: c, ino: ino, append: flag&os.O_APPEND != 0}, nil
+}
+
+// Remove removes the named file or (empty) directory.
+func (c *CrashFs) Remove(name string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.fs.Remove(name); err != nil {
+		return err
+	}
+	path := filepath.Clean(name)
+	c.change(path, 0)
+	c.forget(path)
+	return nil
+}
+
+// RemoveAll removes path and any children it contains.
+func (c *CrashFs) RemoveAll(path string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.fs.RemoveAll(path); err != nil {
+		return err
+	}
+	path = filepath.Clean(path)
+	if _, ok := c.paths[path]; ok {
+		c.change(path, 0)
+		c.forget(path)
+	}
+	return nil
+}
+
+// Rename renames (moves) oldname to newname. Renaming within a directory is
+// atomic, renaming to another directory changes each directory independently.
+func (c *CrashFs) Rename(oldname, newname string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.fs.Rename(oldname, newname); err != nil {
+		return err
+	}
+
+	oldpath, newpath := filepath.Clean(oldname), filepath.Clean(newname)
+	ino, ok := c.paths[oldpath]
+	if !ok || oldpath == newpath {
+		return nil
+	}
+
+	oldParent, newParent := filepath.Dir(oldpath), filepath.Dir(newpath)
+	if parent, ok := c.paths[oldParent]; ok && oldParent == newParent {
+		dir := c.inodes[parent]
+		dir.changes = append(dir.changes, []crashEntry{
+			{name: filepath.Base(newpath), ino: ino},
+			{name: filepath.Base(oldpath), ino: 0},
+		})
+	} else {
+		c.change(oldpath, 0)
+		c.change(newpath, ino)
+	}
+
+	// Move the paths of the renamed file, and the files below it
+	c.forget(newpath)
+	for p, id := range c.paths {
+		if HostPathStyle.hasPathPrefix(p, oldpath) {
+			delete(c.paths, p)
+			c.paths[newpath+p[len(oldpath):]] = id
+		}
+	}
+	return nil
+}
+
+// Stat returns a FileInfo describing the named file.
+func (c *CrashFs) Stat(name string) (os.FileInfo, error) {
+	return c.fs.Stat(name)
+}
+
+// Name of this FileSystem.
+func (c *CrashFs) Name() string {
+	return "CrashFs"
+}
+
+// Chmod changes the mode of the named file to mode.
+func (c *CrashFs) Chmod(name string, mode os.FileMode) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.fs.Chmod(name, mode); err != nil {
+		return err
+	}
+	if ino, ok := c.paths[filepath.Clean(name)]; ok {
+		node := c.inodes[ino]
+		node.mode = node.mode&^os.ModePerm | mode&os.ModePerm
+	}
+	return nil
+}
+
+// Chown changes the uid and gid of the named file.
+func (c *CrashFs) Chown(name string, uid, gid int) error {
+	return c.fs.Chown(name, uid, gid)
+}
+
+// Chtimes changes the access and modification times of the named file.
+func (c *CrashFs) Chtimes(name string, atime time.Time, mtime time.Time) error {
+	return c.fs.Chtimes(name, atime, mtime)
+}
+
+// CrashImages calls fn with every filesystem that could be left behind by a
+// crash, given the changes made since the last checkpoint. Each image is a new
+// afero.MemMapFs, and images with the same contents are only passed to fn
+// once. When fn returns an error, CrashImages stops and returns it. The
+// CrashFs is locked while fn runs, so fn must not use it.
+//
+// The number of images grows quickly with the number of writes that aren't
+// synced, so keep the changes made between checkpoints small.
+func (c *CrashFs) CrashImages(fn func(image afero.Fs) error) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// Each inode that changed since the checkpoint can be in several states
+	// after a crash, try every combination
+	var inos []int
+	for ino, node := range c.inodes {
+		if c.states(node) > 1 {
+			inos = append(inos, ino)
+		}
+	}
+	sort.Ints(inos)
+
+	choice := make(map[int]int, len(inos))
+	for _, ino := range inos {
+		choice[ino] = c.firstState(c.inodes[ino])
+	}
+
+	seen := make(map[[sha256.Size]byte]struct{})
+	for {
+		image := afero.NewMemMapFs()
+		digest := sha256.New()
+		if err := c.build(image, digest, c.root, string(filepath.Separator), choice, map[int]bool{}); err != nil {
+			return err
+		}
+
+		var key [sha256.Size]byte
+		copy(key[:], digest.Sum(nil))
+		if _, ok := seen[key]; !ok {
+			seen[key] = struct{}{}
+			if err := fn(image); err != nil {
+				return err
+			}
+		}
+
+		// Move on to the next combination, like incrementing a number
+		i := 0
+		for ; i < len(inos); i++ {
+			node := c.inodes[inos[i]]
+			choice[inos[i]]++
+			if choice[inos[i]] < c.firstState(node)+c.states(node) {
+				break
+			}
+			choice[inos[i]] = c.firstState(node)
+		}
+		if i == len(inos) {
+			return nil
+		}
+	}
+}
+
+// states returns the number of states that an inode can be in after a crash.
+func (c *CrashFs) states(node *crashInode) int {
+	if node.dir {
+		return len(node.changes) - node.synced + 1
+	}
+	return len(node.versions) - node.durable
+}
+
+// firstState returns the oldest state that an inode can be in after a crash,
+// the number of changes to a directory, or the version of a file.
+func (c *CrashFs) firstState(node *crashInode) int {
+	if node.dir {
+		return node.synced
+	}
+	return node.durable
+}
+
+// build writes the directory ino, as it is after a crash, to path in image.
+// The choice holds the state of each inode that changed since the checkpoint,
+// and active holds the directories being built, to stop at cycles left by
+// renaming a directory into another directory that didn't survive.
+func (c *CrashFs) build(image afero.Fs, digest io.Writer, ino int, path string, choice map[int]int, active map[int]bool) error {
+	node := c.inodes[ino]
+	entries := make(map[string]int, len(node.entries))
+	for name, child := range node.entries {
+		entries[name] = child
+	}
+	applied, ok := choice[ino]
+	if !ok {
+		applied = len(node.changes)
+	}
+	for _, change := range node.changes[:applied] {
+		for _, e := range change {
+			if e.ino == 0 {
+				delete(entries, e.name)
+			} else {
+				entries[e.name] = e.ino
+			}
+		}
+	}
+
+	names := make([]string, 0, len(entries))
+	for name := range entries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	active[ino] = true
+	defer delete(active, ino)
+	for _, name := range names {
+		childIno := entries[name]
+		child := c.inodes[childIno]
+		childPath := filepath.Join(path, name)
+
+		if child.dir {
+			if active[childIno] {
+				continue
+			}
+			io.WriteString(digest, "d "+childPath+"\x00")
+			if err := image.MkdirAll(childPath, child.mode.Perm()); err != nil {
+				return err
+			}
+			if err := c.build(image, digest, childIno, childPath, choice, active); err != nil {
+				return err
+			}
+			continue
+		}
+
+		version, ok := choice[childIno]
+		if !ok {
+			version = len(child.versions) - 1
+		}
+		data := child.versions[version]
+		io.WriteString(digest, "f "+childPath+"\x00")
+		digest.Write(data)
+		io.WriteString(digest, "\x00")
+		if err := afero.WriteFile(image, childPath, data, child.mode.Perm()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// crashFile tracks the writes to an open file, and calls to Sync.
+type crashFile struct {
+	afero.File
+
+	fs *CrashFs
+
+	// ino is the id of the inode of the file.
+	ino int
+
+	// append is set when the file was opened with O_APPEND.
+	append bool
+}
+
+func (f *crashFile) Write(p []byte) (int, error) {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+
+	node := f.fs.inodes[f.ino]
+	if node.dir {
+		return f.File.Write(p)
+	}
+	off := int64(len(node.latest()))
+	if !f.append {
+		var err error
+		if off, err = f.File.Seek(0, io.SeekCurrent); err != nil {
+			return 0, err
+		}
+	}
+	n, err := f.File.Write(p)
+	f.write(node, p[:n], off)
+	return n, err
+}
+
+func (f *crashFile) WriteAt(p []byte, off int64) (int, error) {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+
+	n, err := f.File.WriteAt(p, off)
+	f.write(f.fs.inodes[f.ino], p[:n], off)
+	return n, err
+}
+
+func (f *crashFile) WriteString(s string) (int, error) {
+	return f.Write([]byte(s))
+}
+
+// write adds a version of the file with p written at off. The caller must
+// hold the mutex of the CrashFs.
+func (f *crashFile) write(node *crashInode, p []byte, off int64) {
+	if len(p) == 0 || node.dir {
+		return
+	}
+
+	latest := node.latest()
+	size := int64(len(latest))
+	if end := off + int64(len(p)); end > size {
+		size = end
+	}
+	data := make([]byte, size)
+	copy(data, latest)
+	copy(data[off:], p)
+	node.versions = append(node.versions, data)
+}
+
+func (f *crashFile) Truncate(size int64) error {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+
+	if err := f.File.Truncate(size); err != nil {
+		return err
+	}
+	node := f.fs.inodes[f.ino]
+	if node.dir {
+		return nil
+	}
+	data := make([]byte, size)
+	copy(data, node.latest())
+	node.versions = append(node.versions, data)
+	return nil
+}
+
+// Sync makes the contents of a file, or the entries of a directory, durable.
+func (f *crashFile) Sync() error {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+
+	if err := f.File.Sync(); err != nil {
+		return err
+	}
+	node := f.fs.inodes[f.ino]
+	if node.dir {
+		node.synced = len(node.changes)
+	} else {
+		node.durable = len(node.versions) - 1
+	}
+	return nil
+}
@@ -0,0 +1,148 @@
+package aferox
+
+import (
+	"os"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// crashStates returns the distinct contents of a file in the crash images,
+// using "missing" when the file doesn't exist.
+func crashStates(t *testing.T, fs *CrashFs, path string) []string {
+	var states []string
+	seen := make(map[string]bool)
+	err := fs.CrashImages(func(image afero.Fs) error {
+		data, err := afero.ReadFile(image, path)
+		state := string(data)
+		if os.IsNotExist(err) {
+			state = "missing"
+		} else if err != nil {
+			return err
+		}
+		if !seen[state] {
+			seen[state] = true
+			states = append(states, state)
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	return states
+}
+
+// writeState replaces the state file by writing a temporary file and renaming
+// it over the state file.
+func writeState(t *testing.T, a Aferox, data string, syncFile bool, syncDir bool) {
+	f, err := a.Create("state.json.tmp")
+	require.NoError(t, err)
+	_, err = f.WriteString(data)
+	require.NoError(t, err)
+	if syncFile {
+		require.NoError(t, f.Sync())
+	}
+	require.NoError(t, f.Close())
+	require.NoError(t, a.Rename("state.json.tmp", "state.json"))
+
+	if syncDir {
+		dir, err := a.Open(".")
+		require.NoError(t, err)
+		require.NoError(t, dir.Sync())
+		require.NoError(t, dir.Close())
+	}
+}
+
+func TestCrashFs_Overwrite(t *testing.T) {
+	mem := afero.NewMemMapFs()
+	require.NoError(t, afero.WriteFile(mem, "/porter/state.json", []byte("v1"), 0644))
+	fs, err := NewCrashFs(mem)
+	require.NoError(t, err)
+	a := NewAferox("/porter", fs)
+	require.NoError(t, a.WriteFile("state.json", []byte("v2"), 0644))
+
+	data, err := a.ReadFile("state.json")
+	require.NoError(t, err)
+	assert.Equal(t, "v2", string(data), "the running program sees every change")
+
+	states := crashStates(t, fs, "/porter/state.json")
+	assert.ElementsMatch(t, []string{"v1", "", "v2"}, states, "overwriting the file in place isn't crash-safe")
+}
+
+func TestCrashFs_AtomicRename(t *testing.T) {
+	t.Run("synced", func(t *testing.T) {
+		mem := afero.NewMemMapFs()
+		require.NoError(t, afero.WriteFile(mem, "/porter/state.json", []byte("v1"), 0644))
+		fs, err := NewCrashFs(mem)
+		require.NoError(t, err)
+		a := NewAferox("/porter", fs)
+		writeState(t, a, "v2", true, false)
+		assert.ElementsMatch(t, []string{"v1", "v2"}, crashStates(t, fs, "/porter/state.json"))
+
+		dir, err := a.Open("/porter")
+		require.NoError(t, err)
+		require.NoError(t, dir.Sync())
+		assert.Equal(t, []string{"v2"}, crashStates(t, fs, "/porter/state.json"), "expected the rename to be durable")
+		assert.Equal(t, []string{"missing"}, crashStates(t, fs, "/porter/state.json.tmp"))
+	})
+
+	t.Run("file not synced", func(t *testing.T) {
+		mem := afero.NewMemMapFs()
+		require.NoError(t, afero.WriteFile(mem, "/porter/state.json", []byte("v1"), 0644))
+		fs, err := NewCrashFs(mem)
+		require.NoError(t, err)
+		a := NewAferox("/porter", fs)
+		writeState(t, a, "v2", false, true)
+		assert.ElementsMatch(t, []string{"", "v2"}, crashStates(t, fs, "/porter/state.json"), "the rename may survive without the data")
+	})
+
+	t.Run("checkpoint", func(t *testing.T) {
+		mem := afero.NewMemMapFs()
+		require.NoError(t, afero.WriteFile(mem, "/porter/state.json", []byte("v1"), 0644))
+		fs, err := NewCrashFs(mem)
+		require.NoError(t, err)
+		a := NewAferox("/porter", fs)
+		writeState(t, a, "v2", false, false)
+		require.NoError(t, fs.Checkpoint())
+		assert.Equal(t, []string{"v2"}, crashStates(t, fs, "/porter/state.json"))
+	})
+}
+
+func TestCrashFs_DirectoryEntries(t *testing.T) {
+	mem := afero.NewMemMapFs()
+	require.NoError(t, afero.WriteFile(mem, "/porter/state.json", []byte("v1"), 0644))
+	fs, err := NewCrashFs(mem)
+	require.NoError(t, err)
+	a := NewAferox("/porter", fs)
+	require.NoError(t, a.MkdirAll("/porter/outputs", 0755))
+	f, err := a.Create("/porter/outputs/result.txt")
+	require.NoError(t, err)
+	_, err = f.Write([]byte("done"))
+	require.NoError(t, err)
+	require.NoError(t, f.Sync())
+	require.NoError(t, f.Close())
+
+	// Syncing a file doesn't make its directory entry durable
+	assert.ElementsMatch(t, []string{"missing", "done"}, crashStates(t, fs, "/porter/outputs/result.txt"))
+
+	for _, dir := range []string{"/porter", "/porter/outputs"} {
+		d, err := a.Open(dir)
+		require.NoError(t, err)
+		require.NoError(t, d.Sync())
+		require.NoError(t, d.Close())
+	}
+	assert.Equal(t, []string{"done"}, crashStates(t, fs, "/porter/outputs/result.txt"))
+}
+
+func TestCrashFs_Remove(t *testing.T) {
+	mem := afero.NewMemMapFs()
+	require.NoError(t, afero.WriteFile(mem, "/porter/state.json", []byte("v1"), 0644))
+	fs, err := NewCrashFs(mem)
+	require.NoError(t, err)
+	a := NewAferox("/porter", fs)
+	require.NoError(t, a.Remove("state.json"))
+
+	exists, _ := a.Exists("state.json")
+	assert.False(t, exists)
+	assert.ElementsMatch(t, []string{"v1", "missing"}, crashStates(t, fs, "/porter/state.json"))
+}
@@ -0,0 +1,526 @@
+package aferox
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/spf13/afero"
+)
+
+// DiffOptions customize how trees are compared by Diff.
+type DiffOptions struct {
+	// IgnoreModTime doesn't report files that only differ by their
+	// modification time.
+	IgnoreModTime bool
+
+	// Ignore skips files and directories matching any of the patterns,
+	// including everything below an ignored directory. Patterns use the syntax
+	// of Glob, and are matched against the path relative to the root.
+	Ignore []string
+}
+
+// ChangeKind is how a file differs between two trees.
+type ChangeKind int
+
+const (
+	// Added files only exist in the new tree.
+	Added ChangeKind = iota + 1
+
+	// Removed files only exist in the old tree.
+	Removed
+
+	// Modified files have different contents, permissions or modification
+	// times.
+	Modified
+
+	// TypeChanged files are a different type of file in each tree, for
+	// example a file that was replaced by a directory.
+	TypeChanged
+)
+
+func (k ChangeKind) String() string {
+	switch k {
+	case Added:
+		return "added"
+	case Removed:
+		return "removed"
+	case Modified:
+		return "modified"
+	case TypeChanged:
+		return "type changed"
+	default:
+		return fmt.Sprintf("ChangeKind(%d)", int(k))
+	}
+}
+
+// code is the single letter used for the kind of change by Changes.String,
+// like git diff --name-status.
+func (k ChangeKind) code() string {
+	switch k {
+	case Added:
+		return "A"
+	case Removed:
+		return "D"
+	case Modified:
+		return "M"
+	case TypeChanged:
+		return "T"
+	default:
+		return "?"
+	}
+}
+
+// Change describes a file that differs between two trees.
+type Change struct {
+	// Path of the file relative to the root of the trees.
+	Path string
+
+	// Kind of change.
+	Kind ChangeKind
+
+	// OldMode is the mode of the file in the old tree, when it exists.
+	OldMode os.FileMode
+
+	// NewMode is the mode of the file in the new tree, when it exists.
+	NewMode os.FileMode
+
+	// ContentChanged is set when a modified file has different contents, or a
+	// modified symbolic link has a different target.
+	ContentChanged bool
+
+	// ModeChanged is set when a modified file has different permissions.
+	ModeChanged bool
+
+	// ModTimeChanged is set when a modified file has a different modification
+	// time.
+	ModTimeChanged bool
+
+	// old and new describe the file in each tree, nil when it doesn't exist.
+	old *snapshotEntry
+	new *snapshotEntry
+}
+
+// String describes the change on a single line, for example:
+//
+//	M  bin/run.sh (mode -rw-r--r-- -> -rwxr-xr-x)
+func (c Change) String() string {
+	var details []string
+	switch c.Kind {
+	case TypeChanged:
+		details = append(details, fileType(c.OldMode)+" -> "+fileType(c.NewMode))
+	case Modified:
+		if c.ModeChanged {
+			details = append(details, fmt.Sprintf("mode %s -> %s", c.OldMode, c.NewMode))
+		}
+		if c.ContentChanged && c.OldMode&os.ModeSymlink != 0 {
+			details = append(details, fmt.Sprintf("target %s -> %s", c.old.target, c.new.target))
+		}
+		if c.ModTimeChanged {
+			details = append(details, fmt.Sprintf("mtime %s -> %s", c.old.modTime.Format(time.RFC3339Nano), c.new.modTime.Format(time.RFC3339Nano)))
+		}
+	}
+
+	line := c.Kind.code() + "  " + c.Path
+	if len(details) > 0 {
+		line += " (" + strings.Join(details, ", ") + ")"
+	}
+	return line
+}
+
+// fileType names the type of a file for Change.String.
+func fileType(mode os.FileMode) string {
+	switch {
+	case mode.IsRegular():
+		return "file"
+	case mode.IsDir():
+		return "directory"
+	case mode&os.ModeSymlink != 0:
+		return "symlink"
+	default:
+		return mode.Type().String()
+	}
+}
+
+// UnifiedDiff returns the changes to the contents of a text file in the
+// unified format, like diff -u, with the old file labeled a/Path and the new
+// file labeled b/Path. Added and removed files are compared with /dev/null.
+// Binary files are only reported as different. It returns an empty string
+// when the contents of the file didn't change, or it isn't a regular file in
+// both trees.
+func (c Change) UnifiedDiff() string {
+	oldLabel, newLabel := "a/"+filepath.ToSlash(c.Path), "b/"+filepath.ToSlash(c.Path)
+	var oldData, newData []byte
+	switch c.Kind {
+	case Added:
+		if !c.new.mode.IsRegular() {
+			return ""
+		}
+		oldLabel, newData = "/dev/null", c.new.data
+	case Removed:
+		if !c.old.mode.IsRegular() {
+			return ""
+		}
+		oldData, newLabel = c.old.data, "/dev/null"
+	case Modified:
+		if !c.ContentChanged || !c.new.mode.IsRegular() {
+			return ""
+		}
+		oldData, newData = c.old.data, c.new.data
+	default:
+		return ""
+	}
+
+	if !isText(oldData) || !isText(newData) {
+		return fmt.Sprintf("Binary files %s and %s differ\n", oldLabel, newLabel)
+	}
+	return unifiedDiff(oldLabel, newLabel, splitLines(string(oldData)), splitLines(string(newData)))
+}
+
+// isText determines if data looks like the contents of a text file.
+func isText(data []byte) bool {
+	return utf8.Valid(data) && bytes.IndexByte(data, 0) < 0
+}
+
+// Changes is a list of changes between two trees, sorted by path.
+type Changes []Change
+
+// Paths returns the path of every change, for example to check that a
+// command changed exactly the expected files.
+func (changes Changes) Paths() []string {
+	paths := make([]string, len(changes))
+	for i, c := range changes {
+		paths[i] = c.Path
+	}
+	return paths
+}
+
+// String lists the changes one per line, followed by the unified diff of each
+// text file that changed, so that it can be used in a test failure message.
+func (changes Changes) String() string {
+	var b strings.Builder
+	for _, c := range changes {
+		b.WriteString(c.String())
+		b.WriteString("\n")
+	}
+	for _, c := range changes {
+		if diff := c.UnifiedDiff(); diff != "" {
+			b.WriteString("\n")
+			b.WriteString(diff)
+		}
+	}
+	return b.String()
+}
+
+// Diff compares the tree rooted at oldRoot in oldFs with the tree rooted at
+// newRoot in newFs, and returns the files that differ. Relative roots are
+// resolved like NewFsx, or against the working directory when the filesystem
+// is an *Fsx.
+//
+// Files are compared by their type, contents, permissions and modification
+// time. The modification time of directories is ignored, since it changes
+// whenever a file is added or removed in them. The only possible returned
+// error other than an I/O error is filepath.ErrBadPattern, when one of the
+// Ignore patterns is malformed.
+func Diff(oldFs afero.Fs, oldRoot string, newFs afero.Fs, newRoot string, opts DiffOptions) (Changes, error) {
+	oldTree := diffAferox(oldFs)
+	style := oldTree.Fs.PathStyle()
+	if err := validatePatterns(style, opts.Ignore); err != nil {
+		return nil, err
+	}
+
+	oldEntries, err := oldTree.captureTree(oldTree.Abs(oldRoot), opts.Ignore)
+	if err != nil {
+		return nil, err
+	}
+	newTree := diffAferox(newFs)
+	newEntries, err := newTree.captureTree(newTree.Abs(newRoot), opts.Ignore)
+	if err != nil {
+		return nil, err
+	}
+	return diffEntries(style, oldEntries, newEntries, opts), nil
+}
+
+// diffAferox wraps a filesystem compared by Diff.
+func diffAferox(fs afero.Fs) Aferox {
+	wrapper, ok := fs.(*Fsx)
+	if !ok {
+		wrapper = NewFsx(".", fs)
+	}
+	return newAferox(wrapper, NewEnv(nil))
+}
+
+// DiffSnapshot compares the state captured by a snapshot with the current
+// state of the filesystem, see Diff for details.
+func (a Aferox) DiffSnapshot(s *Snapshot, opts DiffOptions) (Changes, error) {
+	if err := validatePatterns(s.style, opts.Ignore); err != nil {
+		return nil, err
+	}
+
+	entries, err := a.captureTree(s.root, opts.Ignore)
+	if err != nil {
+		return nil, err
+	}
+	return diffEntries(s.style, s.entries, entries, opts), nil
+}
+
+// Diff compares the snapshot with a newer snapshot of the same filesystem,
+// see Diff for details.
+func (s *Snapshot) Diff(newer *Snapshot, opts DiffOptions) (Changes, error) {
+	if err := validatePatterns(s.style, opts.Ignore); err != nil {
+		return nil, err
+	}
+	return diffEntries(s.style, s.entries, newer.entries, opts), nil
+}
+
+// validatePatterns checks that every pattern is valid.
+func validatePatterns(style PathStyle, patterns []string) error {
+	for _, pattern := range patterns {
+		if _, err := globMatch(style, pattern, "", GlobOptions{}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// diffEntries compares the files of two trees, with relative paths in style.
+func diffEntries(style PathStyle, oldEntries []snapshotEntry, newEntries []snapshotEntry, opts DiffOptions) Changes {
+	oldByPath := indexEntries(style, oldEntries, opts.Ignore)
+	newByPath := indexEntries(style, newEntries, opts.Ignore)
+
+	paths := make([]string, 0, len(oldByPath)+len(newByPath))
+	for rel := range oldByPath {
+		paths = append(paths, rel)
+	}
+	for rel := range newByPath {
+		if _, ok := oldByPath[rel]; !ok {
+			paths = append(paths, rel)
+		}
+	}
+	sort.Strings(paths)
+
+	var changes Changes
+	for _, rel := range paths {
+		oldEntry, newEntry := oldByPath[rel], newByPath[rel]
+		c := Change{Path: rel, old: oldEntry, new: newEntry}
+		if oldEntry != nil {
+			c.OldMode = oldEntry.mode
+		}
+		if newEntry != nil {
+			c.NewMode = newEntry.mode
+		}
+
+		switch {
+		case oldEntry == nil:
+			c.Kind = Added
+		case newEntry == nil:
+			c.Kind = Removed
+		case oldEntry.mode.Type() != newEntry.mode.Type():
+			c.Kind = TypeChanged
+		default:
+			c.ContentChanged = !bytes.Equal(oldEntry.data, newEntry.data) || oldEntry.target != newEntry.target
+			c.ModeChanged = oldEntry.mode&chmodMode != newEntry.mode&chmodMode
+			c.ModTimeChanged = !opts.IgnoreModTime && !oldEntry.mode.IsDir() && !oldEntry.modTime.Equal(newEntry.modTime)
+			if !c.ContentChanged && !c.ModeChanged && !c.ModTimeChanged {
+				continue
+			}
+			c.Kind = Modified
+		}
+		changes = append(changes, c)
+	}
+	return changes
+}
+
+// indexEntries maps the relative path of each entry that isn't ignored to the
+// entry. An entry is ignored when it, or one of the directories containing
+// it, matches one of the patterns.
+func indexEntries(style PathStyle, entries []snapshotEntry, ignore []string) map[string]*snapshotEntry {
+	index := make(map[string]*snapshotEntry, len(entries))
+	for i := range entries {
+		rel := entries[i].rel
+		if isIgnored(style, rel, ignore) {
+			continue
+		}
+		index[rel] = &entries[i]
+	}
+	return index
+}
+
+// isIgnored determines if the relative path, or one of the directories
+// containing it, matches one of the patterns.
+func isIgnored(style PathStyle, rel string, patterns []string) bool {
+	if len(patterns) == 0 {
+		return false
+	}
+	for p := rel; p != "." && p != style.separator(); p = style.join(p, "..") {
+		for _, pattern := range patterns {
+			if matched, _ := globMatch(style, pattern, p, GlobOptions{}); matched {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// maxDiffCells limits the size of the table used to find the longest common
+// lines of two files. Larger files are shown as completely replaced.
+const maxDiffCells = 1 << 22
+
+// diffContext is the number of unchanged lines shown around each change.
+const diffContext = 3
+
+// diffLine is a line of a unified diff, with its prefix: ' ' for unchanged
+// lines, '-' for removed lines and '+' for added lines.
+type diffLine struct {
+	op   byte
+	text string
+}
+
+// splitLines splits text into lines, keeping the line endings.
+func splitLines(text string) []string {
+	if text == "" {
+		return nil
+	}
+	lines := strings.SplitAfter(text, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// diffLines returns the edit script turning a into b, based on their longest
+// common subsequence of lines.
+func diffLines(a []string, b []string) []diffLine {
+	var script []diffLine
+	if len(a)*len(b) > maxDiffCells {
+		for _, line := range a {
+			script = append(script, diffLine{'-', line})
+		}
+		for _, line := range b {
+			script = append(script, diffLine{'+', line})
+		}
+		return script
+	}
+
+	// lcs[i][j] is the length of the longest common subsequence of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			script = append(script, diffLine{' ', a[i]})
+			i++
+			j++
+		case j == len(b) || (i < len(a) && lcs[i+1][j] >= lcs[i][j+1]):
+			script = append(script, diffLine{'-', a[i]})
+			i++
+		default:
+			script = append(script, diffLine{'+', b[j]})
+			j++
+		}
+	}
+	return script
+}
+
+// unifiedDiff formats the differences between the lines of two files in the
+// unified format.
+func unifiedDiff(oldLabel string, newLabel string, oldLines []string, newLines []string) string {
+	script := diffLines(oldLines, newLines)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "--- %s\n+++ %s\n", oldLabel, newLabel)
+
+	// oldLine and newLine count the lines of each file before script[i]
+	oldLine, newLine := 0, 0
+	for i := 0; i < len(script); {
+		if script[i].op == ' ' {
+			oldLine++
+			newLine++
+			i++
+			continue
+		}
+
+		// Start the hunk with the unchanged lines before the change
+		start := i - diffContext
+		if start < 0 {
+			start = 0
+		}
+
+		// Extend the hunk until there are more unchanged lines than can be
+		// shown around two changes
+		end, unchanged := i, 0
+		for end < len(script) && unchanged <= 2*diffContext {
+			if script[end].op == ' ' {
+				unchanged++
+			} else {
+				unchanged = 0
+			}
+			end++
+		}
+		if unchanged > diffContext {
+			end -= unchanged - diffContext
+		}
+
+		oldStart, newStart := oldLine-(i-start), newLine-(i-start)
+		oldCount, newCount := 0, 0
+		for _, line := range script[start:end] {
+			if line.op != '+' {
+				oldCount++
+			}
+			if line.op != '-' {
+				newCount++
+			}
+		}
+		fmt.Fprintf(&b, "@@ -%s +%s @@\n", hunkRange(oldStart, oldCount), hunkRange(newStart, newCount))
+		for _, line := range script[start:end] {
+			b.WriteByte(line.op)
+			b.WriteString(line.text)
+			if !strings.HasSuffix(line.text, "\n") {
+				b.WriteString("\n\\ No newline at end of file\n")
+			}
+		}
+
+		for _, line := range script[i:end] {
+			if line.op != '+' {
+				oldLine++
+			}
+			if line.op != '-' {
+				newLine++
+			}
+		}
+		i = end
+	}
+	return b.String()
+}
+
+// hunkRange formats the lines of a file covered by a hunk. The first line is
+// numbered from 1, and an empty range refers to the line before it.
+func hunkRange(start int, count int) string {
+	if count == 0 {
+		return fmt.Sprintf("%d,0", start)
+	}
+	if count == 1 {
+		return fmt.Sprintf("%d", start+1)
+	}
+	return fmt.Sprintf("%d,%d", start+1, count)
+}
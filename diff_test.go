@@ -0,0 +1,165 @@
+package aferox
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiff(t *testing.T) {
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	oldFs := afero.NewMemMapFs()
+	writeTestFiles(t, oldFs, map[string]string{
+		"/src/porter.yaml": "name: mybuns\nversion: 0.1.0\n",
+		"/src/run.sh":      "echo hello\n",
+		"/src/removed.txt": "bye\n",
+		"/src/bin":         "",
+		"/src/.cache/a":    "a",
+		"/src/touched.txt": "same",
+	})
+
+	newFs := afero.NewMemMapFs()
+	writeTestFiles(t, newFs, map[string]string{
+		"/dest/porter.yaml": "name: mybuns\nversion: 0.2.0\n",
+		"/dest/run.sh":      "echo hello\n",
+		"/dest/added.txt":   "hi\n",
+		"/dest/.cache/b":    "b",
+	})
+	require.NoError(t, newFs.Chmod("/dest/run.sh", 0755))
+	require.NoError(t, newFs.MkdirAll("/dest/bin", 0755))
+
+	for _, fs := range []afero.Fs{oldFs, newFs} {
+		err := afero.Walk(fs, "/", func(path string, _ os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			return fs.Chtimes(path, mtime, mtime)
+		})
+		require.NoError(t, err)
+	}
+	// Same contents, but written after the modification times were set
+	require.NoError(t, afero.WriteFile(newFs, "/dest/touched.txt", []byte("same"), 0644))
+
+	changes, err := Diff(oldFs, "/src", newFs, "/dest", DiffOptions{Ignore: []string{".cache"}})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"added.txt", "bin", "porter.yaml", "removed.txt", "run.sh", "touched.txt"}, changes.Paths())
+
+	kinds := make(map[string]ChangeKind)
+	for _, c := range changes {
+		kinds[c.Path] = c.Kind
+	}
+	assert.Equal(t, map[string]ChangeKind{
+		"added.txt":   Added,
+		"bin":         TypeChanged,
+		"porter.yaml": Modified,
+		"removed.txt": Removed,
+		"run.sh":      Modified,
+		"touched.txt": Modified,
+	}, kinds)
+
+	run := changes[4]
+	assert.True(t, run.ModeChanged)
+	assert.False(t, run.ContentChanged)
+	assert.Equal(t, "M  run.sh (mode -rw-r--r-- -> -rwxr-xr-x)", run.String())
+	assert.Empty(t, run.UnifiedDiff(), "expected no diff when only the mode changed")
+
+	assert.True(t, changes[5].ModTimeChanged)
+
+	t.Run("ignore modification times", func(t *testing.T) {
+		changes, err := Diff(oldFs, "/src", newFs, "/dest", DiffOptions{IgnoreModTime: true, Ignore: []string{".cache"}})
+		require.NoError(t, err)
+		assert.NotContains(t, changes.Paths(), "touched.txt")
+	})
+
+	t.Run("relative roots", func(t *testing.T) {
+		// Resolved against the root of a virtual filesystem, not the working directory of the process
+		changes, err := Diff(oldFs, "src", newFs, "dest", DiffOptions{Ignore: []string{".cache"}})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"added.txt", "bin", "porter.yaml", "removed.txt", "run.sh", "touched.txt"}, changes.Paths())
+	})
+
+	t.Run("bad pattern", func(t *testing.T) {
+		_, err := Diff(oldFs, "/src", newFs, "/dest", DiffOptions{Ignore: []string{"[a"}})
+		assert.Equal(t, filepath.ErrBadPattern, err)
+	})
+}
+
+func TestChange_UnifiedDiff(t *testing.T) {
+	oldFs := afero.NewMemMapFs()
+	require.NoError(t, afero.WriteFile(oldFs, "/porter.yaml", []byte("a\nb\nc\nd\ne\nf\ng\nh\ni\nj\nk\nl\n"), 0644))
+	require.NoError(t, afero.WriteFile(oldFs, "/image.png", []byte{0x89, 'P', 'N', 'G', 0}, 0644))
+	require.NoError(t, afero.WriteFile(oldFs, "/removed.txt", []byte("bye"), 0644))
+
+	newFs := afero.NewMemMapFs()
+	require.NoError(t, afero.WriteFile(newFs, "/porter.yaml", []byte("a\nB\nc\nd\ne\nf\ng\nh\ni\nj\nl\nm"), 0644))
+	require.NoError(t, afero.WriteFile(newFs, "/image.png", []byte{0x89, 'P', 'N', 'G', 1}, 0644))
+
+	changes, err := Diff(oldFs, "/", newFs, "/", DiffOptions{IgnoreModTime: true})
+	require.NoError(t, err)
+	require.Equal(t, []string{"image.png", "porter.yaml", "removed.txt"}, changes.Paths())
+
+	want := `M  image.png
+M  porter.yaml
+D  removed.txt
+
+Binary files a/image.png and b/image.png differ
+
+--- a/porter.yaml
++++ b/porter.yaml
+@@ -1,5 +1,5 @@
+ a
+-b
++B
+ c
+ d
+ e
+@@ -8,5 +8,5 @@
+ h
+ i
+ j
+-k
+ l
++m
+\ No newline at end of file
+
+--- a/removed.txt
++++ /dev/null
+@@ -1 +0,0 @@
+-bye
+\ No newline at end of file
+`
+	assert.Equal(t, want, changes.String())
+}
+
+func TestAferox_DiffSnapshot(t *testing.T) {
+	a := NewAferox("/home/me", afero.NewMemMapFs())
+	require.NoError(t, a.MkdirAll("/home/me", 0755))
+	require.NoError(t, a.WriteFile("porter.yaml", []byte("name: mybuns\n"), 0644))
+
+	snap, err := a.Snapshot()
+	require.NoError(t, err)
+
+	require.NoError(t, a.WriteFile("porter.yaml", []byte("name: mybuns\nversion: 0.1.0\n"), 0644))
+	require.NoError(t, a.MkdirAll(".cnab", 0755))
+	require.NoError(t, a.WriteFile(".cnab/bundle.json", []byte("{}"), 0644))
+
+	changes, err := a.DiffSnapshot(snap, DiffOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		filepath.Join("home", "me", ".cnab"),
+		filepath.Join("home", "me", ".cnab", "bundle.json"),
+		filepath.Join("home", "me", "porter.yaml"),
+	}, changes.Paths(), changes.String())
+
+	newer, err := a.Snapshot()
+	require.NoError(t, err)
+	sameChanges, err := snap.Diff(newer, DiffOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, changes.Paths(), sameChanges.Paths())
+}
@@ -0,0 +1,207 @@
+package aferox
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// Env is a set of environment variables that is independent of the current
+// process's environment. Use in place of the environment functions in the os
+// package when you need an isolated environment, for example when simulating
+// a process in tests.
+//
+// Env is safe for concurrent use. On Windows, or when using WindowsPathStyle,
+// variable names are case insensitive, just like the process environment.
+type Env struct {
+	mu   sync.RWMutex
+	vars map[string]envVar
+
+	// style determines which operating system's conventions are followed.
+	style PathStyle
+}
+
+// envVar is a single environment variable, keeping the name as it was set.
+type envVar struct {
+	key   string
+	value string
+}
+
+// NewEnv creates an environment from a list of strings in the form
+// "key=value", such as the result of os.Environ. Entries without an equal sign
+// are ignored. When a key is repeated, the last value is used.
+func NewEnv(environ []string) *Env {
+	e := &Env{vars: make(map[string]envVar, len(environ))}
+	for _, kv := range environ {
+		// Windows has hidden variables such as "=C:=C:\foo" so skip a leading equal sign
+		i := strings.Index(kv, "=")
+		if i == 0 {
+			i = strings.Index(kv[1:], "=") + 1
+		}
+		if i <= 0 {
+			continue
+		}
+		key := kv[:i]
+		e.vars[e.key(key)] = envVar{key: key, value: kv[i+1:]}
+	}
+	return e
+}
+
+// SetPathStyle changes which operating system's conventions the environment
+// follows, for the case sensitivity of variable names, TempDir and
+// UserHomeDir. When variable names become case insensitive, and a name is
+// repeated, one of the values is kept.
+func (e *Env) SetPathStyle(style PathStyle) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.style = style
+	vars := make(map[string]envVar, len(e.vars))
+	for _, v := range e.vars {
+		vars[e.key(v.key)] = v
+	}
+	e.vars = vars
+}
+
+// PathStyle returns which operating system's conventions the environment
+// follows.
+func (e *Env) PathStyle() PathStyle {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.style
+}
+
+// key normalizes the name of an environment variable for lookups.
+// The caller must hold mu.
+func (e *Env) key(key string) string {
+	if e.style.windows() {
+		return strings.ToUpper(key)
+	}
+	return key
+}
+
+// Getenv retrieves the value of the environment variable named by the key.
+// It returns the value, which will be empty if the variable is not present.
+// Use in place of os.Getenv.
+func (e *Env) Getenv(key string) string {
+	value, _ := e.LookupEnv(key)
+	return value
+}
+
+// LookupEnv retrieves the value of the environment variable named by the key.
+// If the variable is present in the environment the value (which may be empty)
+// is returned and the boolean is true. Otherwise the returned value will be
+// empty and the boolean will be false.
+// Use in place of os.LookupEnv.
+func (e *Env) LookupEnv(key string) (string, bool) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	v, ok := e.vars[e.key(key)]
+	return v.value, ok
+}
+
+// Setenv sets the value of the environment variable named by the key.
+// It returns an error, if any.
+// Use in place of os.Setenv.
+func (e *Env) Setenv(key, value string) error {
+	if key == "" || strings.ContainsAny(key, "=\x00") || strings.Contains(value, "\x00") {
+		return os.NewSyscallError("setenv", syscall.EINVAL)
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.vars[e.key(key)] = envVar{key: key, value: value}
+	return nil
+}
+
+// Unsetenv unsets a single environment variable.
+// Use in place of os.Unsetenv.
+func (e *Env) Unsetenv(key string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	delete(e.vars, e.key(key))
+	return nil
+}
+
+// Clearenv deletes all environment variables.
+// Use in place of os.Clearenv.
+func (e *Env) Clearenv() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.vars = make(map[string]envVar)
+}
+
+// Environ returns a copy of strings representing the environment,
+// in the form "key=value", sorted by key.
+// Use in place of os.Environ.
+func (e *Env) Environ() []string {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	environ := make([]string, 0, len(e.vars))
+	for _, v := range e.vars {
+		environ = append(environ, v.key+"="+v.value)
+	}
+	sort.Strings(environ)
+	return environ
+}
+
+// ExpandEnv replaces ${var} or $var in the string according to the values
+// of the environment variables. References to undefined variables are
+// replaced by the empty string.
+// Use in place of os.ExpandEnv.
+func (e *Env) ExpandEnv(s string) string {
+	return os.Expand(s, e.Getenv)
+}
+
+// TempDir returns the default directory to use for temporary files, based on
+// the environment.
+// On Unix systems, it returns $TMPDIR if non-empty, else /tmp.
+// On Windows, it uses the first non-empty value of %TMP%, %TEMP% and
+// %USERPROFILE%, else the system default, or C:\Windows\Temp when emulating
+// Windows on another operating system.
+// Use in place of os.TempDir.
+func (e *Env) TempDir() string {
+	if e.PathStyle().windows() {
+		for _, key := range []string{"TMP", "TEMP", "USERPROFILE"} {
+			if dir := e.Getenv(key); dir != "" {
+				return dir
+			}
+		}
+		if runtime.GOOS == "windows" {
+			return os.TempDir()
+		}
+		return `C:\Windows\Temp`
+	}
+
+	if dir := e.Getenv("TMPDIR"); dir != "" {
+		return dir
+	}
+	return "/tmp"
+}
+
+// UserHomeDir returns the current user's home directory, based on the
+// environment.
+// On Unix systems, it returns $HOME.
+// On Windows, it returns %USERPROFILE%.
+// On Plan 9, it returns $home.
+// Use in place of os.UserHomeDir.
+func (e *Env) UserHomeDir() (string, error) {
+	key := "HOME"
+	switch style := e.PathStyle(); {
+	case style.windows():
+		key = "USERPROFILE"
+	case style == HostPathStyle && runtime.GOOS == "plan9":
+		key = "home"
+	}
+
+	if dir := e.Getenv(key); dir != "" {
+		return dir, nil
+	}
+	return "", fmt.Errorf("$%s is not defined", key)
+}
@@ -0,0 +1,89 @@
+package aferox
+
+import (
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewEnv(t *testing.T) {
+	e := NewEnv([]string{"A=1", "B=two=2", "EMPTY=", "INVALID", "A=3", "=C:=C:\\foo"})
+
+	assert.Equal(t, "3", e.Getenv("A"), "the last value for a repeated key should win")
+	assert.Equal(t, "two=2", e.Getenv("B"))
+
+	value, ok := e.LookupEnv("EMPTY")
+	assert.True(t, ok, "EMPTY should be set")
+	assert.Empty(t, value)
+
+	_, ok = e.LookupEnv("INVALID")
+	assert.False(t, ok, "entries without an equal sign should be ignored")
+
+	assert.Equal(t, "C:\\foo", e.Getenv("=C:"))
+}
+
+func TestEnv_Setenv(t *testing.T) {
+	e := NewEnv(nil)
+
+	err := e.Setenv("FOO", "bar")
+	require.NoError(t, err, "Setenv failed")
+	assert.Equal(t, "bar", e.Getenv("FOO"))
+	assert.Equal(t, []string{"FOO=bar"}, e.Environ())
+
+	err = e.Setenv("", "bar")
+	assert.Error(t, err, "Setenv should reject an empty key")
+
+	err = e.Setenv("A=B", "bar")
+	assert.Error(t, err, "Setenv should reject a key with an equal sign")
+
+	err = e.Unsetenv("FOO")
+	require.NoError(t, err, "Unsetenv failed")
+	_, ok := e.LookupEnv("FOO")
+	assert.False(t, ok, "FOO should be unset")
+}
+
+func TestEnv_Environ(t *testing.T) {
+	e := NewEnv([]string{"B=2", "A=1"})
+	assert.Equal(t, []string{"A=1", "B=2"}, e.Environ())
+
+	e.Clearenv()
+	assert.Empty(t, e.Environ())
+}
+
+func TestEnv_ExpandEnv(t *testing.T) {
+	e := NewEnv([]string{"HOME=/home/me", "NAME=porter"})
+	assert.Equal(t, "/home/me/.porter/porter", e.ExpandEnv("$HOME/.${NAME}/$NAME"))
+	assert.Equal(t, "/bin", e.ExpandEnv("$MISSING/bin"))
+}
+
+func TestEnv_TempDir(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		e := NewEnv([]string{`TEMP=C:\temp`})
+		assert.Equal(t, `C:\temp`, e.TempDir())
+		return
+	}
+
+	e := NewEnv(nil)
+	assert.Equal(t, "/tmp", e.TempDir())
+
+	e = NewEnv([]string{"TMPDIR=/var/tmp"})
+	assert.Equal(t, "/var/tmp", e.TempDir())
+}
+
+func TestEnv_UserHomeDir(t *testing.T) {
+	key := "HOME"
+	if runtime.GOOS == "windows" {
+		key = "USERPROFILE"
+	}
+
+	e := NewEnv(nil)
+	_, err := e.UserHomeDir()
+	assert.Error(t, err, "expected an error when the home directory is not set")
+
+	require.NoError(t, e.Setenv(key, "/home/me"))
+	home, err := e.UserHomeDir()
+	require.NoError(t, err, "UserHomeDir failed")
+	assert.Equal(t, "/home/me", home)
+}
@@ -0,0 +1,79 @@
+package aferox
+
+import (
+	"strings"
+)
+
+// PathExpansion configures how Fsx expands a leading tilde and environment
+// variables in paths before resolving them against the working directory.
+//
+// A path of "~" or starting with "~/" is expanded to the home directory, and
+// "~user" or "~user/" to the home directory of that user. A tilde that can't
+// be expanded, for example for an unknown user, is left as-is.
+// References to environment variables, $VAR or ${VAR}, are replaced with
+// their value, undefined variables are replaced by the empty string just like
+// os.ExpandEnv.
+type PathExpansion struct {
+	// Home is the home directory used to expand "~". When empty, the home
+	// directory from Env is used.
+	Home string
+
+	// Users maps user names to their home directory, to expand "~user".
+	Users map[string]string
+
+	// Env holds the environment variables to expand. When nil, environment
+	// variables are not expanded.
+	Env *Env
+}
+
+// expand applies the tilde and environment variable expansion to path, which
+// uses the separators of style. It is safe to call on a nil PathExpansion,
+// which leaves the path unchanged.
+func (e *PathExpansion) expand(style PathStyle, path string) string {
+	if e == nil {
+		return path
+	}
+
+	path = e.expandTilde(style, path)
+	if e.Env != nil && strings.Contains(path, "$") {
+		path = e.Env.ExpandEnv(path)
+	}
+	return path
+}
+
+// expandTilde replaces a leading ~ or ~user with the home directory.
+func (e *PathExpansion) expandTilde(style PathStyle, path string) string {
+	if !strings.HasPrefix(path, "~") {
+		return path
+	}
+
+	end := strings.IndexFunc(path, func(r rune) bool {
+		return r < 0x80 && style.isSeparator(uint8(r))
+	})
+	if end < 0 {
+		end = len(path)
+	}
+
+	var home string
+	if user := path[1:end]; user != "" {
+		home = e.Users[user]
+	} else {
+		home = e.homeDir()
+	}
+	if home == "" {
+		return path
+	}
+	return home + path[end:]
+}
+
+// homeDir returns the home directory used to expand "~".
+func (e *PathExpansion) homeDir() string {
+	if e.Home != "" {
+		return e.Home
+	}
+	if e.Env != nil {
+		home, _ := e.Env.UserHomeDir()
+		return home
+	}
+	return ""
+}
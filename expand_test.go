@@ -0,0 +1,132 @@
+package aferox
+
+import (
+	"os"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPathExpansion_expand(t *testing.T) {
+	e := &PathExpansion{
+		Home:  "/home/me",
+		Users: map[string]string{"root": "/root"},
+		Env:   NewEnv([]string{"PORTER_HOME=/home/me/.porter", "NAME=config"}),
+	}
+
+	testcases := []struct {
+		path string
+		want string
+	}{
+		{path: "~", want: "/home/me"},
+		{path: "~/.porter/config.toml", want: "/home/me/.porter/config.toml"},
+		{path: "~root/.ssh", want: "/root/.ssh"},
+		{path: "~nobody/bin", want: "~nobody/bin"},
+		{path: "docs/~/file", want: "docs/~/file"},
+		{path: "$PORTER_HOME/bin", want: "/home/me/.porter/bin"},
+		{path: "${PORTER_HOME}/${NAME}.toml", want: "/home/me/.porter/config.toml"},
+		{path: "$MISSING/bin", want: "/bin"},
+		{path: "plain/path", want: "plain/path"},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.path, func(t *testing.T) {
+			assert.Equal(t, tc.want, e.expand(HostPathStyle, tc.path))
+		})
+	}
+
+	t.Run("nil", func(t *testing.T) {
+		var e *PathExpansion
+		assert.Equal(t, "~/$HOME", e.expand(HostPathStyle, "~/$HOME"))
+	})
+
+	t.Run("home from env", func(t *testing.T) {
+		e := &PathExpansion{Env: NewEnv([]string{"HOME=/home/env", "USERPROFILE=/home/env", "home=/home/env"})}
+		assert.Equal(t, "/home/env/bin", e.expand(HostPathStyle, "~/bin"))
+	})
+
+	t.Run("windows path style", func(t *testing.T) {
+		e := &PathExpansion{Home: `C:\Users\me`}
+		assert.Equal(t, `C:\Users\me\.porter`, e.expand(WindowsPathStyle, `~\.porter`))
+		assert.Equal(t, `C:\Users\me/.porter`, e.expand(WindowsPathStyle, "~/.porter"))
+	})
+
+	t.Run("no env", func(t *testing.T) {
+		e := &PathExpansion{Home: "/home/me"}
+		assert.Equal(t, "/home/me/$HOME", e.expand(HostPathStyle, "~/$HOME"))
+	})
+}
+
+func TestFsx_PathExpansion(t *testing.T) {
+	f := NewFsx("/work", afero.NewMemMapFs())
+
+	_, err := f.Create("~/file.txt")
+	require.NoError(t, err, "Create failed")
+	_, err = f.Stat("/work/~/file.txt")
+	require.NoError(t, err, "paths should not be expanded by default")
+
+	f.SetPathExpansion(&PathExpansion{Home: "/home/me", Env: NewEnv([]string{"DEST=/tmp"})})
+	assert.Equal(t, xplat("/home/me/.porter"), f.Abs("~/.porter"))
+
+	require.NoError(t, f.MkdirAll("~/.porter", 0755), "MkdirAll failed")
+	require.NoError(t, f.MkdirAll("$DEST", 0755), "MkdirAll failed")
+	_, err = f.Create("~/.porter/config.toml")
+	require.NoError(t, err, "Create failed")
+	_, err = f.Stat("/home/me/.porter/config.toml")
+	require.NoError(t, err, "Create should expand the path")
+
+	require.NoError(t, f.Chmod("~/.porter/config.toml", 0600), "Chmod failed")
+	fi, err := f.Stat("~/.porter/config.toml")
+	require.NoError(t, err, "Stat failed")
+	assert.Equal(t, os.FileMode(0600), fi.Mode().Perm())
+
+	err = f.Rename("~/.porter/config.toml", "$DEST/config.toml")
+	require.NoError(t, err, "Rename failed")
+	_, err = f.Stat("/tmp/config.toml")
+	require.NoError(t, err, "Rename should expand both paths")
+
+	require.NoError(t, f.Chdir("~"), "Chdir failed")
+	assert.Equal(t, xplat("/home/me"), f.Getwd())
+
+	require.NoError(t, f.Remove("${DEST}/config.toml"), "Remove failed")
+	_, err = f.Stat("/tmp/config.toml")
+	assert.True(t, os.IsNotExist(err), "Remove should expand the path")
+}
+
+func TestAferox_EnablePathExpansion(t *testing.T) {
+	a := NewAferoxWithEnv("/work", afero.NewMemMapFs(), NewEnv([]string{"HOME=/home/me", "USERPROFILE=/home/me", "home=/home/me", "BIN=bin"}))
+	a.EnablePathExpansion()
+
+	require.NoError(t, a.WriteFile("~/$BIN/tool", []byte("tool"), 0755), "WriteFile failed")
+	contents, err := a.ReadFile("/home/me/bin/tool")
+	require.NoError(t, err, "ReadFile failed")
+	assert.Equal(t, "tool", string(contents))
+
+	require.NoError(t, a.Setenv("BIN", "other"), "Setenv failed")
+	assert.Equal(t, xplat("/home/me/other"), a.Abs("~/$BIN"), "expansion should use the current environment")
+}
+
+func TestAferox_PathExpansion_SavedDirs(t *testing.T) {
+	a := NewAferoxWithEnv("/", afero.NewMemMapFs(), NewEnv(nil))
+	require.NoError(t, a.MkdirAll("/home/$x", 0755), "MkdirAll failed")
+	require.NoError(t, a.MkdirAll("/tmp2", 0755), "MkdirAll failed")
+	require.NoError(t, a.Chdir("/home/$x"), "Chdir failed")
+
+	// Directories that were already resolved must not be expanded again
+	require.NoError(t, a.Setenv("x", "y"), "Setenv failed")
+	a.EnablePathExpansion()
+	wantDir := xplat("/home/$x")
+
+	require.NoError(t, a.Pushd("/tmp2"), "Pushd failed")
+	require.NoError(t, a.Popd(), "Popd failed")
+	assert.Equal(t, wantDir, a.Getwd(), "Popd should restore the saved directory")
+
+	require.NoError(t, a.Chdir("/tmp2"), "Chdir failed")
+	require.NoError(t, a.ChdirPrevious(), "ChdirPrevious failed")
+	assert.Equal(t, wantDir, a.Getwd(), "ChdirPrevious should restore the saved directory")
+
+	err := a.InDir("/tmp2", func() error { return nil })
+	require.NoError(t, err, "InDir failed")
+	assert.Equal(t, wantDir, a.Getwd(), "InDir should restore the saved directory")
+}
@@ -0,0 +1,461 @@
+package aferox
+
+import (
+	"io"
+	"math/rand"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+var _ afero.Fs = &FaultFs{}
+var _ afero.Symlinker = &FaultFs{}
+
+// FaultFs injects failures into a filesystem, such as ENOSPC, EACCES, EIO or
+// short writes, to test how code handles errors that filesystems like
+// afero.MemMapFs never return. Use it under NewFsx, so that the rules are
+// matched against absolute paths.
+//
+// Each call is checked against the rules in the order that they were
+// injected, and the first rule that applies is used. A call that isn't
+// affected by any rule is passed to the wrapped filesystem unchanged. Rules
+// are deterministic, the only source of randomness is FaultRule.Probability,
+// which uses the seed given to NewFaultFs, so a failing test can be
+// reproduced by running the same calls with the same seed.
+type FaultFs struct {
+	fs afero.Fs
+
+	// mu protects rules and rand.
+	mu    sync.Mutex
+	rules []*faultState
+	rand  *rand.Rand
+}
+
+// FaultRule describes which calls to a FaultFs fail, and how.
+type FaultRule struct {
+	// Op is the operation to match, when empty every operation matches. The
+	// operations are named like the Op of the *os.PathError that they would
+	// return:
+	//
+	//	open       Create, Open and OpenFile
+	//	mkdir      Mkdir and MkdirAll
+	//	remove, removeall, rename, stat, lstat, chmod, chown, chtimes,
+	//	symlink, readlink
+	//	read       File.Read and ReadAt
+	//	write      File.Write, WriteAt and WriteString
+	//	readdir    File.Readdir and Readdirnames
+	//	close, sync, truncate
+	//	           File.Close, Sync and Truncate
+	Op string
+
+	// Path is a pattern matched against the path of the call, using the same
+	// syntax as Glob, so "/home/**" matches every file below /home. For
+	// Rename either path may match, and for file operations the path that
+	// the file was opened with is used. When empty every path matches.
+	Path string
+
+	// After skips the first After calls matched by the rule, so that a
+	// failure can be injected into the third write of a file for example.
+	After int
+
+	// Times limits how many times the rule is applied, when 0 the rule is
+	// applied to every call that it matches.
+	Times int
+
+	// Probability of applying the rule to a matching call, between 0 and 1.
+	// When 0 the rule is always applied.
+	Probability float64
+
+	// Err is returned instead of calling the wrapped filesystem. It is wrapped
+	// in a *os.PathError, or a *os.LinkError for Rename and SymlinkIfPossible,
+	// unless it already is one. When nil, the call is only delayed.
+	Err error
+
+	// WriteLimit makes writes to a file fail once WriteLimit bytes have been
+	// written to it, simulating a full disk. The write that crosses the limit
+	// writes as much as it can and returns Err, or io.ErrShortWrite when Err
+	// is nil. It only applies to File.Write, WriteAt and WriteString.
+	WriteLimit int64
+
+	// Latency delays the call, before it is passed to the wrapped filesystem
+	// or fails.
+	Latency time.Duration
+}
+
+// faultState tracks how often a rule has matched.
+type faultState struct {
+	FaultRule
+
+	// calls is the number of calls matched by the rule.
+	calls int
+
+	// applied is the number of times the rule was applied.
+	applied int
+}
+
+// NewFaultFs creates a wrapper around a filesystem representation that
+// injects failures. The seed is used for rules with a Probability.
+func NewFaultFs(fs afero.Fs, seed int64) *FaultFs {
+	return &FaultFs{
+		fs:   fs,
+		rand: rand.New(rand.NewSource(seed)),
+	}
+}
+
+// Inject adds a rule for the calls that should fail. The only possible
+// returned error is filepath.ErrBadPattern, when the Path pattern is
+// malformed.
+func (f *FaultFs) Inject(rule FaultRule) error {
+	if _, err := globMatch(HostPathStyle, rule.Path, "", GlobOptions{}); err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.rules = append(f.rules, &faultState{FaultRule: rule})
+	return nil
+}
+
+// Reset removes all rules.
+func (f *FaultFs) Reset() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.rules = nil
+}
+
+// match determines if the rule matches an operation on any of the paths.
+func (r *faultState) match(op string, paths []string) bool {
+	if r.Op != "" && r.Op != op {
+		return false
+	}
+	if r.Path == "" {
+		return true
+	}
+	for _, path := range paths {
+		if matched, _ := globMatch(HostPathStyle, r.Path, path, GlobOptions{}); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// find returns the rule to apply to a call, or nil when the call is not
+// affected. Every rule that matches the call counts it, even when an earlier
+// rule is applied. For writes, size is the number of bytes to write and
+// written is the number of bytes already written to the file.
+func (f *FaultFs) find(op string, size int, written int64, paths ...string) *FaultRule {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var found *FaultRule
+	for _, r := range f.rules {
+		if !r.match(op, paths) {
+			continue
+		}
+		r.calls++
+		if found != nil || r.calls <= r.After || (r.Times > 0 && r.applied >= r.Times) {
+			continue
+		}
+		if r.WriteLimit > 0 && written+int64(size) <= r.WriteLimit {
+			continue
+		}
+		if r.Probability > 0 && f.rand.Float64() >= r.Probability {
+			continue
+		}
+		r.applied++
+		rule := r.FaultRule
+		found = &rule
+	}
+	return found
+}
+
+// inject applies the first rule affecting a call, and returns the error that
+// the call should fail with, or nil when the call should be passed to the
+// wrapped filesystem.
+func (f *FaultFs) inject(op string, paths ...string) error {
+	rule := f.find(op, 0, 0, paths...)
+	if rule == nil {
+		return nil
+	}
+	if rule.Latency > 0 {
+		time.Sleep(rule.Latency)
+	}
+	return rule.Err
+}
+
+// faultError wraps an injected error in a *os.PathError.
+func faultError(op string, name string, err error) error {
+	switch err.(type) {
+	case *os.PathError, *os.LinkError:
+		return err
+	default:
+		return &os.PathError{Op: op, Path: name, Err: err}
+	}
+}
+
+// faultLinkError wraps an injected error in a *os.LinkError.
+func faultLinkError(op string, oldname string, newname string, err error) error {
+	switch err.(type) {
+	case *os.PathError, *os.LinkError:
+		return err
+	default:
+		return &os.LinkError{Op: op, Old: oldname, New: newname, Err: err}
+	}
+}
+
+// Create creates or truncates the named file.
+func (f *FaultFs) Create(name string) (afero.File, error) {
+	return f.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+}
+
+// Mkdir creates a new directory with the specified name and permission
+// bits (before umask).
+func (f *FaultFs) Mkdir(name string, perm os.FileMode) error {
+	if err := f.inject("mkdir", name); err != nil {
+		return faultError("mkdir", name, err)
+	}
+	return f.fs.Mkdir(name, perm)
+}
+
+// MkdirAll creates a directory named path, along with any necessary parents.
+func (f *FaultFs) MkdirAll(path string, perm os.FileMode) error {
+	if err := f.inject("mkdir", path); err != nil {
+		return faultError("mkdir", path, err)
+	}
+	return f.fs.MkdirAll(path, perm)
+}
+
+// Open opens the named file for reading.
+func (f *FaultFs) Open(name string) (afero.File, error) {
+	return f.OpenFile(name, os.O_RDONLY, 0)
+}
+
+// OpenFile opens a file using the given flags and the given mode. The
+// returned file injects failures into its own operations.
+func (f *FaultFs) OpenFile(name string, flag int, perm os.FileMode) (afero.File, error) {
+	if err := f.inject("open", name); err != nil {
+		return nil, faultError("open", name, err)
+	}
+	file, err := f.fs.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return &faultFile{File: file, fs: f, name: name}, nil
+}
+
+// Remove removes the named file or (empty) directory.
+func (f *FaultFs) Remove(name string) error {
+	if err := f.inject("remove", name); err != nil {
+		return faultError("remove", name, err)
+	}
+	return f.fs.Remove(name)
+}
+
+// RemoveAll removes path and any children it contains.
+func (f *FaultFs) RemoveAll(path string) error {
+	if err := f.inject("removeall", path); err != nil {
+		return faultError("removeall", path, err)
+	}
+	return f.fs.RemoveAll(path)
+}
+
+// Rename renames (moves) oldname to newname.
+func (f *FaultFs) Rename(oldname, newname string) error {
+	if err := f.inject("rename", oldname, newname); err != nil {
+		return faultLinkError("rename", oldname, newname, err)
+	}
+	return f.fs.Rename(oldname, newname)
+}
+
+// Stat returns a FileInfo describing the named file.
+func (f *FaultFs) Stat(name string) (os.FileInfo, error) {
+	if err := f.inject("stat", name); err != nil {
+		return nil, faultError("stat", name, err)
+	}
+	return f.fs.Stat(name)
+}
+
+// LstatIfPossible returns a FileInfo describing the named file, without
+// following a symbolic link when supported by the wrapped filesystem.
+func (f *FaultFs) LstatIfPossible(name string) (os.FileInfo, bool, error) {
+	if err := f.inject("lstat", name); err != nil {
+		return nil, false, faultError("lstat", name, err)
+	}
+	if lstater, ok := f.fs.(afero.Lstater); ok {
+		return lstater.LstatIfPossible(name)
+	}
+	fi, err := f.fs.Stat(name)
+	return fi, false, err
+}
+
+// SymlinkIfPossible creates newname as a symbolic link to oldname, when
+// supported by the wrapped filesystem. Rules are matched against newname.
+func (f *FaultFs) SymlinkIfPossible(oldname, newname string) error {
+	if err := f.inject("symlink", newname); err != nil {
+		return faultLinkError("symlink", oldname, newname, err)
+	}
+	linker, ok := f.fs.(afero.Linker)
+	if !ok {
+		return &os.LinkError{Op: "symlink", Old: oldname, New: newname, Err: afero.ErrNoSymlink}
+	}
+	return linker.SymlinkIfPossible(oldname, newname)
+}
+
+// ReadlinkIfPossible returns the destination of the named symbolic link, when
+// supported by the wrapped filesystem.
+func (f *FaultFs) ReadlinkIfPossible(name string) (string, error) {
+	if err := f.inject("readlink", name); err != nil {
+		return "", faultError("readlink", name, err)
+	}
+	reader, ok := f.fs.(afero.LinkReader)
+	if !ok {
+		return "", &os.PathError{Op: "readlink", Path: name, Err: afero.ErrNoReadlink}
+	}
+	return reader.ReadlinkIfPossible(name)
+}
+
+// Name of this FileSystem.
+func (f *FaultFs) Name() string {
+	return "FaultFs"
+}
+
+// Chmod changes the mode of the named file to mode.
+func (f *FaultFs) Chmod(name string, mode os.FileMode) error {
+	if err := f.inject("chmod", name); err != nil {
+		return faultError("chmod", name, err)
+	}
+	return f.fs.Chmod(name, mode)
+}
+
+// Chown changes the uid and gid of the named file.
+func (f *FaultFs) Chown(name string, uid, gid int) error {
+	if err := f.inject("chown", name); err != nil {
+		return faultError("chown", name, err)
+	}
+	return f.fs.Chown(name, uid, gid)
+}
+
+// Chtimes changes the access and modification times of the named file.
+func (f *FaultFs) Chtimes(name string, atime time.Time, mtime time.Time) error {
+	if err := f.inject("chtimes", name); err != nil {
+		return faultError("chtimes", name, err)
+	}
+	return f.fs.Chtimes(name, atime, mtime)
+}
+
+// faultFile injects failures into the operations on an open file.
+type faultFile struct {
+	afero.File
+
+	fs *FaultFs
+
+	// name that the file was opened with, used to match rules.
+	name string
+
+	// mu protects written.
+	mu sync.Mutex
+
+	// written is the number of bytes written to the file through this handle.
+	written int64
+}
+
+func (f *faultFile) Close() error {
+	err := f.File.Close()
+	if faultErr := f.fs.inject("close", f.name); faultErr != nil {
+		return faultError("close", f.name, faultErr)
+	}
+	return err
+}
+
+func (f *faultFile) Read(p []byte) (int, error) {
+	if err := f.fs.inject("read", f.name); err != nil {
+		return 0, faultError("read", f.name, err)
+	}
+	return f.File.Read(p)
+}
+
+func (f *faultFile) ReadAt(p []byte, off int64) (int, error) {
+	if err := f.fs.inject("read", f.name); err != nil {
+		return 0, faultError("read", f.name, err)
+	}
+	return f.File.ReadAt(p, off)
+}
+
+func (f *faultFile) Readdir(count int) ([]os.FileInfo, error) {
+	if err := f.fs.inject("readdir", f.name); err != nil {
+		return nil, faultError("readdir", f.name, err)
+	}
+	return f.File.Readdir(count)
+}
+
+func (f *faultFile) Readdirnames(n int) ([]string, error) {
+	if err := f.fs.inject("readdir", f.name); err != nil {
+		return nil, faultError("readdir", f.name, err)
+	}
+	return f.File.Readdirnames(n)
+}
+
+func (f *faultFile) Sync() error {
+	if err := f.fs.inject("sync", f.name); err != nil {
+		return faultError("sync", f.name, err)
+	}
+	return f.File.Sync()
+}
+
+func (f *faultFile) Truncate(size int64) error {
+	if err := f.fs.inject("truncate", f.name); err != nil {
+		return faultError("truncate", f.name, err)
+	}
+	return f.File.Truncate(size)
+}
+
+func (f *faultFile) Write(p []byte) (int, error) {
+	return f.write(p, f.File.Write)
+}
+
+func (f *faultFile) WriteAt(p []byte, off int64) (int, error) {
+	return f.write(p, func(p []byte) (int, error) {
+		return f.File.WriteAt(p, off)
+	})
+}
+
+func (f *faultFile) WriteString(s string) (int, error) {
+	return f.Write([]byte(s))
+}
+
+// write writes p with writeFn, unless a rule makes the write fail. When the
+// write crosses a WriteLimit, only the bytes up to the limit are written.
+func (f *faultFile) write(p []byte, writeFn func([]byte) (int, error)) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	rule := f.fs.find("write", len(p), f.written, f.name)
+	if rule != nil && rule.Latency > 0 {
+		time.Sleep(rule.Latency)
+	}
+
+	switch {
+	case rule != nil && rule.WriteLimit > 0:
+		limit := rule.WriteLimit - f.written
+		if limit < 0 {
+			limit = 0
+		}
+		n, err := writeFn(p[:limit])
+		f.written += int64(n)
+		if err != nil {
+			return n, err
+		}
+		if rule.Err == nil {
+			return n, faultError("write", f.name, io.ErrShortWrite)
+		}
+		return n, faultError("write", f.name, rule.Err)
+	case rule != nil && rule.Err != nil:
+		return 0, faultError("write", f.name, rule.Err)
+	default:
+		n, err := writeFn(p)
+		f.written += int64(n)
+		return n, err
+	}
+}
@@ -0,0 +1,137 @@
+package aferox
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFaultFs(t *testing.T) {
+	fs := NewFaultFs(afero.NewMemMapFs(), 1)
+	a := NewAferox("/home/me", fs)
+	require.NoError(t, fs.Inject(FaultRule{Op: "open", Path: "/home/me/.porter/**", Err: syscall.EACCES}))
+	require.NoError(t, fs.Inject(FaultRule{Op: "mkdir", After: 1, Times: 1, Err: syscall.EIO}))
+
+	t.Run("path glob after working directory resolution", func(t *testing.T) {
+		err := a.WriteFile(".porter/config.toml", nil, 0644)
+		require.Error(t, err)
+		pathErr, ok := err.(*os.PathError)
+		require.True(t, ok, "expected a *os.PathError, got %T", err)
+		assert.Equal(t, "open", pathErr.Op)
+		assert.Equal(t, "/home/me/.porter/config.toml", pathErr.Path)
+		assert.True(t, errors.Is(err, syscall.EACCES), "expected EACCES, got %v", err)
+
+		require.NoError(t, a.WriteFile("porter.yaml", nil, 0644))
+	})
+
+	t.Run("call count", func(t *testing.T) {
+		require.NoError(t, a.Mkdir("/a", 0755))
+		err := a.Mkdir("/b", 0755)
+		assert.True(t, errors.Is(err, syscall.EIO), "expected the second mkdir to fail, got %v", err)
+		require.NoError(t, a.Mkdir("/c", 0755))
+	})
+
+	t.Run("reset", func(t *testing.T) {
+		fs.Reset()
+		require.NoError(t, a.WriteFile(".porter/config.toml", nil, 0644))
+	})
+}
+
+func TestFaultFs_Write(t *testing.T) {
+	mem := afero.NewMemMapFs()
+	fs := NewFaultFs(mem, 1)
+	require.NoError(t, fs.Inject(FaultRule{Op: "write", Path: "/out/*.txt", WriteLimit: 8, Err: syscall.ENOSPC}))
+	require.NoError(t, fs.Inject(FaultRule{Op: "sync", Err: syscall.EIO}))
+
+	f, err := fs.Create("/out/data.txt")
+	require.NoError(t, err)
+
+	n, err := f.WriteString("hello ")
+	require.NoError(t, err)
+	assert.Equal(t, 6, n)
+
+	n, err = f.Write([]byte("world"))
+	assert.Equal(t, 2, n, "expected a short write")
+	assert.True(t, errors.Is(err, syscall.ENOSPC), "expected ENOSPC, got %v", err)
+
+	err = f.Sync()
+	assert.True(t, errors.Is(err, syscall.EIO), "expected EIO, got %v", err)
+	require.NoError(t, f.Close())
+
+	data, err := afero.ReadFile(mem, "/out/data.txt")
+	require.NoError(t, err)
+	assert.Equal(t, "hello wo", string(data))
+
+	t.Run("short write without an error", func(t *testing.T) {
+		fs.Reset()
+		require.NoError(t, fs.Inject(FaultRule{WriteLimit: 1}))
+		err := afero.WriteFile(fs, "/out/short.txt", []byte("abc"), 0644)
+		assert.True(t, errors.Is(err, io.ErrShortWrite), "expected io.ErrShortWrite, got %v", err)
+	})
+}
+
+func TestFaultFs_Close(t *testing.T) {
+	fs := NewFaultFs(afero.NewMemMapFs(), 1)
+	require.NoError(t, fs.Inject(FaultRule{Op: "close", Path: "/data.txt", Err: syscall.EIO}))
+
+	err := afero.WriteFile(fs, "/data.txt", []byte("hello"), 0644)
+	assert.True(t, errors.Is(err, syscall.EIO), "expected EIO, got %v", err)
+
+	data, err := afero.ReadFile(fs.fs, "/data.txt")
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(data), "expected the data to be written before close failed")
+}
+
+func TestFaultFs_Rename(t *testing.T) {
+	fs := NewFaultFs(afero.NewMemMapFs(), 1)
+	require.NoError(t, afero.WriteFile(fs, "/a.txt", nil, 0644))
+	require.NoError(t, fs.Inject(FaultRule{Op: "rename", Path: "/backup/*", Err: syscall.EXDEV}))
+
+	err := fs.Rename("/a.txt", "/backup/a.txt")
+	_, ok := err.(*os.LinkError)
+	assert.True(t, ok, "expected a *os.LinkError, got %T", err)
+	assert.True(t, errors.Is(err, syscall.EXDEV), "expected EXDEV, got %v", err)
+}
+
+func TestFaultFs_Latency(t *testing.T) {
+	fs := NewFaultFs(afero.NewMemMapFs(), 1)
+	require.NoError(t, fs.Inject(FaultRule{Op: "stat", Latency: 20 * time.Millisecond}))
+
+	start := time.Now()
+	_, err := fs.Stat("/")
+	require.NoError(t, err)
+	assert.True(t, time.Since(start) >= 20*time.Millisecond, "expected the call to be delayed")
+}
+
+func TestFaultFs_Probability(t *testing.T) {
+	run := func(seed int64) []bool {
+		fs := NewFaultFs(afero.NewMemMapFs(), seed)
+		require.NoError(t, fs.Inject(FaultRule{Op: "stat", Probability: 0.5, Err: syscall.EIO}))
+
+		var failed []bool
+		for i := 0; i < 20; i++ {
+			_, err := fs.Stat("/")
+			failed = append(failed, err != nil)
+		}
+		return failed
+	}
+
+	results := run(42)
+	assert.Equal(t, results, run(42), "expected the same seed to inject the same failures")
+	assert.Contains(t, results, true)
+	assert.Contains(t, results, false)
+}
+
+func TestFaultFs_BadPattern(t *testing.T) {
+	fs := NewFaultFs(afero.NewMemMapFs(), 1)
+	err := fs.Inject(FaultRule{Path: "/[a"})
+	assert.Equal(t, filepath.ErrBadPattern, err)
+}
@@ -1,46 +1,500 @@
 package aferox
 
 import (
+	"errors"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/spf13/afero"
 )
 
 var _ afero.Fs = &Fsx{}
+var _ afero.Symlinker = &Fsx{}
+
+// ErrDirStackEmpty is returned by Popd when there are no directories on the
+// directory stack.
+var ErrDirStackEmpty = errors.New("directory stack empty")
+
+// ErrNoPreviousDir is returned by ChdirPrevious when the working directory has
+// not been changed yet.
+var ErrNoPreviousDir = errors.New("previous directory not set")
 
 // Fsx adjusts all relative paths based on the stored
 // working directory, instead of relying on the default behavior for relative
 // paths defined by the implementing Fs.
+//
+// Fsx is safe for concurrent use. Each method resolves its paths against the
+// working directory once, when it is called, so an operation that is already
+// running when Chdir is called continues to use the previous working directory.
 type Fsx struct {
 	fs afero.Fs
 
+	// mu protects dir, oldDir, dirStack, drives, expansion, style, policy and
+	// recorder.
+	mu  sync.RWMutex
 	dir string
+
+	// oldDir is the previous working directory, like OLDPWD.
+	oldDir string
+
+	// dirStack holds the directories saved by Pushd, the top of the stack is last.
+	dirStack []string
+
+	// expansion configures expanding ~ and environment variables in paths,
+	// when nil paths are not expanded.
+	expansion *PathExpansion
+
+	// drives holds the last working directory of each drive, by driveKey,
+	// when using Windows paths. It is replaced instead of modified, so that
+	// a pathResolver can keep a reference to it.
+	drives map[string]string
+
+	// style determines how paths are interpreted.
+	style PathStyle
+
+	// jail restricts the filesystem to a directory in fs, when nil the
+	// filesystem is not restricted.
+	jail *Jail
+
+	// policy restricts which paths can be written, with the directories
+	// already resolved. When nil, every path can be written.
+	policy *WritePolicy
+
+	// recorder logs every call, when nil calls are not recorded.
+	recorder *Recorder
 }
 
+// NewFsx creates a wrapper around a filesystem representation with an
+// independent working directory. When fs is afero.MemMapFs, or a wrapper from
+// this package around it, such as CaseInsensitiveFs, dir is resolved
+// lexically against the root, like NewVirtualFsx, so that the host's working
+// directory doesn't leak into it. On any other filesystem, such as afero.OsFs
+// or afero.ReadOnlyFs, dir is resolved with filepath.Abs, against the working
+// directory of the process. Use NewVirtualFsx to reject dirs that refer to a
+// location above the root instead.
 func NewFsx(dir string, fs afero.Fs) *Fsx {
+	return &Fsx{
+		dir: newFsxDir(dir, fs),
+		fs:  fs,
+	}
+}
+
+// newFsxDir resolves the working directory given to NewFsx.
+func newFsxDir(dir string, fs afero.Fs) string {
+	if isVirtualFs(fs) {
+		return virtualRoot(dir)
+	}
 	pwd, _ := filepath.Abs(dir)
+	return pwd
+}
+
+// isVirtualFs determines if fs is known to be independent of the host
+// filesystem, by its type. The wrappers in this package keep the paths of the
+// filesystem that they wrap, so they are unwrapped. The filesystem itself is
+// never called, so that creating an Fsx doesn't depend on, or change, its
+// contents.
+func isVirtualFs(fs afero.Fs) bool {
+	switch fs := fs.(type) {
+	case *afero.MemMapFs:
+		return true
+	case *CaseInsensitiveFs:
+		return isVirtualFs(fs.fs)
+	case *CrashFs:
+		return isVirtualFs(fs.fs)
+	case *FaultFs:
+		return isVirtualFs(fs.fs)
+	case *SymlinkFs:
+		return isVirtualFs(fs.fs)
+	case *MountFs:
+		return isVirtualFs(fs.rootFs())
+	default:
+		return false
+	}
+}
+
+// isHostFs determines if fs is the host filesystem, afero.OsFs, or a wrapper
+// from this package around it, by its type.
+func isHostFs(fs afero.Fs) bool {
+	switch fs := fs.(type) {
+	case *afero.OsFs:
+		return true
+	case *CaseInsensitiveFs:
+		return isHostFs(fs.fs)
+	case *CrashFs:
+		return isHostFs(fs.fs)
+	case *FaultFs:
+		return isHostFs(fs.fs)
+	case *SymlinkFs:
+		return isHostFs(fs.fs)
+	case *MountFs:
+		return isHostFs(fs.rootFs())
+	default:
+		return false
+	}
+}
+
+// NewVirtualFsx creates a wrapper around a filesystem representation with an
+// independent working directory, which is resolved purely lexically against
+// the root of the filesystem. The working directory of the process is never
+// consulted, so the result is the same no matter where the program runs. Use
+// it for virtual filesystems, such as afero.MemMapFs.
+//
+// An empty dir is the root. If dir contains a NUL byte, or is a relative path
+// that refers to a location above the root, such as "../tmp", the error will
+// be of type *os.PathError.
+func NewVirtualFsx(dir string, fs afero.Fs) (*Fsx, error) {
+	pwd, err := virtualAbs(dir)
+	if err != nil {
+		return nil, err
+	}
 	return &Fsx{
 		dir: pwd,
 		fs:  fs,
+	}, nil
+}
+
+// virtualAbs resolves dir lexically against the root of a virtual filesystem.
+func virtualAbs(dir string) (string, error) {
+	if strings.IndexByte(dir, 0) >= 0 {
+		return "", &os.PathError{Op: "chdir", Path: dir, Err: syscall.EINVAL}
+	}
+	if !isRooted(dir) && HostPathStyle.escapesRoot("", dir) {
+		return "", &os.PathError{Op: "chdir", Path: dir, Err: syscall.EINVAL}
+	}
+	return virtualRoot(dir), nil
+}
+
+// isRooted determines if dir starts at the root, or at a volume, instead of
+// the working directory.
+func isRooted(dir string) bool {
+	return filepath.VolumeName(dir) != "" || strings.HasPrefix(dir, `/`) || strings.HasPrefix(dir, `\`)
+}
+
+// virtualRoot resolves dir lexically against the root of a virtual
+// filesystem, clamping any references above the root to the root.
+func virtualRoot(dir string) string {
+	volume := filepath.VolumeName(dir)
+	root := volume + string(filepath.Separator)
+	return filepath.Clean(filepath.Join(root, dir[len(volume):]))
+}
+
+// fork creates a copy of the filesystem with a separate working directory,
+// which is resolved against the current working directory.
+func (f *Fsx) fork(dir string) *Fsx {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return &Fsx{
+		dir:       f.resolveLocked(dir),
+		fs:        f.fs,
+		drives:    f.drives,
+		expansion: f.expansion,
+		style:     f.style,
+		jail:      f.jail,
+		policy:    f.policy,
+		recorder:  f.recorder,
+	}
+}
+
+// SetPathExpansion enables expanding a leading tilde and environment variables
+// in every path passed to the filesystem, see PathExpansion for details.
+// Pass nil to disable expansion, which is the default.
+func (f *Fsx) SetPathExpansion(expansion *PathExpansion) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.expansion = expansion
+}
+
+// SetPathStyle changes how paths are interpreted, see PathStyle for details.
+// The working directories are converted to the new style, so that they refer
+// to the same directory in the wrapped filesystem when possible.
+func (f *Fsx) SetPathStyle(style PathStyle) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	from := f.style
+	f.style = style
+	f.dir = style.convert(from, f.dir)
+	if f.oldDir != "" {
+		f.oldDir = style.convert(from, f.oldDir)
+	}
+	dirStack := make([]string, len(f.dirStack))
+	for i, dir := range f.dirStack {
+		dirStack[i] = style.convert(from, dir)
 	}
+	f.dirStack = dirStack
+	f.drives = nil
+	f.rememberDrive(f.dir)
+}
+
+// SetWritePolicy restricts which paths can be modified, see WritePolicy for
+// details. The directories in the policy are resolved against the current
+// working directory. Pass nil to allow writing to every path, which is the
+// default.
+func (f *Fsx) SetWritePolicy(policy *WritePolicy) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.policy = policy.resolve(f.resolverLocked())
+}
+
+// SetRecorder records every call made through the filesystem, including
+// those that fail, see Recorder for details. Pass nil to stop recording, which
+// is the default.
+func (f *Fsx) SetRecorder(recorder *Recorder) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.recorder = recorder
+}
+
+// PathStyle returns how paths are interpreted.
+func (f *Fsx) PathStyle() PathStyle {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.style
 }
 
 // Getwd returns a rooted path name corresponding to the current directory.
 func (f *Fsx) Getwd() string {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
 	return f.dir
 }
 
 // Chdir changes the current working directory to the named directory.
-func (f *Fsx) Chdir(dir string) {
-	f.dir = f.Abs(dir)
+// If the directory does not exist, or is not a directory, the working directory
+// is not changed and the error will be of type *os.PathError.
+func (f *Fsx) Chdir(dir string) error {
+	r := f.resolver()
+	newDir, err := f.checkDir(r, dir)
+	if err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	if f.dir != r.dir {
+		// Changed while it was checked, so resolve dir against the new working directory
+		f.mu.Unlock()
+		return f.Chdir(dir)
+	}
+	f.setwd(newDir)
+	f.mu.Unlock()
+	return nil
+}
+
+// ChdirUnchecked changes the current working directory to the named directory
+// without checking that it exists. Use when the directory is created later.
+func (f *Fsx) ChdirUnchecked(dir string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var err error
+	r := f.resolverLocked()
+	defer r.record(Operation{Op: "ChdirUnchecked", Path: dir}, time.Now(), &err)
+	f.setwd(r.abs(dir))
+}
+
+// ChdirPrevious changes the current working directory to the previous
+// working directory, like "cd -".
+func (f *Fsx) ChdirPrevious() error {
+	f.mu.RLock()
+	oldDir, r := f.oldDir, f.resolverLocked()
+	f.mu.RUnlock()
+
+	if oldDir == "" {
+		return &os.PathError{Op: "chdir", Path: "-", Err: ErrNoPreviousDir}
+	}
+	if err := f.checkSavedDir(r, oldDir); err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	if f.oldDir != oldDir {
+		// Changed while it was checked, so check the new previous directory
+		f.mu.Unlock()
+		return f.ChdirPrevious()
+	}
+	f.setwd(oldDir)
+	f.mu.Unlock()
+	return nil
+}
+
+// Oldwd returns the previous working directory, like OLDPWD. It is empty when
+// the working directory has not been changed.
+func (f *Fsx) Oldwd() string {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.oldDir
+}
+
+// Pushd saves the current working directory on the directory stack and then
+// changes the working directory to the named directory. When the directory is
+// invalid, the working directory and the directory stack are not changed.
+func (f *Fsx) Pushd(dir string) error {
+	r := f.resolver()
+	newDir, err := f.checkDir(r, dir)
+	if err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	if f.dir != r.dir {
+		// Changed while it was checked, so resolve dir against the new working directory
+		f.mu.Unlock()
+		return f.Pushd(dir)
+	}
+	f.dirStack = append(f.dirStack, f.dir)
+	f.setwd(newDir)
+	f.mu.Unlock()
+	return nil
+}
+
+// Popd removes the top directory from the directory stack and changes the
+// working directory to it. When the directory stack is empty, ErrDirStackEmpty
+// is returned.
+func (f *Fsx) Popd() error {
+	f.mu.RLock()
+	depth, r := len(f.dirStack), f.resolverLocked()
+	var newDir string
+	if depth > 0 {
+		newDir = f.dirStack[depth-1]
+	}
+	f.mu.RUnlock()
+
+	if depth == 0 {
+		return ErrDirStackEmpty
+	}
+	if err := f.checkSavedDir(r, newDir); err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	if len(f.dirStack) != depth || f.dirStack[depth-1] != newDir {
+		// Changed while it was checked, so check the new top directory
+		f.mu.Unlock()
+		return f.Popd()
+	}
+	f.dirStack = f.dirStack[:depth-1]
+	f.setwd(newDir)
+	f.mu.Unlock()
+	return nil
+}
+
+// Dirs returns the current working directory followed by the directory stack,
+// from the most recently pushed directory to the oldest, like "dirs".
+func (f *Fsx) Dirs() []string {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	dirs := make([]string, 0, len(f.dirStack)+1)
+	dirs = append(dirs, f.dir)
+	for i := len(f.dirStack) - 1; i >= 0; i-- {
+		dirs = append(dirs, f.dirStack[i])
+	}
+	return dirs
+}
+
+// InDir changes the working directory to the named directory, calls fn, and
+// then changes back to the original working directory, even if fn panics.
+// Since the working directory is shared, other goroutines using the same Fsx
+// also see the change while fn runs.
+func (f *Fsx) InDir(dir string, fn func() error) error {
+	pwd := f.Getwd()
+	if err := f.Chdir(dir); err != nil {
+		return err
+	}
+	defer f.restoreDir(pwd)
+
+	return fn()
+}
+
+// restoreDir changes the working directory back to dir, a working directory
+// returned by Getwd, without resolving it again. It is recorded as "Chdir".
+func (f *Fsx) restoreDir(dir string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var err error
+	r := f.resolverLocked()
+	defer r.record(Operation{Op: "Chdir", Path: dir, AbsPath: dir}, time.Now(), &err)
+	f.setwd(dir)
+}
+
+// checkDir resolves dir with r and validates that it is an existing
+// directory. It doesn't hold mu while the wrapped Fs is called, so that other
+// paths can be resolved meanwhile.
+func (f *Fsx) checkDir(r pathResolver, dir string) (newDir string, err error) {
+	defer r.record(Operation{Op: "Chdir", Path: dir}, time.Now(), &err)
+	realDir, err := r.realPath("chdir", dir)
+	if err != nil {
+		return "", err
+	}
+	if err := f.statDir(dir, realDir); err != nil {
+		return "", err
+	}
+	return r.abs(dir), nil
+}
+
+// checkSavedDir validates that a working directory saved by the Fsx, such as
+// the previous working directory, is still an existing directory. The saved
+// directory is already resolved, so it isn't expanded again. Like checkDir,
+// it doesn't hold mu.
+func (f *Fsx) checkSavedDir(r pathResolver, dir string) (err error) {
+	defer r.record(Operation{Op: "Chdir", Path: dir, AbsPath: dir}, time.Now(), &err)
+	return f.statDir(dir, r.jail.realPath(r.style.toHost(dir)))
+}
+
+// statDir validates that realDir, the path in the wrapped Fs for dir, is an
+// existing directory.
+func (f *Fsx) statDir(dir string, realDir string) error {
+	fi, err := f.fs.Stat(realDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return &os.PathError{Op: "chdir", Path: dir, Err: syscall.ENOENT}
+		}
+		return &os.PathError{Op: "chdir", Path: dir, Err: underlyingError(err)}
+	}
+	if !fi.IsDir() {
+		return &os.PathError{Op: "chdir", Path: dir, Err: syscall.ENOTDIR}
+	}
+	return nil
+}
+
+// setwd changes the working directory and remembers the previous one.
+// The caller must hold mu.
+func (f *Fsx) setwd(dir string) {
+	f.oldDir = f.dir
+	f.dir = dir
+	f.rememberDrive(dir)
+}
+
+// rememberDrive records dir as the working directory of its drive, when
+// using Windows paths. The caller must hold mu.
+func (f *Fsx) rememberDrive(dir string) {
+	volume := f.style.volumeName(dir)
+	if volume == "" || f.style.isUNC(volume) {
+		return
+	}
+
+	drives := make(map[string]string, len(f.drives)+1)
+	for k, v := range f.drives {
+		drives[k] = v
+	}
+	drives[f.style.driveKey(volume)] = dir
+	f.drives = drives
 }
 
 // Chown changes the uid and gid of the named file.
-func (f *Fsx) Chown(name string, uid, gid int) error {
-	return f.fs.Chown(name, uid, gid)
+func (f *Fsx) Chown(name string, uid, gid int) (err error) {
+	r := f.resolver()
+	defer r.record(Operation{Op: "Chown", Path: name}, time.Now(), &err)
+	path, err := r.writePath("chown", name)
+	if err != nil {
+		return err
+	}
+	return r.wrapError(f.fs.Chown(path, uid, gid))
 }
 
 // Abs returns an absolute representation of path. If the path is not absolute
@@ -48,34 +502,162 @@ func (f *Fsx) Chown(name string, uid, gid int) error {
 // absolute path. The absolute path name for a given file is not guaranteed to
 // be unique. Abs calls Clean on the result.
 func (f *Fsx) Abs(path string) string {
-	var fullPath string
-	if filepath.IsAbs(path) {
-		fullPath = path
-	} else {
-		prefix := f.dir
-		// On Windows /foo resolves to DRIVEPATH:\foo, so treat anything that starts with a slash as absolute that just needs cleaning up
-		if strings.HasPrefix(path, `/`) || strings.HasPrefix(path, `\`) {
-			prefix, _ = filepath.Abs("/")
+	return f.resolver().abs(path)
+}
+
+// resolver returns a pathResolver that resolves paths like Abs. The working
+// directory is captured once, so that every path resolved by it is
+// consistent, even if Chdir is called concurrently.
+func (f *Fsx) resolver() pathResolver {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.resolverLocked()
+}
+
+// resolverLocked returns a pathResolver. The caller must hold mu.
+func (f *Fsx) resolverLocked() pathResolver {
+	return pathResolver{fs: f.fs, dir: f.dir, drives: f.drives, expansion: f.expansion, style: f.style, jail: f.jail, policy: f.policy, recorder: f.recorder}
+}
+
+// resolveLocked resolves path like Abs. The caller must hold mu.
+func (f *Fsx) resolveLocked(path string) string {
+	return f.resolverLocked().abs(path)
+}
+
+// pathResolver resolves paths against a fixed working directory.
+type pathResolver struct {
+	// fs is the wrapped Fs, used to resolve symbolic links for the write
+	// policy.
+	fs afero.Fs
+
+	dir       string
+	drives    map[string]string
+	expansion *PathExpansion
+	style     PathStyle
+	jail      *Jail
+	policy    *WritePolicy
+	recorder  *Recorder
+}
+
+// abs returns an absolute representation of path.
+func (r pathResolver) abs(path string) string {
+	return r.style.resolve(r.dir, r.drives, r.expansion.expand(r.style, path))
+}
+
+// realPath returns the path in the wrapped Fs for path. When the path escapes
+// a strict jail, the error is a *os.PathError for the operation op.
+func (r pathResolver) realPath(op string, path string) (string, error) {
+	expanded := r.expansion.expand(r.style, path)
+	if r.jail != nil && r.jail.Strict && r.style.escapesRoot(r.dir, expanded) {
+		return "", &os.PathError{Op: op, Path: path, Err: ErrJailEscape}
+	}
+	return r.jail.realPath(r.style.toHost(r.style.resolve(r.dir, r.drives, expanded))), nil
+}
+
+// checkWrite returns an error when the write policy doesn't allow writing to
+// path, as given or with its symbolic links resolved.
+func (r pathResolver) checkWrite(op string, path string) error {
+	abs := r.abs(path)
+	return r.policy.check(r.style, op, path, abs, func() (string, bool) {
+		return r.evalSymlinks(abs, followsLink(op))
+	})
+}
+
+// writePath returns the path in the wrapped Fs for path, like realPath, after
+// checking that the write policy allows writing to it.
+func (r pathResolver) writePath(op string, path string) (string, error) {
+	if err := r.checkWrite(op, path); err != nil {
+		return "", err
+	}
+	return r.realPath(op, path)
+}
+
+// translated determines if paths in the wrapped Fs are different from the
+// paths seen by the caller.
+func (r pathResolver) translated() bool {
+	return r.jail != nil || !r.style.native()
+}
+
+// virtualPath converts a path in the wrapped Fs to the path seen by the
+// caller. The bool reports whether the path is visible to the caller.
+func (r pathResolver) virtualPath(path string) (string, bool) {
+	path, ok := r.jail.virtualPath(path)
+	if !ok {
+		return "", false
+	}
+	return r.style.fromHost(path)
+}
+
+// wrapError reports paths in err as seen by the caller.
+func (r pathResolver) wrapError(err error) error {
+	if err == nil || !r.translated() {
+		return err
+	}
+
+	switch e := err.(type) {
+	case *os.PathError:
+		if path, ok := r.virtualPath(e.Path); ok {
+			return &os.PathError{Op: e.Op, Path: path, Err: e.Err}
+		}
+	case *os.LinkError:
+		oldPath, oldOk := r.virtualPath(e.Old)
+		newPath, newOk := r.virtualPath(e.New)
+		if oldOk && newOk {
+			return &os.LinkError{Op: e.Op, Old: oldPath, New: newPath, Err: e.Err}
 		}
-		fullPath = filepath.Join(prefix, path)
 	}
-	return filepath.Clean(fullPath)
+	return err
+}
+
+// wrapFile reports the file name and any error as seen by the caller.
+func (r pathResolver) wrapFile(file afero.File, name string, err error) (afero.File, error) {
+	if !r.translated() {
+		return file, err
+	}
+	if err != nil {
+		return nil, r.wrapError(err)
+	}
+	return &namedFile{File: file, name: name}, nil
+}
+
+// namedFile reports a different name for a file, such as the name relative to
+// a jail.
+type namedFile struct {
+	afero.File
+
+	// name of the file seen by the caller.
+	name string
+}
+
+func (f *namedFile) Name() string {
+	return f.name
 }
 
 // Create creates or truncates the named file. If the file already exists,
 // it is truncated. If the file does not exist, it is created with mode 0666
 // (before umask). If successful, methods on the returned File can
 // be used for I/O; the associated file descriptor has mode O_RDWR.
-func (f *Fsx) Create(name string) (afero.File, error) {
-	name = f.Abs(name)
-	return f.fs.Create(name)
+func (f *Fsx) Create(name string) (file afero.File, err error) {
+	r := f.resolver()
+	defer r.record(Operation{Op: "Create", Path: name, Flag: os.O_RDWR | os.O_CREATE | os.O_TRUNC, Perm: 0666}, time.Now(), &err)
+	path, err := r.writePath("open", name)
+	if err != nil {
+		return nil, err
+	}
+	file, err = f.fs.Create(path)
+	return r.wrapFile(file, r.abs(name), err)
 }
 
 // Mkdir creates a new directory with the specified name and permission
 // bits (before umask).
-func (f *Fsx) Mkdir(name string, perm os.FileMode) error {
-	name = f.Abs(name)
-	return f.fs.Mkdir(name, perm)
+func (f *Fsx) Mkdir(name string, perm os.FileMode) (err error) {
+	r := f.resolver()
+	defer r.record(Operation{Op: "Mkdir", Path: name, Perm: perm}, time.Now(), &err)
+	path, err := r.writePath("mkdir", name)
+	if err != nil {
+		return err
+	}
+	return r.wrapError(f.fs.Mkdir(path, perm))
 }
 
 // MkdirAll creates a directory named path,
@@ -85,9 +667,14 @@ func (f *Fsx) Mkdir(name string, perm os.FileMode) error {
 // directories that MkdirAll creates.
 // If path is already a directory, MkdirAll does nothing
 // and returns nil.
-func (f *Fsx) MkdirAll(path string, perm os.FileMode) error {
-	path = f.Abs(path)
-	return f.fs.MkdirAll(path, perm)
+func (f *Fsx) MkdirAll(path string, perm os.FileMode) (err error) {
+	r := f.resolver()
+	defer r.record(Operation{Op: "MkdirAll", Path: path, Perm: perm}, time.Now(), &err)
+	realPath, err := r.writePath("mkdir", path)
+	if err != nil {
+		return err
+	}
+	return r.wrapError(f.fs.MkdirAll(realPath, perm))
 }
 
 // OpenFile is the generalized open call; most users will use Open
@@ -95,45 +682,89 @@ func (f *Fsx) MkdirAll(path string, perm os.FileMode) error {
 // (O_RDONLY etc.). If the file does not exist, and the O_CREATE flag
 // is passed, it is created with mode perm (before umask). If successful,
 // methods on the returned File can be used for I/O.
-func (f *Fsx) Open(name string) (afero.File, error) {
-	name = f.Abs(name)
-	return f.fs.Open(name)
+func (f *Fsx) Open(name string) (file afero.File, err error) {
+	r := f.resolver()
+	defer r.record(Operation{Op: "Open", Path: name, Flag: os.O_RDONLY}, time.Now(), &err)
+	path, err := r.realPath("open", name)
+	if err != nil {
+		return nil, err
+	}
+	file, err = f.fs.Open(path)
+	return r.wrapFile(file, r.abs(name), err)
 }
 
 // OpenFile opens a file using the given flags and the given mode.
-func (f *Fsx) OpenFile(name string, flag int, perm os.FileMode) (afero.File, error) {
-	name = f.Abs(name)
-	return f.fs.OpenFile(name, flag, perm)
+func (f *Fsx) OpenFile(name string, flag int, perm os.FileMode) (file afero.File, err error) {
+	r := f.resolver()
+	defer r.record(Operation{Op: "OpenFile", Path: name, Flag: flag, Perm: perm}, time.Now(), &err)
+	resolve := r.realPath
+	if isWriteFlag(flag) {
+		resolve = r.writePath
+	}
+	path, err := resolve("open", name)
+	if err != nil {
+		return nil, err
+	}
+	file, err = f.fs.OpenFile(path, flag, perm)
+	return r.wrapFile(file, r.abs(name), err)
 }
 
 // Remove removes the named file or (empty) directory.
-func (f *Fsx) Remove(name string) error {
-	name = f.Abs(name)
-	return f.fs.Remove(name)
+func (f *Fsx) Remove(name string) (err error) {
+	r := f.resolver()
+	defer r.record(Operation{Op: "Remove", Path: name}, time.Now(), &err)
+	path, err := r.writePath("remove", name)
+	if err != nil {
+		return err
+	}
+	return r.wrapError(f.fs.Remove(path))
 }
 
 // RemoveAll removes path and any children it contains.
 // It removes everything it can but returns the first error
 // it encounters. If the path does not exist, RemoveAll
 // returns nil (no error).
-func (f *Fsx) RemoveAll(path string) error {
-	path = f.Abs(path)
-	return f.fs.RemoveAll(path)
+func (f *Fsx) RemoveAll(path string) (err error) {
+	r := f.resolver()
+	defer r.record(Operation{Op: "RemoveAll", Path: path}, time.Now(), &err)
+	realPath, err := r.writePath("removeall", path)
+	if err != nil {
+		return err
+	}
+	return r.wrapError(f.fs.RemoveAll(realPath))
 }
 
 // Rename renames (moves) oldpath to newpath.
 // If newpath already exists and is not a directory, Rename replaces it.
 // OS-specific restrictions may apply when oldpath and newpath are in different directories.
-func (f *Fsx) Rename(oldname, newname string) error {
-	oldname = f.Abs(oldname)
-	newname = f.Abs(newname)
-	return f.fs.Rename(oldname, newname)
+func (f *Fsx) Rename(oldname, newname string) (err error) {
+	// Resolve both paths against the same working directory, even if Chdir is called concurrently
+	r := f.resolver()
+	defer r.record(Operation{Op: "Rename", Path: oldname, NewPath: newname}, time.Now(), &err)
+	if err := r.checkWrite("rename", oldname); err != nil {
+		return err
+	}
+	if err := r.checkWrite("rename", newname); err != nil {
+		return err
+	}
+	oldpath, oldErr := r.realPath("rename", oldname)
+	newpath, newErr := r.realPath("rename", newname)
+	if oldErr != nil || newErr != nil {
+		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: ErrJailEscape}
+	}
+	return r.wrapError(f.fs.Rename(oldpath, newpath))
 }
 
 // Stat returns a FileInfo describing the named file.
-func (f *Fsx) Stat(name string) (os.FileInfo, error) {
-	name = f.Abs(name)
-	return f.fs.Stat(name)
+func (f *Fsx) Stat(name string) (fi os.FileInfo, err error) {
+	r := f.resolver()
+	defer r.recordResult(Operation{Op: "Stat", Path: name}, time.Now(), &err, func() string { return describeFileInfo(fi) })
+	path, err := r.realPath("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	fi, err = f.fs.Stat(path)
+	return fi, r.wrapError(err)
 }
 
 // The name of this FileSystem.
@@ -147,14 +778,145 @@ func (f *Fsx) Name() string {
 //
 // A different subset of the mode bits are used, depending on the
 // operating system.
-func (f *Fsx) Chmod(name string, mode os.FileMode) error {
-	name = f.Abs(name)
-	return f.fs.Chmod(name, mode)
+func (f *Fsx) Chmod(name string, mode os.FileMode) (err error) {
+	r := f.resolver()
+	defer r.record(Operation{Op: "Chmod", Path: name, Perm: mode}, time.Now(), &err)
+	path, err := r.writePath("chmod", name)
+	if err != nil {
+		return err
+	}
+	return r.wrapError(f.fs.Chmod(path, mode))
 }
 
 // Chtimes changes the access and modification times of the named
 // file, similar to the Unix utime() or utimes() functions.
-func (f *Fsx) Chtimes(name string, atime time.Time, mtime time.Time) error {
-	name = f.Abs(name)
-	return f.fs.Chtimes(name, atime, mtime)
+func (f *Fsx) Chtimes(name string, atime time.Time, mtime time.Time) (err error) {
+	r := f.resolver()
+	defer r.record(Operation{Op: "Chtimes", Path: name}, time.Now(), &err)
+	path, err := r.writePath("chtimes", name)
+	if err != nil {
+		return err
+	}
+	return r.wrapError(f.fs.Chtimes(path, atime, mtime))
+}
+
+// LstatIfPossible returns a FileInfo describing the named file. If the file is
+// a symbolic link, the returned FileInfo describes the link and not its target.
+// The returned bool reports whether Lstat was called on the wrapped Fs, if it
+// doesn't support Lstat then Stat is used instead.
+func (f *Fsx) LstatIfPossible(name string) (fi os.FileInfo, lstatCalled bool, err error) {
+	r := f.resolver()
+	defer r.recordResult(Operation{Op: "LstatIfPossible", Path: name}, time.Now(), &err, func() string { return describeFileInfo(fi) })
+	path, err := r.realPath("lstat", name)
+	if err != nil {
+		return nil, false, err
+	}
+	if lstater, ok := f.fs.(afero.Lstater); ok {
+		fi, lstatCalled, err = lstater.LstatIfPossible(path)
+		return fi, lstatCalled, r.wrapError(err)
+	}
+	fi, err = f.fs.Stat(path)
+	return fi, false, r.wrapError(err)
+}
+
+// SymlinkIfPossible creates newname as a symbolic link to oldname.
+// Relative paths for both the link and its target are resolved against the
+// current working directory.
+// If the wrapped Fs doesn't support symbolic links, the error will be a
+// *os.LinkError wrapping afero.ErrNoSymlink.
+func (f *Fsx) SymlinkIfPossible(oldname, newname string) (err error) {
+	r := f.resolver()
+	defer r.record(Operation{Op: "SymlinkIfPossible", Path: oldname, NewPath: newname}, time.Now(), &err)
+	if err := r.checkWrite("symlink", newname); err != nil {
+		return err
+	}
+	oldpath, oldErr := r.realPath("symlink", oldname)
+	newpath, newErr := r.realPath("symlink", newname)
+	if oldErr != nil || newErr != nil {
+		return &os.LinkError{Op: "symlink", Old: oldname, New: newname, Err: ErrJailEscape}
+	}
+	if linker, ok := f.fs.(afero.Linker); ok {
+		return r.wrapError(linker.SymlinkIfPossible(oldpath, newpath))
+	}
+	return &os.LinkError{Op: "symlink", Old: r.abs(oldname), New: r.abs(newname), Err: afero.ErrNoSymlink}
+}
+
+// readlinkStored returns the destination of the named symbolic link exactly as
+// it is stored in the wrapped Fs, unlike ReadlinkIfPossible, which converts it
+// for the caller.
+func (f *Fsx) readlinkStored(name string) (dest string, err error) {
+	r := f.resolver()
+	defer r.recordResult(Operation{Op: "ReadlinkIfPossible", Path: name}, time.Now(), &err, func() string { return dest })
+	path, err := r.realPath("readlink", name)
+	if err != nil {
+		return "", err
+	}
+	reader, ok := f.fs.(afero.LinkReader)
+	if !ok {
+		return "", &os.PathError{Op: "readlink", Path: r.abs(name), Err: afero.ErrNoReadlink}
+	}
+	dest, err = reader.ReadlinkIfPossible(path)
+	return dest, r.wrapError(err)
+}
+
+// symlinkStored creates newname as a symbolic link to target, a destination
+// returned by readlinkStored, which is stored as-is in the wrapped Fs. Unlike
+// SymlinkIfPossible, a relative target isn't resolved against the working
+// directory, so it stays relative to the directory of the link.
+func (f *Fsx) symlinkStored(target, newname string) (err error) {
+	r := f.resolver()
+	defer r.record(Operation{Op: "SymlinkIfPossible", Path: target, AbsPath: target, NewPath: newname}, time.Now(), &err)
+	if err := r.checkWrite("symlink", newname); err != nil {
+		return err
+	}
+	newpath, err := r.realPath("symlink", newname)
+	if err != nil {
+		return &os.LinkError{Op: "symlink", Old: target, New: newname, Err: ErrJailEscape}
+	}
+	if linker, ok := f.fs.(afero.Linker); ok {
+		return r.wrapError(linker.SymlinkIfPossible(target, newpath))
+	}
+	return &os.LinkError{Op: "symlink", Old: target, New: r.abs(newname), Err: afero.ErrNoSymlink}
+}
+
+// ReadlinkIfPossible returns the destination of the named symbolic link.
+// If the wrapped Fs doesn't support reading symbolic links, the error will be a
+// *os.PathError wrapping afero.ErrNoReadlink.
+//
+// When the Fsx is jailed, absolute targets are returned relative to the jail,
+// and targets outside of the jail are rejected with ErrJailEscape. Targets are
+// returned in the PathStyle of the Fsx when possible.
+func (f *Fsx) ReadlinkIfPossible(name string) (dest string, err error) {
+	r := f.resolver()
+	defer r.recordResult(Operation{Op: "ReadlinkIfPossible", Path: name}, time.Now(), &err, func() string { return dest })
+	path, err := r.realPath("readlink", name)
+	if err != nil {
+		return "", err
+	}
+	reader, ok := f.fs.(afero.LinkReader)
+	if !ok {
+		return "", &os.PathError{Op: "readlink", Path: r.abs(name), Err: afero.ErrNoReadlink}
+	}
+
+	target, err := reader.ReadlinkIfPossible(path)
+	if err != nil || !r.translated() {
+		return target, r.wrapError(err)
+	}
+
+	if filepath.IsAbs(target) {
+		virtualTarget, ok := r.virtualPath(target)
+		if ok {
+			return virtualTarget, nil
+		}
+		if r.jail != nil {
+			return "", &os.PathError{Op: "readlink", Path: r.abs(name), Err: ErrJailEscape}
+		}
+		return target, nil
+	}
+
+	// Only allow relative targets that stay inside the jail
+	if _, ok := r.jail.virtualPath(filepath.Join(filepath.Dir(path), target)); !ok {
+		return "", &os.PathError{Op: "readlink", Path: r.abs(name), Err: ErrJailEscape}
+	}
+	return strings.Replace(filepath.ToSlash(target), "/", r.style.separator(), -1), nil
 }
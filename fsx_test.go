@@ -1,10 +1,13 @@
 package aferox
 
 import (
+	"errors"
+	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
 	"runtime"
+	"sync"
 	"syscall"
 	"testing"
 	"time"
@@ -199,3 +202,271 @@ func TestFsx_Abs(t *testing.T) {
 	wantPath, _ := filepath.Abs("/test")
 	assert.Equal(t, wantPath, path)
 }
+
+func TestFsx_Symlink(t *testing.T) {
+	t.Run("osfs", func(t *testing.T) {
+		tmp, err := ioutil.TempDir("", "aferox")
+		require.NoError(t, err, "create temp directory failed")
+		defer os.RemoveAll(tmp)
+
+		f := NewFsx(tmp, afero.NewOsFs())
+		_, err = f.Create("target.txt")
+		require.NoError(t, err, "Create failed")
+
+		err = f.SymlinkIfPossible("target.txt", "link.txt")
+		require.NoError(t, err, "SymlinkIfPossible failed")
+
+		fi, lstatCalled, err := f.LstatIfPossible("link.txt")
+		require.NoError(t, err, "LstatIfPossible failed")
+		assert.True(t, lstatCalled, "expected Lstat to be called on the wrapped Fs")
+		assert.True(t, fi.Mode()&os.ModeSymlink != 0, "expected link.txt to be a symlink")
+
+		target, err := f.ReadlinkIfPossible("link.txt")
+		require.NoError(t, err, "ReadlinkIfPossible failed")
+		assert.Equal(t, filepath.Join(tmp, "target.txt"), target)
+	})
+
+	t.Run("memfs", func(t *testing.T) {
+		f := NewFsx("/home", afero.NewMemMapFs())
+		_, err := f.Create("target.txt")
+		require.NoError(t, err, "Create failed")
+
+		err = f.SymlinkIfPossible("target.txt", "link.txt")
+		require.Error(t, err)
+		var linkErr *os.LinkError
+		require.True(t, errors.As(err, &linkErr), "expected a *os.LinkError")
+		assert.Equal(t, afero.ErrNoSymlink, linkErr.Err)
+		assert.Equal(t, xplat("/home/target.txt"), linkErr.Old)
+		assert.Equal(t, xplat("/home/link.txt"), linkErr.New)
+
+		_, err = f.ReadlinkIfPossible("target.txt")
+		require.Error(t, err)
+		var pathErr *os.PathError
+		require.True(t, errors.As(err, &pathErr), "expected a *os.PathError")
+		assert.Equal(t, afero.ErrNoReadlink, pathErr.Err)
+
+		fi, _, err := f.LstatIfPossible("target.txt")
+		require.NoError(t, err, "LstatIfPossible failed")
+		assert.Equal(t, "target.txt", fi.Name())
+	})
+}
+
+func TestFsx_ConcurrentChdir(t *testing.T) {
+	// Run with -race to detect unsynchronized access to the working directory
+	f := NewAferox("/home", afero.NewMemMapFs())
+	dirs := []string{"/home", "/tmp", "/home/me"}
+	for _, dir := range dirs {
+		require.NoError(t, f.MkdirAll(dir, 0755), "MkdirAll failed")
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 500; i++ {
+			assert.NoError(t, f.Chdir(dirs[i%len(dirs)]), "Chdir failed")
+		}
+	}()
+
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				name := fmt.Sprintf("file-%d-%d.txt", i, j)
+				file, err := f.Fs.Create(name)
+				if !assert.NoError(t, err, "Create failed") {
+					return
+				}
+				file.Close()
+
+				// The file was created in whichever directory was current when Create was called
+				created := file.Name()
+				_, err = f.Fs.Open(created)
+				assert.NoError(t, err, "Open failed")
+
+				err = f.Fs.Rename(created, created+".bak")
+				assert.NoError(t, err, "Rename failed")
+				f.Abs(name)
+				f.Getwd()
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	assert.Contains(t, dirs, f.Getwd())
+}
+
+// slowStatFs blocks Stat for the named file until release is closed.
+type slowStatFs struct {
+	afero.Fs
+	name    string
+	called  chan struct{}
+	release chan struct{}
+}
+
+func (fs *slowStatFs) Stat(name string) (os.FileInfo, error) {
+	if name == fs.name {
+		close(fs.called)
+		<-fs.release
+	}
+	return fs.Fs.Stat(name)
+}
+
+func TestFsx_ChdirDoesNotBlockWhileChecking(t *testing.T) {
+	fs := &slowStatFs{Fs: afero.NewMemMapFs(), name: xplat("/slow"), called: make(chan struct{}), release: make(chan struct{})}
+	f := NewFsx("/home", fs)
+	require.NoError(t, f.MkdirAll("/slow", 0755))
+
+	done := make(chan error)
+	go func() { done <- f.Chdir("/slow") }()
+	<-fs.called
+
+	resolved := make(chan string)
+	go func() { resolved <- f.Abs("porter.yaml") }()
+	select {
+	case path := <-resolved:
+		assert.Equal(t, xplat("/home/porter.yaml"), path)
+	case <-time.After(5 * time.Second):
+		t.Fatal("resolving a path waited for Chdir to stat the directory")
+	}
+
+	close(fs.release)
+	require.NoError(t, <-done)
+	assert.Equal(t, xplat("/slow"), f.Getwd())
+}
+
+func TestFsx_ChdirRelativeWhileChanged(t *testing.T) {
+	ops := map[string]func(f *Fsx, dir string) error{
+		"Chdir": (*Fsx).Chdir,
+		"Pushd": (*Fsx).Pushd,
+	}
+	for name, chdir := range ops {
+		t.Run(name, func(t *testing.T) {
+			fs := &slowStatFs{Fs: afero.NewMemMapFs(), name: xplat("/home/sub"), called: make(chan struct{}), release: make(chan struct{})}
+			f := NewFsx("/home", fs)
+			require.NoError(t, f.MkdirAll("/home/sub", 0755))
+			require.NoError(t, f.MkdirAll("/tmp/sub", 0755))
+
+			done := make(chan error)
+			go func() { done <- chdir(f, "sub") }()
+			<-fs.called
+			require.NoError(t, f.Chdir("/tmp"))
+
+			// The relative directory must be resolved against the working
+			// directory that it is applied to
+			close(fs.release)
+			require.NoError(t, <-done)
+			assert.Equal(t, xplat("/tmp/sub"), f.Getwd())
+		})
+	}
+}
+
+func TestFsx_RenameUsesSingleWorkingDirectory(t *testing.T) {
+	f := NewFsx("/home", afero.NewMemMapFs())
+	require.NoError(t, f.MkdirAll("/home", 0755), "MkdirAll failed")
+	require.NoError(t, f.MkdirAll("/tmp", 0755), "MkdirAll failed")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				dir := "/home"
+				if j%2 == 0 {
+					dir = "/tmp"
+				}
+				assert.NoError(t, f.Chdir(dir), "Chdir failed")
+			}
+		}(i)
+	}
+
+	for i := 0; i < 100; i++ {
+		name := fmt.Sprintf("rename-%d.txt", i)
+		for _, dir := range []string{"/home", "/tmp"} {
+			_, err := f.Create(filepath.Join(dir, name))
+			require.NoError(t, err, "Create failed")
+		}
+
+		// Both names must resolve against the same directory, otherwise the
+		// file would be moved between /home and /tmp.
+		err := f.Rename(name, name+".bak")
+		require.NoError(t, err, "Rename failed")
+		for _, dir := range []string{"/home", "/tmp"} {
+			_, origErr := f.Stat(filepath.Join(dir, name))
+			_, bakErr := f.Stat(filepath.Join(dir, name+".bak"))
+			assert.True(t, (origErr == nil) != (bakErr == nil), "file was moved between directories in %s", dir)
+		}
+	}
+	wg.Wait()
+}
+
+func TestNewVirtualFsx(t *testing.T) {
+	testcases := []struct {
+		name    string
+		dir     string
+		wantDir string
+		wantErr bool
+	}{
+		{name: "empty", dir: "", wantDir: "/"},
+		{name: "relative", dir: "home/me", wantDir: "/home/me"},
+		{name: "dot", dir: "./home/../tmp", wantDir: "/tmp"},
+		{name: "absolute", dir: "/home/me/", wantDir: "/home/me"},
+		{name: "rooted parent", dir: "/../home", wantDir: "/home"},
+		{name: "relative parent", dir: "../home", wantErr: true},
+		{name: "nul", dir: "/home\x00", wantErr: true},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			f, err := NewVirtualFsx(tc.dir, afero.NewMemMapFs())
+			if tc.wantErr {
+				require.Error(t, err)
+				_, ok := err.(*os.PathError)
+				assert.True(t, ok, "expected a *os.PathError, got %T", err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, filepath.FromSlash(tc.wantDir), f.Getwd())
+		})
+	}
+}
+
+func TestNewFsx_Relative(t *testing.T) {
+	pwd, err := os.Getwd()
+	require.NoError(t, err)
+
+	// Relative directories are resolved lexically on a virtual filesystem
+	f := NewFsx("home", afero.NewMemMapFs())
+	assert.Equal(t, filepath.FromSlash("/home"), f.Getwd())
+
+	f = NewFsx("../tmp", afero.NewMemMapFs())
+	assert.Equal(t, filepath.FromSlash("/tmp"), f.Getwd())
+
+	f = NewFsx("", afero.NewMemMapFs())
+	assert.Equal(t, filepath.FromSlash("/"), f.Getwd())
+
+	// even when it contains the working directory of the process
+	fs := afero.NewMemMapFs()
+	require.NoError(t, fs.MkdirAll(pwd, 0755))
+	f = NewFsx("sub", fs)
+	assert.Equal(t, filepath.FromSlash("/sub"), f.Getwd())
+
+	// or is wrapped by this package
+	f = NewFsx("sub", NewCaseInsensitiveFs(afero.NewMemMapFs()))
+	assert.Equal(t, filepath.FromSlash("/sub"), f.Getwd())
+
+	// and against the working directory of the process on any other filesystem
+	f = NewFsx("sub", afero.NewReadOnlyFs(afero.NewOsFs()))
+	assert.Equal(t, filepath.Join(pwd, "sub"), f.Getwd())
+
+	f = NewFsx("sub", NewCaseInsensitiveFs(afero.NewOsFs()))
+	assert.Equal(t, filepath.Join(pwd, "sub"), f.Getwd())
+
+	f = NewFsx("sub", NewMountFs(afero.NewOsFs()))
+	assert.Equal(t, filepath.Join(pwd, "sub"), f.Getwd())
+
+	f = NewFsx("", afero.NewOsFs())
+	assert.Equal(t, pwd, f.Getwd())
+}
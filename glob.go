@@ -0,0 +1,282 @@
+package aferox
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// GlobOptions customize how Aferox.GlobWithOptions matches files.
+type GlobOptions struct {
+	// CaseInsensitive ignores case when matching names against the pattern.
+	CaseInsensitive bool
+}
+
+// Glob returns the names of all files matching pattern or nil if there is no
+// matching file. Relative patterns are resolved against the working directory,
+// and the returned names are relative too, absolute patterns return absolute
+// names. Names are returned in lexical order, and use the separator of the
+// PathStyle of the filesystem.
+//
+// In addition to the syntax of filepath.Match, the pattern supports:
+//
+//	**     as a whole path segment, matches zero or more directories
+//	{a,b}  matches any of the comma-separated alternatives, which may be
+//	       nested and may contain path separators
+//
+// The only possible returned error is filepath.ErrBadPattern, when pattern is
+// malformed. I/O errors, such as a directory that cannot be read, are ignored.
+// Use in place of filepath.Glob.
+func (a Aferox) Glob(pattern string) ([]string, error) {
+	return a.GlobWithOptions(pattern, GlobOptions{})
+}
+
+// GlobWithOptions returns the names of all files matching pattern, see Glob
+// for the pattern syntax.
+func (a Aferox) GlobWithOptions(pattern string, opts GlobOptions) ([]string, error) {
+	style := a.Fs.PathStyle()
+	g := globber{a: a, opts: opts, style: style, found: make(map[string]struct{})}
+	for _, p := range expandBraces(style, pattern) {
+		if err := g.glob(p); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(g.found) == 0 {
+		return nil, nil
+	}
+	matches := make([]string, 0, len(g.found))
+	for m := range g.found {
+		matches = append(matches, m)
+	}
+	sort.Strings(matches)
+	return matches, nil
+}
+
+// globber collects the files matching one or more patterns.
+type globber struct {
+	a     Aferox
+	opts  GlobOptions
+	style PathStyle
+	found map[string]struct{}
+}
+
+// glob finds the files matching a pattern without any braces.
+func (g globber) glob(pattern string) error {
+	// Split the pattern into the part we start from, and path segments to match
+	prefix := g.style.volumeName(pattern)
+	rest := pattern[len(prefix):]
+	if rest != "" && g.style.isSeparator(rest[0]) {
+		prefix += g.style.separator()
+	}
+	segments := g.style.split(rest)
+
+	for _, seg := range segments {
+		if _, err := filepath.Match(seg, ""); err != nil {
+			return err
+		}
+	}
+	if len(segments) == 0 {
+		return nil
+	}
+
+	g.match(g.a.Abs(prefix), prefix, segments)
+	return nil
+}
+
+// match finds the files under dir that match the remaining path segments.
+// The name for dir to report in the results is out, which is empty for the
+// working directory.
+func (g globber) match(dir string, out string, segments []string) {
+	if len(segments) == 0 {
+		// The working directory itself is never a match, like filepath.Glob
+		if out != "" {
+			g.found[out] = struct{}{}
+		}
+		return
+	}
+
+	seg, rest := segments[0], segments[1:]
+	if seg == "**" {
+		// Match zero directories, then each directory below dir.
+		// A trailing ** matches every file below dir too.
+		g.match(dir, out, rest)
+		for _, fi := range g.readDir(dir) {
+			if fi.IsDir() {
+				g.match(g.style.join(dir, fi.Name()), g.style.join(out, fi.Name()), segments)
+			} else if len(rest) == 0 {
+				g.found[g.style.join(out, fi.Name())] = struct{}{}
+			}
+		}
+		return
+	}
+
+	if !g.style.hasGlobMeta(seg) && !g.opts.CaseInsensitive {
+		path := g.style.join(dir, seg)
+		if _, err := g.a.Stat(path); err == nil {
+			g.match(path, g.style.join(out, seg), rest)
+		}
+		return
+	}
+
+	for _, fi := range g.readDir(dir) {
+		if !g.matchName(seg, fi.Name()) {
+			continue
+		}
+
+		path := g.style.join(dir, fi.Name())
+		if len(rest) > 0 && !fi.IsDir() {
+			// Only continue into directories, following symbolic links
+			if target, err := g.a.Stat(path); err != nil || !target.IsDir() {
+				continue
+			}
+		}
+		g.match(path, g.style.join(out, fi.Name()), rest)
+	}
+}
+
+// globMatch reports whether name matches the pattern, using the same syntax
+// as Glob, without accessing the filesystem. Both are split into path
+// segments with style, so the pattern must match the entire name.
+func globMatch(style PathStyle, pattern string, name string, opts GlobOptions) (bool, error) {
+	nameSegments := style.split(name)
+	for _, p := range expandBraces(style, pattern) {
+		patternSegments := style.split(p)
+		for _, seg := range patternSegments {
+			if _, err := filepath.Match(seg, ""); err != nil {
+				return false, err
+			}
+		}
+
+		g := globber{opts: opts, style: style}
+		if g.matchSegments(patternSegments, nameSegments) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// matchSegments determines if the path segments of a name match the segments
+// of a pattern.
+func (g globber) matchSegments(pattern []string, name []string) bool {
+	if len(pattern) == 0 {
+		return len(name) == 0
+	}
+
+	if pattern[0] == "**" {
+		// Try matching zero or more segments of the name
+		for i := 0; i <= len(name); i++ {
+			if g.matchSegments(pattern[1:], name[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+
+	if len(name) == 0 || !g.matchName(pattern[0], name[0]) {
+		return false
+	}
+	return g.matchSegments(pattern[1:], name[1:])
+}
+
+// readDir lists the directory, ignoring any errors.
+func (g globber) readDir(dir string) []os.FileInfo {
+	infos, err := g.a.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+	return infos
+}
+
+// matchName determines if a file name matches a single path segment.
+func (g globber) matchName(pattern string, name string) bool {
+	if g.opts.CaseInsensitive {
+		pattern = strings.ToLower(pattern)
+		name = strings.ToLower(name)
+	}
+	matched, _ := filepath.Match(pattern, name)
+	return matched
+}
+
+// hasGlobMeta determines if the path segment contains any of the special
+// characters recognized by filepath.Match. Backslash is an escape character,
+// except for Windows paths where it is a separator.
+func (s PathStyle) hasGlobMeta(segment string) bool {
+	magicChars := `*?[`
+	if !s.windows() {
+		magicChars = `*?[\`
+	}
+	return strings.ContainsAny(segment, magicChars)
+}
+
+// expandBraces returns every alternative of a pattern containing {a,b}
+// alternations, for example "{a,b}/{c,d}" is expanded to "a/c", "a/d", "b/c"
+// and "b/d". Braces without a matching closing brace are left as-is.
+func expandBraces(style PathStyle, pattern string) []string {
+	start, end := findBraces(style, pattern)
+	if start < 0 {
+		return []string{pattern}
+	}
+
+	prefix, body, suffix := pattern[:start], pattern[start+1:end], pattern[end+1:]
+	var expanded []string
+	for _, alt := range splitAlternatives(style, body) {
+		expanded = append(expanded, expandBraces(style, prefix+alt+suffix)...)
+	}
+	return expanded
+}
+
+// findBraces returns the index of the first opening brace in the pattern and
+// its matching closing brace, or -1 when there isn't a pair of braces.
+func findBraces(style PathStyle, pattern string) (int, int) {
+	escapes := !style.windows()
+	for start := 0; start < len(pattern); start++ {
+		if escapes && pattern[start] == '\\' {
+			start++
+			continue
+		}
+		if pattern[start] != '{' {
+			continue
+		}
+
+		depth := 0
+		for end := start; end < len(pattern); end++ {
+			switch {
+			case escapes && pattern[end] == '\\':
+				end++
+			case pattern[end] == '{':
+				depth++
+			case pattern[end] == '}':
+				depth--
+				if depth == 0 {
+					return start, end
+				}
+			}
+		}
+		return -1, -1
+	}
+	return -1, -1
+}
+
+// splitAlternatives splits the body of a brace expression on the commas that
+// are not nested inside another brace expression.
+func splitAlternatives(style PathStyle, body string) []string {
+	escapes := !style.windows()
+	var alts []string
+	depth, last := 0, 0
+	for i := 0; i < len(body); i++ {
+		switch {
+		case escapes && body[i] == '\\':
+			i++
+		case body[i] == '{':
+			depth++
+		case body[i] == '}':
+			depth--
+		case body[i] == ',' && depth == 0:
+			alts = append(alts, body[last:i])
+			last = i + 1
+		}
+	}
+	return append(alts, body[last:])
+}
@@ -0,0 +1,109 @@
+package aferox
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAferox_Glob(t *testing.T) {
+	a := NewAferox("/home", afero.NewMemMapFs())
+	writeTestFiles(t, a.Fs, map[string]string{
+		"/home/porter.yaml":                            "",
+		"/home/README.md":                              "",
+		"/home/charts/mysql/Chart.yaml":                "",
+		"/home/charts/mysql/templates/deployment.yaml": "",
+		"/home/charts/redis/Chart.yaml":                "",
+		"/home/scripts/install.sh":                     "",
+		"/home/scripts/helpers.ps1":                    "",
+		"/tmp/tmpfile.txt":                             "",
+	})
+
+	testcases := []struct {
+		name    string
+		pattern string
+		want    []string
+	}{
+		{name: "relative", pattern: "*.yaml", want: []string{"porter.yaml"}},
+		{name: "relative dir", pattern: "charts/*/Chart.yaml", want: []string{"charts/mysql/Chart.yaml", "charts/redis/Chart.yaml"}},
+		{name: "absolute", pattern: "/tmp/*.txt", want: []string{"/tmp/tmpfile.txt"}},
+		{name: "parent", pattern: "../tmp/*", want: []string{"../tmp/tmpfile.txt"}},
+		{name: "doublestar", pattern: "**/*.yaml", want: []string{
+			"charts/mysql/Chart.yaml", "charts/mysql/templates/deployment.yaml", "charts/redis/Chart.yaml", "porter.yaml"}},
+		{name: "doublestar middle", pattern: "charts/**/deployment.yaml", want: []string{"charts/mysql/templates/deployment.yaml"}},
+		{name: "doublestar trailing", pattern: "scripts/**", want: []string{"scripts", "scripts/helpers.ps1", "scripts/install.sh"}},
+		{name: "braces", pattern: "scripts/*.{sh,ps1}", want: []string{"scripts/helpers.ps1", "scripts/install.sh"}},
+		{name: "braces with separator", pattern: "{porter.yaml,charts/redis/*}", want: []string{"charts/redis/Chart.yaml", "porter.yaml"}},
+		{name: "nested braces", pattern: "charts/{mysql/{Chart,values}.yaml,redis/Chart.yaml}", want: []string{"charts/mysql/Chart.yaml", "charts/redis/Chart.yaml"}},
+		{name: "literal", pattern: "README.md", want: []string{"README.md"}},
+		{name: "case sensitive", pattern: "readme.md", want: nil},
+		{name: "no match", pattern: "*.json", want: nil},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			matches, err := a.Glob(filepath.FromSlash(tc.pattern))
+			require.NoError(t, err, "Glob failed")
+
+			var want []string
+			for _, m := range tc.want {
+				if filepath.IsAbs(filepath.FromSlash(m)) {
+					m = xplat(m)
+				}
+				want = append(want, filepath.FromSlash(m))
+			}
+			assert.Equal(t, want, matches)
+		})
+	}
+
+	t.Run("case insensitive", func(t *testing.T) {
+		matches, err := a.GlobWithOptions("readme.MD", GlobOptions{CaseInsensitive: true})
+		require.NoError(t, err, "GlobWithOptions failed")
+		assert.Equal(t, []string{"README.md"}, matches)
+
+		matches, err = a.GlobWithOptions(filepath.FromSlash("CHARTS/*/chart.yaml"), GlobOptions{CaseInsensitive: true})
+		require.NoError(t, err, "GlobWithOptions failed")
+		assert.Equal(t, []string{filepath.FromSlash("charts/mysql/Chart.yaml"), filepath.FromSlash("charts/redis/Chart.yaml")}, matches)
+	})
+
+	t.Run("bare doublestar", func(t *testing.T) {
+		a := NewAferox("/work", afero.NewMemMapFs())
+		require.NoError(t, a.WriteFile("/work/a/b.txt", nil, 0644))
+		require.NoError(t, a.WriteFile("/work/c.txt", nil, 0644))
+
+		matches, err := a.Glob("**")
+		require.NoError(t, err, "Glob failed")
+		assert.Equal(t, []string{"a", filepath.FromSlash("a/b.txt"), "c.txt"}, matches, "the working directory should not match")
+	})
+
+	t.Run("windows path style", func(t *testing.T) {
+		a := NewAferoxWithEnv("/", NewCaseInsensitiveFs(afero.NewMemMapFs()), NewEnv(nil))
+		a.SetPathStyle(WindowsPathStyle)
+		require.NoError(t, a.WriteFile(`C:\src\app\main.go`, nil, 0644))
+		require.NoError(t, a.WriteFile(`C:\src\lib.go`, nil, 0644))
+		require.NoError(t, a.Chdir(`C:\src`))
+
+		matches, err := a.Glob(`**\*.go`)
+		require.NoError(t, err, "Glob failed")
+		assert.Equal(t, []string{`app\main.go`, "lib.go"}, matches)
+
+		matches, err = a.Glob(`C:/src/{app,none}/*.go`)
+		require.NoError(t, err, "Glob failed")
+		assert.Equal(t, []string{`C:\src\app\main.go`}, matches)
+	})
+
+	t.Run("bad pattern", func(t *testing.T) {
+		_, err := a.Glob("charts/[")
+		assert.Equal(t, filepath.ErrBadPattern, err)
+	})
+}
+
+func TestExpandBraces(t *testing.T) {
+	assert.Equal(t, []string{"a"}, expandBraces(HostPathStyle, "a"))
+	assert.Equal(t, []string{"a/c", "a/d", "b/c", "b/d"}, expandBraces(HostPathStyle, "{a,b}/{c,d}"))
+	assert.Equal(t, []string{"xa", "xb1", "xb2"}, expandBraces(HostPathStyle, "x{a,b{1,2}}"))
+	assert.Equal(t, []string{"a{b"}, expandBraces(HostPathStyle, "a{b"), "unmatched braces should be left as-is")
+	assert.Equal(t, []string{"a", ""}, expandBraces(HostPathStyle, "{a,}"))
+}
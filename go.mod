@@ -1,6 +1,6 @@
 module github.com/carolynvs/aferox
 
-go 1.13
+go 1.16
 
 require (
 	github.com/spf13/afero v1.5.1
@@ -0,0 +1,256 @@
+package aferox
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/spf13/afero"
+)
+
+var (
+	_ fs.FS         = IOFS{}
+	_ fs.StatFS     = IOFS{}
+	_ fs.ReadDirFS  = IOFS{}
+	_ fs.ReadFileFS = IOFS{}
+	_ fs.GlobFS     = IOFS{}
+	_ fs.SubFS      = IOFS{}
+)
+
+// IOFS adapts a working directory aware filesystem to io/fs.FS, so that it can
+// be used with the standard library, for example with fs.WalkDir, http.FS or
+// template.ParseFS.
+//
+// Names are slash-separated and unrooted, as required by io/fs, and are
+// resolved against the root directory of the IOFS. The root is fixed when the
+// IOFS is created, so changing the working directory afterwards does not
+// change which files are visible.
+type IOFS struct {
+	fs *Fsx
+
+	// root is the absolute path, in the path style of fs, of the directory that names are relative to.
+	root string
+}
+
+// NewIOFS creates an io/fs.FS for the filesystem, rooted at its current
+// working directory.
+func NewIOFS(fs *Fsx) IOFS {
+	return IOFS{fs: fs, root: fs.Getwd()}
+}
+
+// IOFS creates an io/fs.FS for the filesystem, rooted at the current working
+// directory.
+func (a Aferox) IOFS() IOFS {
+	return NewIOFS(a.Fs)
+}
+
+// path converts a slash-separated io/fs name to an OS path.
+func (f IOFS) path(op string, name string) (string, error) {
+	if !fs.ValidPath(name) || (f.fs.PathStyle().windows() && strings.ContainsAny(name, `\:`)) {
+		return "", &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	return f.fs.PathStyle().join(f.root, name), nil
+}
+
+// pathError reports err using the io/fs name instead of the OS path.
+func (f IOFS) pathError(op string, name string, err error) error {
+	var pathErr *fs.PathError
+	if errors.As(err, &pathErr) {
+		err = pathErr.Err
+	}
+	return &fs.PathError{Op: op, Path: name, Err: err}
+}
+
+// Open opens the named file.
+func (f IOFS) Open(name string) (fs.File, error) {
+	path, err := f.path("open", name)
+	if err != nil {
+		return nil, err
+	}
+	file, err := f.fs.Open(path)
+	if err != nil {
+		return nil, f.pathError("open", name, err)
+	}
+	return &ioFile{File: file, name: name}, nil
+}
+
+// Stat returns a FileInfo describing the named file.
+func (f IOFS) Stat(name string) (fs.FileInfo, error) {
+	path, err := f.path("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := f.fs.Stat(path)
+	if err != nil {
+		return nil, f.pathError("stat", name, err)
+	}
+	return renamedFileInfo(fi, name), nil
+}
+
+// ReadDir reads the named directory and returns a list of directory entries
+// sorted by filename.
+func (f IOFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	path, err := f.path("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+	infos, err := afero.ReadDir(f.fs, path)
+	if err != nil {
+		return nil, f.pathError("readdir", name, err)
+	}
+	return toDirEntries(infos), nil
+}
+
+// ReadFile reads the named file and returns its contents.
+func (f IOFS) ReadFile(name string) ([]byte, error) {
+	path, err := f.path("readfile", name)
+	if err != nil {
+		return nil, err
+	}
+	data, err := afero.ReadFile(f.fs, path)
+	if err != nil {
+		return nil, f.pathError("readfile", name, err)
+	}
+	return data, nil
+}
+
+// Glob returns the names of all files matching pattern, using the syntax of
+// path.Match.
+func (f IOFS) Glob(pattern string) ([]string, error) {
+	// Hide the Glob method so that fs.Glob uses ReadDir instead of calling back into this method
+	return fs.Glob(readDirFS{f}, pattern)
+}
+
+// readDirFS exposes only the ReadDirFS methods of an IOFS.
+type readDirFS struct {
+	fs IOFS
+}
+
+func (f readDirFS) Open(name string) (fs.File, error) {
+	return f.fs.Open(name)
+}
+
+func (f readDirFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	return f.fs.ReadDir(name)
+}
+
+// Sub returns an FS corresponding to the subtree rooted at dir.
+func (f IOFS) Sub(dir string) (fs.FS, error) {
+	path, err := f.path("sub", dir)
+	if err != nil {
+		return nil, err
+	}
+	return IOFS{fs: f.fs, root: path}, nil
+}
+
+// ioFile adapts an afero.File to fs.ReadDirFile.
+type ioFile struct {
+	afero.File
+
+	// name that the file was opened with.
+	name string
+
+	// entries holds the remaining directory entries, sorted by name, once
+	// ReadDir has been called.
+	entries []fs.DirEntry
+	readDir bool
+}
+
+func (f *ioFile) Stat() (fs.FileInfo, error) {
+	fi, err := f.File.Stat()
+	if err != nil {
+		return nil, err
+	}
+	return renamedFileInfo(fi, f.name), nil
+}
+
+// ReadAt ensures that a short read reports an error, as required by
+// io.ReaderAt, which not every afero.File implementation does.
+func (f *ioFile) ReadAt(p []byte, off int64) (int, error) {
+	n, err := f.File.ReadAt(p, off)
+	if n < len(p) && err == nil {
+		err = io.EOF
+	}
+	return n, err
+}
+
+func (f *ioFile) ReadDir(n int) ([]fs.DirEntry, error) {
+	if !f.readDir {
+		infos, err := f.File.Readdir(-1)
+		if err != nil {
+			return nil, err
+		}
+		sort.Slice(infos, func(i, j int) bool { return infos[i].Name() < infos[j].Name() })
+		f.entries = toDirEntries(infos)
+		f.readDir = true
+	}
+
+	if n <= 0 {
+		entries := f.entries
+		f.entries = nil
+		return entries, nil
+	}
+	if len(f.entries) == 0 {
+		return nil, io.EOF
+	}
+	if n > len(f.entries) {
+		n = len(f.entries)
+	}
+	entries := f.entries[:n]
+	f.entries = f.entries[n:]
+	return entries, nil
+}
+
+// renamedFileInfo ensures that the name of the FileInfo matches the io/fs
+// name, since some filesystems return the full path, or the name of the
+// working directory for ".".
+func renamedFileInfo(fi os.FileInfo, name string) fs.FileInfo {
+	base := filepath.Base(filepath.FromSlash(name))
+	if fi.Name() == base {
+		return fi
+	}
+	return namedFileInfo{FileInfo: fi, name: base}
+}
+
+type namedFileInfo struct {
+	os.FileInfo
+	name string
+}
+
+func (fi namedFileInfo) Name() string {
+	return fi.name
+}
+
+// toDirEntries converts a list of FileInfo to DirEntry.
+func toDirEntries(infos []os.FileInfo) []fs.DirEntry {
+	entries := make([]fs.DirEntry, len(infos))
+	for i, fi := range infos {
+		entries[i] = dirEntry{fi}
+	}
+	return entries
+}
+
+// dirEntry adapts a FileInfo to fs.DirEntry.
+type dirEntry struct {
+	fi os.FileInfo
+}
+
+func (e dirEntry) Name() string {
+	return e.fi.Name()
+}
+
+func (e dirEntry) IsDir() bool {
+	return e.fi.IsDir()
+}
+
+func (e dirEntry) Type() fs.FileMode {
+	return e.fi.Mode().Type()
+}
+
+func (e dirEntry) Info() (fs.FileInfo, error) {
+	return e.fi, nil
+}
@@ -0,0 +1,109 @@
+package aferox
+
+import (
+	"errors"
+	"io/fs"
+	"path"
+	"testing"
+	"testing/fstest"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIOFS_TestFS(t *testing.T) {
+	a := NewAferox("/home", afero.NewMemMapFs())
+	writeTestFiles(t, a.Fs, map[string]string{
+		"/home/homefile.txt":  "homefile",
+		"/home/me/mefile.txt": "mefile",
+		"/home/me/notes.md":   "notes",
+		"/tmp/tmpfile.txt":    "tmpfile",
+	})
+
+	err := fstest.TestFS(a.IOFS(), "homefile.txt", "me/mefile.txt", "me/notes.md")
+	require.NoError(t, err)
+
+	sub, err := fs.Sub(a.IOFS(), "me")
+	require.NoError(t, err, "Sub failed")
+	err = fstest.TestFS(sub, "mefile.txt", "notes.md")
+	require.NoError(t, err)
+}
+
+func TestIOFS_Root(t *testing.T) {
+	a := NewAferox("/home", afero.NewMemMapFs())
+	writeTestFiles(t, a.Fs, map[string]string{
+		"/home/homefile.txt": "homefile",
+		"/tmp/tmpfile.txt":   "tmpfile",
+	})
+	fsys := a.IOFS()
+
+	require.NoError(t, a.Chdir("/tmp"), "Chdir failed")
+	data, err := fs.ReadFile(fsys, "homefile.txt")
+	require.NoError(t, err, "the IOFS root should not change with the working directory")
+	assert.Equal(t, "homefile", string(data))
+
+	_, err = fs.ReadFile(fsys, "tmpfile.txt")
+	assert.True(t, errors.Is(err, fs.ErrNotExist), "expected fs.ErrNotExist, got %v", err)
+}
+
+func TestIOFS_InvalidPath(t *testing.T) {
+	a := NewAferox("/home", afero.NewMemMapFs())
+	writeTestFiles(t, a.Fs, map[string]string{
+		"/home/homefile.txt":  "homefile",
+		"/home/me/mefile.txt": "mefile",
+		"/tmp/tmpfile.txt":    "tmpfile",
+	})
+	fsys := a.IOFS()
+
+	for _, name := range []string{"/home/homefile.txt", "../tmp/tmpfile.txt", "me/", ""} {
+		t.Run(name, func(t *testing.T) {
+			_, err := fsys.Open(name)
+			assert.True(t, errors.Is(err, fs.ErrInvalid), "expected fs.ErrInvalid, got %v", err)
+
+			_, err = fsys.Stat(name)
+			assert.True(t, errors.Is(err, fs.ErrInvalid), "expected fs.ErrInvalid, got %v", err)
+		})
+	}
+}
+
+func TestIOFS_Glob(t *testing.T) {
+	a := NewAferox("/home", afero.NewMemMapFs())
+	writeTestFiles(t, a.Fs, map[string]string{
+		"/home/homefile.txt":  "homefile",
+		"/home/me/mefile.txt": "mefile",
+		"/home/me/notes.md":   "notes",
+	})
+	fsys := a.IOFS()
+
+	matches, err := fs.Glob(fsys, "me/*.txt")
+	require.NoError(t, err, "Glob failed")
+	assert.Equal(t, []string{"me/mefile.txt"}, matches)
+
+	matches, err = fs.Glob(fsys, "*/*")
+	require.NoError(t, err, "Glob failed")
+	assert.Equal(t, []string{"me/mefile.txt", "me/notes.md"}, matches)
+
+	_, err = fs.Glob(fsys, "[")
+	assert.True(t, errors.Is(err, path.ErrBadPattern), "expected path.ErrBadPattern, got %v", err)
+}
+
+func TestIOFS_WalkDir(t *testing.T) {
+	a := NewAferox("/home", afero.NewMemMapFs())
+	writeTestFiles(t, a.Fs, map[string]string{
+		"/home/homefile.txt":  "homefile",
+		"/home/me/mefile.txt": "mefile",
+		"/home/me/notes.md":   "notes",
+		"/tmp/tmpfile.txt":    "tmpfile",
+	})
+	fsys := a.IOFS()
+
+	var walked []string
+	err := fs.WalkDir(fsys, ".", func(path string, d fs.DirEntry, err error) error {
+		require.NoError(t, err)
+		walked = append(walked, path)
+		return nil
+	})
+	require.NoError(t, err, "WalkDir failed")
+	assert.Equal(t, []string{".", "homefile.txt", "me", "me/mefile.txt", "me/notes.md"}, walked)
+}
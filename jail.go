@@ -0,0 +1,127 @@
+package aferox
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/spf13/afero"
+)
+
+// ErrJailEscape is returned by a jailed Fsx when a path refers to a location
+// outside of the jail.
+var ErrJailEscape = errors.New("path escapes the jail root")
+
+// Jail restricts an Fsx to a directory in the wrapped Fs, like a chroot.
+//
+// The root of the jail is presented as "/" by the Fsx, and the working
+// directory, and every file name returned to the caller, is relative to the
+// jail. Paths that would resolve above the root of the jail, such as
+// "../../etc/passwd", are clamped to the root, just like ".." in "/" refers to
+// "/", unless Strict is set.
+//
+// Symbolic links created through the Fsx always point inside the jail, and
+// ReadlinkIfPossible rejects links that point outside of it. Links that
+// already exist in the wrapped Fs are followed by the wrapped Fs though, so
+// the root of the jail should not contain links to files outside of it.
+//
+// Aferox.Command only runs virtual commands inside a jail, since a real
+// process could access any file on the host, unless AllowProcesses is set.
+type Jail struct {
+	// Root is the directory in the wrapped Fs that contains the jail.
+	Root string
+
+	// Strict rejects paths that try to escape the root with ErrJailEscape,
+	// instead of clamping them to the root.
+	Strict bool
+
+	// AllowProcesses lets Aferox.Command start real processes for the
+	// programs found inside the jail, in the working directory inside the
+	// jail. The processes are not confined to the jail, so only set it when
+	// the programs are trusted.
+	AllowProcesses bool
+}
+
+// NewJailedFsx creates a wrapper around a filesystem representation that is
+// restricted to the jail root, with an independent working directory inside of
+// the jail. The working directory is relative to the root of the jail.
+//
+// The root is resolved like the dir given to NewFsx: lexically against the
+// root on a virtual filesystem, such as afero.MemMapFs, and with filepath.Abs
+// on any other filesystem. If the root contains a NUL byte, or is a relative
+// path that refers to a location above the root of a virtual filesystem, the
+// error will be of type *os.PathError.
+func NewJailedFsx(jail Jail, dir string, fs afero.Fs) (*Fsx, error) {
+	root, err := jailRoot(jail.Root, fs)
+	if err != nil {
+		return nil, err
+	}
+	jail.Root = root
+	return &Fsx{
+		dir:  HostPathStyle.resolve(string(filepath.Separator), nil, dir),
+		fs:   fs,
+		jail: &jail,
+	}, nil
+}
+
+// NewJailedAferox creates a wrapper around a filesystem representation that is
+// restricted to the jail root, with an independent working directory inside of
+// the jail. See Jail and NewJailedFsx for details.
+func NewJailedAferox(jail Jail, dir string, fs afero.Fs) (Aferox, error) {
+	wrapper, err := NewJailedFsx(jail, dir, fs)
+	if err != nil {
+		return Aferox{}, err
+	}
+	return newAferox(wrapper, NewEnv(os.Environ())), nil
+}
+
+// jailRoot resolves the root of a jail in fs.
+func jailRoot(root string, fs afero.Fs) (string, error) {
+	if strings.IndexByte(root, 0) >= 0 {
+		return "", &os.PathError{Op: "jail", Path: root, Err: syscall.EINVAL}
+	}
+	if isVirtualFs(fs) {
+		return virtualAbs(root)
+	}
+	abs, err := filepath.Abs(root)
+	if err != nil {
+		return "", &os.PathError{Op: "jail", Path: root, Err: err}
+	}
+	return abs, nil
+}
+
+// realPath converts an absolute path inside the jail to the path in the
+// wrapped Fs. It is safe to call on a nil Jail, which returns the path as-is.
+//
+// The jail applies to the paths of the wrapped Fs, which always follow the
+// host, after they are converted from the path style of the Fsx with toHost.
+// So a jail works the same with any path style.
+func (j *Jail) realPath(path string) string {
+	if j == nil {
+		return path
+	}
+	return HostPathStyle.join(j.Root, path[len(HostPathStyle.volumeName(path)):])
+}
+
+// virtualPath converts a path in the wrapped Fs to the path inside the jail.
+// The bool reports whether the path is inside the jail.
+func (j *Jail) virtualPath(path string) (string, bool) {
+	if j == nil {
+		return path, true
+	}
+
+	root := HostPathStyle.separator()
+	if path == j.Root {
+		return root, true
+	}
+	prefix := j.Root
+	if !HostPathStyle.isSeparator(prefix[len(prefix)-1]) {
+		prefix += root
+	}
+	if !strings.HasPrefix(path, prefix) {
+		return "", false
+	}
+	return HostPathStyle.clean(root + path[len(prefix):]), true
+}
@@ -0,0 +1,163 @@
+package aferox
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFsx_Jail(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	writeTestFiles(t, fs, map[string]string{
+		"/etc/passwd":      "root",
+		"/jail/etc/passwd": "jailed",
+	})
+	require.NoError(t, fs.MkdirAll("/jail/home/me", 0755))
+	f, err := NewJailedAferox(Jail{Root: "/jail"}, "/home/me", fs)
+	require.NoError(t, err, "NewJailedAferox failed")
+
+	assert.Equal(t, "Fsx", f.Fs.Name())
+	assert.Equal(t, filepath.FromSlash("/home/me"), f.Getwd())
+	assert.Equal(t, filepath.FromSlash("/etc/passwd"), f.Abs("../../../../etc/passwd"))
+
+	t.Run("clamped", func(t *testing.T) {
+		data, err := f.ReadFile("../../../../etc/passwd")
+		require.NoError(t, err)
+		assert.Equal(t, "jailed", string(data))
+	})
+
+	t.Run("file names", func(t *testing.T) {
+		file, err := f.Create("notes.txt")
+		require.NoError(t, err)
+		defer file.Close()
+		assert.Equal(t, filepath.FromSlash("/home/me/notes.txt"), file.Name())
+
+		exists, _ := afero.Exists(fs, "/jail/home/me/notes.txt")
+		assert.True(t, exists, "expected the file to be created inside the jail")
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		_, err := f.Stat("missing.txt")
+		require.Error(t, err)
+		pathErr, ok := err.(*os.PathError)
+		require.True(t, ok, "expected a *os.PathError, got %T", err)
+		assert.Equal(t, filepath.FromSlash("/home/me/missing.txt"), pathErr.Path)
+	})
+
+	t.Run("chdir", func(t *testing.T) {
+		f, err := NewJailedAferox(Jail{Root: "/jail"}, "/home/me", fs)
+		require.NoError(t, err, "NewJailedAferox failed")
+		require.NoError(t, f.Chdir("../../../.."))
+		assert.Equal(t, filepath.FromSlash("/"), f.Getwd())
+
+		err = f.Chdir("/jail")
+		assert.True(t, os.IsNotExist(err), "expected the jail root to be hidden, got %v", err)
+	})
+}
+
+func TestNewJailedFsx_Root(t *testing.T) {
+	// A relative root is resolved lexically on a virtual filesystem
+	f, err := NewJailedFsx(Jail{Root: "jail"}, "/home", afero.NewMemMapFs())
+	require.NoError(t, err, "NewJailedFsx failed")
+	assert.Equal(t, filepath.FromSlash("/jail"), f.jail.Root)
+
+	_, err = NewJailedFsx(Jail{Root: "../jail"}, "/home", afero.NewMemMapFs())
+	_, ok := err.(*os.PathError)
+	assert.True(t, ok, "expected a *os.PathError for a root above the root of the filesystem, got %v", err)
+
+	_, err = NewJailedFsx(Jail{Root: "/ja\x00il"}, "/home", afero.NewMemMapFs())
+	_, ok = err.(*os.PathError)
+	assert.True(t, ok, "expected a *os.PathError for a root with a NUL byte, got %v", err)
+}
+
+func TestFsx_JailWindowsPathStyle(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	writeTestFiles(t, fs, map[string]string{
+		"/etc/passwd":      "root",
+		"/jail/etc/passwd": "jailed",
+	})
+	require.NoError(t, fs.MkdirAll("/jail/home/me", 0755))
+	f, err := NewJailedAferox(Jail{Root: "/jail"}, "/", fs)
+	require.NoError(t, err, "NewJailedAferox failed")
+	f.SetPathStyle(WindowsPathStyle)
+	assert.Equal(t, `C:\`, f.Getwd())
+
+	require.NoError(t, f.WriteFile(`C:\notes.txt`, nil, 0644))
+	exists, _ := afero.Exists(fs, "/jail/C:/notes.txt")
+	assert.True(t, exists, "expected the file to be created inside the jail")
+
+	file, err := f.Open(`..\..\notes.txt`)
+	require.NoError(t, err)
+	defer file.Close()
+	assert.Equal(t, `C:\notes.txt`, file.Name())
+}
+
+func TestFsx_JailStrict(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	writeTestFiles(t, fs, map[string]string{
+		"/etc/passwd":      "root",
+		"/jail/etc/passwd": "jailed",
+	})
+	require.NoError(t, fs.MkdirAll("/jail/home/me", 0755))
+	f, err := NewJailedAferox(Jail{Root: "/jail", Strict: true}, "/home/me", fs)
+	require.NoError(t, err, "NewJailedAferox failed")
+
+	_, err = f.ReadFile("../../../etc/passwd")
+	assert.True(t, errors.Is(err, ErrJailEscape), "expected ErrJailEscape, got %v", err)
+
+	data, err := f.ReadFile("../../etc/passwd")
+	require.NoError(t, err)
+	assert.Equal(t, "jailed", string(data))
+
+	err = f.Chdir("/..")
+	assert.True(t, errors.Is(err, ErrJailEscape), "expected ErrJailEscape, got %v", err)
+	assert.Equal(t, filepath.FromSlash("/home/me"), f.Getwd())
+
+	err = f.Rename("/etc/passwd", "../../../passwd")
+	require.Error(t, err)
+	_, ok := err.(*os.LinkError)
+	assert.True(t, ok, "expected a *os.LinkError, got %T", err)
+	assert.True(t, errors.Is(err, ErrJailEscape), "expected ErrJailEscape, got %v", err)
+}
+
+func TestFsx_JailSymlink(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Creating symbolic links requires extra privileges on Windows")
+	}
+
+	tmp, err := ioutil.TempDir("", "aferox")
+	require.NoError(t, err, "create temp directory failed")
+	defer os.RemoveAll(tmp)
+
+	root := filepath.Join(tmp, "jail")
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "home"), 0755))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(root, "home", "config"), []byte("jailed"), 0644))
+
+	f, err := NewJailedFsx(Jail{Root: root}, "/home", afero.NewOsFs())
+	require.NoError(t, err, "NewJailedFsx failed")
+	require.NoError(t, f.SymlinkIfPossible("config", "link"))
+
+	target, err := os.Readlink(filepath.Join(root, "home", "link"))
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(root, "home", "config"), target, "expected the link to point inside the jail")
+
+	target, err = f.ReadlinkIfPossible("link")
+	require.NoError(t, err)
+	assert.Equal(t, "/home/config", target)
+
+	// Links created outside the jail are rejected
+	require.NoError(t, os.Symlink(tmp, filepath.Join(root, "escape")))
+	_, err = f.ReadlinkIfPossible("/escape")
+	assert.True(t, errors.Is(err, ErrJailEscape), "expected ErrJailEscape, got %v", err)
+
+	require.NoError(t, os.Symlink("../..", filepath.Join(root, "home", "relative")))
+	_, err = f.ReadlinkIfPossible("relative")
+	assert.True(t, errors.Is(err, ErrJailEscape), "expected ErrJailEscape, got %v", err)
+}
@@ -0,0 +1,334 @@
+package aferox
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+)
+
+// LookPathExec searches for an executable named cmd in the directories named by
+// the PATH environment variable from Env, following the same rules as
+// exec.LookPath:
+//
+//   - If cmd contains a path separator, it is tried directly, resolved against
+//     the working directory, and PATH is not consulted.
+//   - Relative PATH entries, including empty entries which mean the current
+//     directory, are resolved against the working directory.
+//   - With Windows paths, each extension from PATHEXT is tried in the order
+//     listed, or .COM, .EXE, .BAT and .CMD when PATHEXT is empty. If cmd
+//     already has an extension it is tried as-is first. PATHEXT is ignored
+//     for POSIX paths.
+//   - Except on Windows, the file must have at least one execute bit set.
+//
+// The returned path is absolute, except when cmd contains a path separator,
+// in which case it is returned as given (plus any matching extension).
+// When no executable is found, the error is an *exec.Error wrapping
+// exec.ErrNotFound. Like exec.LookPath, when cmd contains a path separator
+// and no extensions are tried, it wraps the error from checking the file
+// instead, such as a *os.PathError for a missing file.
+// Use in place of exec.LookPath.
+func (a Aferox) LookPathExec(cmd string) (string, error) {
+	if a.Fs.PathStyle().hasSeparator(cmd) && len(a.pathExts()) == 0 {
+		if err := a.checkExecutable(a.Abs(cmd)); err != nil {
+			return "", &exec.Error{Name: cmd, Err: err}
+		}
+		return cmd, nil
+	}
+
+	var match *LookPathMatch
+	a.lookPath(cmd,
+		func(m LookPathMatch) bool {
+			match = &m
+			return false
+		},
+		func(LookPathSkip) {})
+
+	if match == nil {
+		return "", &exec.Error{Name: cmd, Err: exec.ErrNotFound}
+	}
+	return match.Path, nil
+}
+
+// LookPathMatch is an executable found while searching PATH.
+type LookPathMatch struct {
+	// Path to the executable.
+	Path string
+
+	// Dir is the PATH entry where the executable was found, resolved against
+	// the working directory. It is empty when the command contained a path
+	// separator and PATH was not searched.
+	Dir string
+
+	// Ext is the extension from PATHEXT that matched, or empty when the
+	// command matched without adding an extension.
+	Ext string
+}
+
+// LookPathAll searches for every executable named cmd in the directories named
+// by the PATH environment variable from Env, like "which -a". Matches are
+// returned in order of precedence, so the first match is what LookPathExec
+// returns and the rest are shadowed by it. See LookPathExec for the rules
+// used to find executables.
+// When no executable is found, the error is an *exec.Error wrapping
+// exec.ErrNotFound.
+func (a Aferox) LookPathAll(cmd string) ([]LookPathMatch, error) {
+	var matches []LookPathMatch
+	a.lookPath(cmd,
+		func(m LookPathMatch) bool {
+			matches = append(matches, m)
+			return true
+		},
+		func(LookPathSkip) {})
+
+	if len(matches) == 0 {
+		return nil, &exec.Error{Name: cmd, Err: exec.ErrNotFound}
+	}
+	return matches, nil
+}
+
+// LookPathSkipReason explains why a candidate was not used while searching PATH.
+type LookPathSkipReason string
+
+const (
+	// LookPathSkipNotExecutable indicates that the file does not have an
+	// execute bit set.
+	LookPathSkipNotExecutable LookPathSkipReason = "not executable"
+
+	// LookPathSkipIsDir indicates that the candidate is a directory.
+	LookPathSkipIsDir LookPathSkipReason = "is a directory"
+
+	// LookPathSkipUnreadableDir indicates that the PATH entry could not be
+	// searched, for example because it is a file, or because of the
+	// permissions of its parent directory.
+	LookPathSkipUnreadableDir LookPathSkipReason = "unreadable directory"
+)
+
+// LookPathSkip is a candidate that was not used while searching PATH.
+type LookPathSkip struct {
+	// Path to the file, or to the directory from PATH when the directory
+	// could not be searched.
+	Path string
+
+	// Reason that the candidate was skipped.
+	Reason LookPathSkipReason
+
+	// Err is the underlying error.
+	Err error
+}
+
+// LookPathReport explains how a command was resolved from PATH.
+type LookPathReport struct {
+	// Cmd is the command that was searched for.
+	Cmd string
+
+	// Selected is the executable that is used for the command, or nil when
+	// the command was not found.
+	Selected *LookPathMatch
+
+	// Shadowed are the other executables for the command, which are not used
+	// because Selected comes first.
+	Shadowed []LookPathMatch
+
+	// Skipped are the candidates that were rejected, in the order that they
+	// were checked.
+	Skipped []LookPathSkip
+}
+
+// DiagnoseLookPath searches PATH for cmd and reports which executable is used,
+// which executables are shadowed by it, and why any other candidates were
+// skipped. Use it to debug finding the wrong executable on PATH.
+func (a Aferox) DiagnoseLookPath(cmd string) LookPathReport {
+	report := LookPathReport{Cmd: cmd}
+	a.lookPath(cmd,
+		func(m LookPathMatch) bool {
+			if report.Selected == nil {
+				report.Selected = &m
+			} else {
+				report.Shadowed = append(report.Shadowed, m)
+			}
+			return true
+		},
+		func(s LookPathSkip) {
+			report.Skipped = append(report.Skipped, s)
+		})
+	return report
+}
+
+// String formats the report for display, for example:
+//
+//	go: /usr/local/go/bin/go
+//	  shadowed: /usr/bin/go
+//	  skipped: /home/me/bin/go (not executable)
+func (r LookPathReport) String() string {
+	var b strings.Builder
+	if r.Selected != nil {
+		fmt.Fprintf(&b, "%s: %s\n", r.Cmd, r.Selected.Path)
+	} else {
+		fmt.Fprintf(&b, "%s: not found\n", r.Cmd)
+	}
+	for _, m := range r.Shadowed {
+		fmt.Fprintf(&b, "  shadowed: %s\n", m.Path)
+	}
+	for _, s := range r.Skipped {
+		fmt.Fprintf(&b, "  skipped: %s (%s)\n", s.Path, s.Reason)
+	}
+	return b.String()
+}
+
+// lookPath searches for cmd, calling found for each executable in order of
+// precedence until it returns false, and skipped for each candidate that
+// exists but cannot be used.
+func (a Aferox) lookPath(cmd string, found func(LookPathMatch) bool, skipped func(LookPathSkip)) {
+	style := a.Fs.PathStyle()
+	exts := a.pathExts()
+
+	if style.hasSeparator(cmd) {
+		for _, c := range executableCandidates(style, cmd, exts) {
+			if !a.checkCandidate(c, a.Abs(c.path), found, skipped) {
+				return
+			}
+		}
+		return
+	}
+
+	for _, dir := range a.pathDirs() {
+		if err := a.checkPathDir(dir); err != nil {
+			if !os.IsNotExist(err) {
+				skipped(LookPathSkip{Path: dir, Reason: LookPathSkipUnreadableDir, Err: err})
+			}
+			continue
+		}
+
+		for _, c := range executableCandidates(style, style.join(dir, cmd), exts) {
+			c.dir = dir
+			if !a.checkCandidate(c, c.path, found, skipped) {
+				return
+			}
+		}
+	}
+}
+
+// checkPathDir returns an error when the directory from PATH cannot be
+// searched. Like exec.LookPath, the directory isn't read, so that a
+// directory which only allows searching, without listing its files, works.
+func (a Aferox) checkPathDir(dir string) error {
+	fi, err := a.Stat(dir)
+	if err != nil {
+		return err
+	}
+	if !fi.IsDir() {
+		return &os.PathError{Op: "stat", Path: dir, Err: syscall.ENOTDIR}
+	}
+	return nil
+}
+
+// checkCandidate reports a possible executable to either found or skipped,
+// and returns false when the search should stop.
+func (a Aferox) checkCandidate(c executableCandidate, fullPath string, found func(LookPathMatch) bool, skipped func(LookPathSkip)) bool {
+	err := a.checkExecutable(fullPath)
+	if err == nil {
+		return found(LookPathMatch{Path: c.path, Dir: c.dir, Ext: c.ext})
+	}
+
+	if pathErr, ok := err.(*os.PathError); ok {
+		switch pathErr.Err {
+		case syscall.EISDIR:
+			skipped(LookPathSkip{Path: c.path, Reason: LookPathSkipIsDir, Err: err})
+		case os.ErrPermission:
+			skipped(LookPathSkip{Path: c.path, Reason: LookPathSkipNotExecutable, Err: err})
+		}
+	}
+	return true
+}
+
+// pathDirs returns the directories from the PATH environment variable,
+// resolved against the working directory.
+func (a Aferox) pathDirs() []string {
+	entries := a.Fs.PathStyle().splitList(a.Getenv("PATH"))
+	dirs := make([]string, 0, len(entries))
+	for _, dir := range entries {
+		// An empty entry in PATH means the current directory
+		dirs = append(dirs, a.Abs(dir))
+	}
+	return dirs
+}
+
+// defaultPathExt is used in place of an empty PATHEXT, like exec.LookPath on
+// Windows.
+const defaultPathExt = ".COM;.EXE;.BAT;.CMD"
+
+// pathExts returns the lowercase file extensions from the PATHEXT environment
+// variable, in the order that they should be tried. Only Windows paths use
+// extensions.
+func (a Aferox) pathExts() []string {
+	if !a.Fs.PathStyle().windows() {
+		return nil
+	}
+	pathExt := a.Getenv("PATHEXT")
+	if pathExt == "" {
+		pathExt = defaultPathExt
+	}
+	return splitPathExt(pathExt)
+}
+
+// splitPathExt splits a list of file extensions, such as PATHEXT, into
+// lowercase extensions that start with a dot.
+func splitPathExt(pathExt string) []string {
+	var exts []string
+	for _, ext := range strings.Split(strings.ToLower(pathExt), ";") {
+		if ext == "" {
+			continue
+		}
+		if !strings.HasPrefix(ext, ".") {
+			ext = "." + ext
+		}
+		exts = append(exts, ext)
+	}
+	return exts
+}
+
+// executableCandidate is a file that may be an executable.
+type executableCandidate struct {
+	// dir is the PATH entry containing the file.
+	dir string
+
+	// path to the file.
+	path string
+
+	// ext is the extension from PATHEXT added to the file name.
+	ext string
+}
+
+// executableCandidates returns the file names to try for an executable, in
+// order of precedence.
+func executableCandidates(style PathStyle, name string, exts []string) []executableCandidate {
+	if len(exts) == 0 {
+		return []executableCandidate{{path: name}}
+	}
+
+	candidates := make([]executableCandidate, 0, len(exts)+1)
+	if style.ext(name) != "" {
+		candidates = append(candidates, executableCandidate{path: name})
+	}
+	for _, ext := range exts {
+		candidates = append(candidates, executableCandidate{path: name + ext, ext: ext})
+	}
+	return candidates
+}
+
+// checkExecutable returns an error when the named file cannot be executed.
+func (a Aferox) checkExecutable(name string) error {
+	fi, err := a.Stat(name)
+	if err != nil {
+		return err
+	}
+	if fi.IsDir() {
+		return &os.PathError{Op: "exec", Path: name, Err: syscall.EISDIR}
+	}
+	if !a.Fs.PathStyle().windows() && fi.Mode()&0111 == 0 {
+		return &os.PathError{Op: "exec", Path: name, Err: os.ErrPermission}
+	}
+	return nil
+}
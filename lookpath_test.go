@@ -0,0 +1,238 @@
+package aferox
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newLookPathAferox creates an Aferox over a memfs with the specified
+// PATH entries and PATHEXT.
+func newLookPathAferox(path []string, pathExt string) Aferox {
+	env := NewEnv([]string{
+		"PATH=" + strings.Join(path, string(os.PathListSeparator)),
+		"PATHEXT=" + pathExt,
+	})
+	return NewAferoxWithEnv("/home", afero.NewMemMapFs(), env)
+}
+
+// newWindowsLookPathAferox creates an Aferox over a memfs using Windows paths,
+// with the specified PATH entries and PATHEXT.
+func newWindowsLookPathAferox(path []string, pathExt string) Aferox {
+	a := newLookPathAferox(nil, pathExt)
+	a.SetPathStyle(WindowsPathStyle)
+	a.Setenv("PATH", strings.Join(path, ";"))
+	return a
+}
+
+func TestAferox_LookPathExec(t *testing.T) {
+	t.Run("osfs", func(t *testing.T) {
+		pwd, err := os.Getwd()
+		require.NoError(t, err, "Getwd failed")
+
+		a := NewAferox(pwd, afero.NewOsFs())
+		cmdPath, err := a.LookPathExec("go")
+		require.NoError(t, err, "LookPathExec failed")
+
+		wantPath, err := exec.LookPath("go")
+		require.NoError(t, err, "exec.LookPath failed")
+		assert.Equal(t, wantPath, cmdPath)
+	})
+
+	t.Run("first match in path order", func(t *testing.T) {
+		a := newLookPathAferox([]string{"/usr/local/bin", "/bin"}, "")
+		require.NoError(t, a.WriteFile("/bin/go", nil, 0755))
+		require.NoError(t, a.WriteFile("/usr/local/bin/go", nil, 0755))
+
+		cmdPath, err := a.LookPathExec("go")
+		require.NoError(t, err, "LookPathExec failed")
+		assert.Equal(t, xplat("/usr/local/bin/go"), cmdPath)
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		a := newLookPathAferox([]string{"/bin"}, "")
+
+		_, err := a.LookPathExec("go")
+		require.Error(t, err)
+		assert.True(t, errors.Is(err, exec.ErrNotFound), "expected exec.ErrNotFound, got %v", err)
+
+		var execErr *exec.Error
+		require.True(t, errors.As(err, &execErr), "expected an *exec.Error")
+		assert.Equal(t, "go", execErr.Name)
+	})
+
+	t.Run("skip directories", func(t *testing.T) {
+		a := newLookPathAferox([]string{"/usr/local/bin", "/bin"}, "")
+		require.NoError(t, a.MkdirAll("/usr/local/bin/go", 0755))
+		require.NoError(t, a.WriteFile("/bin/go", nil, 0755))
+
+		cmdPath, err := a.LookPathExec("go")
+		require.NoError(t, err, "LookPathExec failed")
+		assert.Equal(t, xplat("/bin/go"), cmdPath)
+	})
+
+	t.Run("pathext order", func(t *testing.T) {
+		a := newWindowsLookPathAferox([]string{`C:\bin`}, ".COM;.EXE;.BAT")
+		require.NoError(t, a.WriteFile(`C:\bin\tool.exe`, nil, 0755))
+		require.NoError(t, a.WriteFile(`C:\bin\tool.bat`, nil, 0755))
+		require.NoError(t, a.WriteFile(`C:\bin\tool.com`, nil, 0755))
+
+		cmdPath, err := a.LookPathExec("tool")
+		require.NoError(t, err, "LookPathExec failed")
+		assert.Equal(t, `C:\bin\tool.com`, cmdPath)
+	})
+
+	t.Run("explicit extension", func(t *testing.T) {
+		a := newWindowsLookPathAferox([]string{`C:\bin`}, ".com;.exe")
+		require.NoError(t, a.WriteFile(`C:\bin\tool.exe`, nil, 0755))
+		require.NoError(t, a.WriteFile(`C:\bin\tool.exe.com`, nil, 0755))
+
+		cmdPath, err := a.LookPathExec("tool.exe")
+		require.NoError(t, err, "LookPathExec failed")
+		assert.Equal(t, `C:\bin\tool.exe`, cmdPath)
+	})
+
+	t.Run("default pathext", func(t *testing.T) {
+		a := newWindowsLookPathAferox([]string{`C:\bin`}, "")
+		require.NoError(t, a.WriteFile(`C:\bin\tool.cmd`, nil, 0644))
+
+		cmdPath, err := a.LookPathExec("tool")
+		require.NoError(t, err, "LookPathExec failed")
+		assert.Equal(t, `C:\bin\tool.cmd`, cmdPath)
+	})
+
+	t.Run("pathext ignored for posix paths", func(t *testing.T) {
+		a := newLookPathAferox([]string{"/bin"}, ".exe")
+		a.SetPathStyle(POSIXPathStyle)
+		require.NoError(t, a.WriteFile("/bin/tool.exe", nil, 0755))
+
+		_, err := a.LookPathExec("tool")
+		assert.True(t, errors.Is(err, exec.ErrNotFound), "expected exec.ErrNotFound, got %v", err)
+	})
+
+	t.Run("relative path entry", func(t *testing.T) {
+		a := newLookPathAferox([]string{"bin", ""}, "")
+		require.NoError(t, a.WriteFile("/home/bin/tool", nil, 0755))
+		require.NoError(t, a.WriteFile("/home/other", nil, 0755))
+
+		cmdPath, err := a.LookPathExec("tool")
+		require.NoError(t, err, "LookPathExec failed")
+		assert.Equal(t, xplat("/home/bin/tool"), cmdPath)
+
+		cmdPath, err = a.LookPathExec("other")
+		require.NoError(t, err, "LookPathExec failed")
+		assert.Equal(t, xplat("/home/other"), cmdPath, "an empty PATH entry should be the working directory")
+	})
+
+	t.Run("path separator", func(t *testing.T) {
+		a := newLookPathAferox([]string{"/bin"}, "")
+		require.NoError(t, a.WriteFile("/home/scripts/tool", nil, 0755))
+
+		cmdPath, err := a.LookPathExec("scripts/tool")
+		require.NoError(t, err, "LookPathExec failed")
+		assert.Equal(t, "scripts/tool", cmdPath)
+
+		_, err = a.LookPathExec("scripts/missing")
+		if runtime.GOOS == "windows" {
+			assert.True(t, errors.Is(err, exec.ErrNotFound), "expected exec.ErrNotFound, got %v", err)
+		} else {
+			assert.True(t, os.IsNotExist(errors.Unwrap(err)), "expected the error from checking the file, got %v", err)
+		}
+	})
+
+	t.Run("search-only path entry", func(t *testing.T) {
+		fs := NewFaultFs(afero.NewMemMapFs(), 1)
+		require.NoError(t, fs.Inject(FaultRule{Op: "open", Path: xplat("/bin"), Err: os.ErrPermission}))
+		a := NewAferoxWithEnv("/home", fs, NewEnv([]string{"PATH=" + xplat("/bin")}))
+		require.NoError(t, a.WriteFile("/bin/go", nil, 0755))
+
+		cmdPath, err := a.LookPathExec("go")
+		require.NoError(t, err, "a PATH entry should only need to be searched, not read")
+		assert.Equal(t, xplat("/bin/go"), cmdPath)
+	})
+
+	t.Run("not executable", func(t *testing.T) {
+		if runtime.GOOS == "windows" {
+			t.Skip("Windows does not use the execute bit")
+		}
+
+		a := newLookPathAferox([]string{"/usr/local/bin", "/bin"}, "")
+		require.NoError(t, a.WriteFile("/usr/local/bin/go", nil, 0644))
+		require.NoError(t, a.WriteFile("/bin/go", nil, 0755))
+		require.NoError(t, a.WriteFile("/home/readme", nil, 0644))
+
+		cmdPath, err := a.LookPathExec("go")
+		require.NoError(t, err, "LookPathExec failed")
+		assert.Equal(t, xplat("/bin/go"), cmdPath)
+
+		_, err = a.LookPathExec("./readme")
+		require.Error(t, err)
+		assert.True(t, errors.Is(err, os.ErrPermission), "expected a permission error, got %v", err)
+	})
+}
+
+func TestAferox_LookPathAll(t *testing.T) {
+	a := newWindowsLookPathAferox([]string{`C:\usr\local\bin`, `C:\missing`, `C:\bin`, `C:\opt\bin`}, ".exe")
+	require.NoError(t, a.WriteFile(`C:\usr\local\bin\go.exe`, nil, 0755))
+	require.NoError(t, a.WriteFile(`C:\bin\go`, nil, 0755))
+	require.NoError(t, a.WriteFile(`C:\bin\go.exe`, nil, 0755))
+	require.NoError(t, a.WriteFile(`C:\opt\bin\go.exe`, nil, 0755))
+
+	matches, err := a.LookPathAll("go")
+	require.NoError(t, err, "LookPathAll failed")
+	wantMatches := []LookPathMatch{
+		{Path: `C:\usr\local\bin\go.exe`, Dir: `C:\usr\local\bin`, Ext: ".exe"},
+		{Path: `C:\bin\go.exe`, Dir: `C:\bin`, Ext: ".exe"},
+		{Path: `C:\opt\bin\go.exe`, Dir: `C:\opt\bin`, Ext: ".exe"},
+	}
+	assert.Equal(t, wantMatches, matches)
+
+	_, err = a.LookPathAll("missing")
+	assert.True(t, errors.Is(err, exec.ErrNotFound), "expected exec.ErrNotFound, got %v", err)
+}
+
+func TestAferox_DiagnoseLookPath(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Windows does not use the execute bit")
+	}
+
+	a := newLookPathAferox([]string{"/home/bin", "/usr/local/bin", "/etc/file", "/bin", "/opt/bin"}, "")
+	require.NoError(t, a.WriteFile("/home/bin/go", nil, 0644))
+	require.NoError(t, a.MkdirAll("/usr/local/bin/go", 0755))
+	require.NoError(t, a.WriteFile("/etc/file", nil, 0644))
+	require.NoError(t, a.WriteFile("/bin/go", nil, 0755))
+	require.NoError(t, a.WriteFile("/opt/bin/go", nil, 0755))
+
+	report := a.DiagnoseLookPath("go")
+	require.NotNil(t, report.Selected, "expected an executable to be selected")
+	assert.Equal(t, "/bin/go", report.Selected.Path)
+	require.Len(t, report.Shadowed, 1)
+	assert.Equal(t, "/opt/bin/go", report.Shadowed[0].Path)
+
+	require.Len(t, report.Skipped, 3)
+	assert.Equal(t, "/home/bin/go", report.Skipped[0].Path)
+	assert.Equal(t, LookPathSkipNotExecutable, report.Skipped[0].Reason)
+	assert.Equal(t, "/usr/local/bin/go", report.Skipped[1].Path)
+	assert.Equal(t, LookPathSkipIsDir, report.Skipped[1].Reason)
+	assert.Equal(t, "/etc/file", report.Skipped[2].Path)
+	assert.Equal(t, LookPathSkipUnreadableDir, report.Skipped[2].Reason)
+
+	wantReport := `go: /bin/go
+  shadowed: /opt/bin/go
+  skipped: /home/bin/go (not executable)
+  skipped: /usr/local/bin/go (is a directory)
+  skipped: /etc/file (unreadable directory)
+`
+	assert.Equal(t, wantReport, report.String())
+
+	report = a.DiagnoseLookPath("missing")
+	assert.Nil(t, report.Selected)
+	assert.Equal(t, "missing: not found\n  skipped: /etc/file (unreadable directory)\n", report.String())
+}
@@ -0,0 +1,537 @@
+package aferox
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/spf13/afero"
+	"github.com/spf13/afero/mem"
+)
+
+var _ afero.Fs = &MountFs{}
+var _ afero.Symlinker = &MountFs{}
+
+// MountFs composes multiple filesystems into a single tree, like the mount
+// table of an operating system. Each operation is sent to the filesystem
+// mounted at the longest matching prefix of the path, with the path made
+// relative to the mount point, so a file at /tmp/foo on a filesystem mounted
+// at /tmp is /foo in the mounted filesystem.
+//
+// Directory listings include the mount points below the directory, and the
+// directories containing a mount point always exist, even if they don't exist
+// in the filesystem that they belong to.
+//
+// Renaming a file to a different mount fails with EXDEV, unless
+// EnableCrossMountRename is used. Mount points can't be renamed or removed,
+// which fails with EBUSY.
+//
+// Paths are cleaned and rooted at /, so callers should use absolute paths,
+// which is what Fsx always provides.
+type MountFs struct {
+	mu sync.RWMutex
+
+	// mounts are sorted by mount point, longest first, so that the first
+	// matching mount is the longest prefix.
+	mounts []mount
+
+	// copyAcrossMounts renames across mounts by copying and then deleting.
+	copyAcrossMounts bool
+}
+
+// mount is a filesystem mounted at a directory.
+type mount struct {
+	dir string
+	fs  afero.Fs
+}
+
+// NewMountFs creates a filesystem with root mounted at /.
+func NewMountFs(root afero.Fs) *MountFs {
+	return &MountFs{
+		mounts: []mount{{dir: string(filepath.Separator), fs: root}},
+	}
+}
+
+// rootFs returns the filesystem mounted at /. It is always the last mount,
+// since it has the shortest mount point.
+func (m *MountFs) rootFs() afero.Fs {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.mounts[len(m.mounts)-1].fs
+}
+
+// Mount mounts fs at the directory dir. Paths below dir, including dir itself,
+// are sent to fs. If a filesystem is already mounted at dir, the error will be
+// a *os.PathError wrapping EBUSY.
+func (m *MountFs) Mount(dir string, fs afero.Fs) error {
+	dir = cleanMountPath(dir)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, mnt := range m.mounts {
+		if mnt.dir == dir {
+			return &os.PathError{Op: "mount", Path: dir, Err: syscall.EBUSY}
+		}
+	}
+	mounts := append(append([]mount{}, m.mounts...), mount{dir: dir, fs: fs})
+	sort.SliceStable(mounts, func(i, j int) bool { return len(mounts[i].dir) > len(mounts[j].dir) })
+	m.mounts = mounts
+	return nil
+}
+
+// Unmount removes the filesystem mounted at the directory dir. If no
+// filesystem is mounted at dir, or dir is the root, the error will be a
+// *os.PathError wrapping EINVAL.
+func (m *MountFs) Unmount(dir string) error {
+	dir = cleanMountPath(dir)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, mnt := range m.mounts {
+		if mnt.dir == dir && i < len(m.mounts)-1 {
+			mounts := append([]mount{}, m.mounts[:i]...)
+			m.mounts = append(mounts, m.mounts[i+1:]...)
+			return nil
+		}
+	}
+	return &os.PathError{Op: "unmount", Path: dir, Err: syscall.EINVAL}
+}
+
+// Mounts returns the mount points, sorted by name.
+func (m *MountFs) Mounts() []string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	dirs := make([]string, len(m.mounts))
+	for i, mnt := range m.mounts {
+		dirs[i] = mnt.dir
+	}
+	sort.Strings(dirs)
+	return dirs
+}
+
+// EnableCrossMountRename renames files across mounts by copying them to the
+// new mount and then removing them from the old one, instead of failing with
+// EXDEV. Like mv, the copy isn't atomic.
+func (m *MountFs) EnableCrossMountRename() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.copyAcrossMounts = true
+}
+
+// cleanMountPath cleans the path and roots it at /.
+func cleanMountPath(name string) string {
+	name = name[len(filepath.VolumeName(name)):]
+	return filepath.Clean(string(filepath.Separator) + name)
+}
+
+// find returns the mount containing name, and the path of name in the
+// mounted filesystem.
+func (m *MountFs) find(name string) (mount, string) {
+	path := cleanMountPath(name)
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, mnt := range m.mounts {
+		if isPathOrChild(path, mnt.dir) {
+			return mnt, cleanMountPath(strings.TrimPrefix(path, mnt.dir))
+		}
+	}
+	// The root is always mounted, so this is never reached
+	return m.mounts[len(m.mounts)-1], path
+}
+
+// mountsBelow returns the mounts strictly below the directory at path.
+func (m *MountFs) mountsBelow(path string) []mount {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var below []mount
+	for _, mnt := range m.mounts {
+		if mnt.dir != path && isPathOrChild(mnt.dir, path) {
+			below = append(below, mnt)
+		}
+	}
+	return below
+}
+
+// isBusy determines if path is a mount point, or contains one.
+func (m *MountFs) isBusy(path string) bool {
+	path = cleanMountPath(path)
+	if len(m.mountsBelow(path)) > 0 {
+		return true
+	}
+	mnt, _ := m.find(path)
+	return mnt.dir == path
+}
+
+// mountError reports err using the path given to MountFs, instead of the path
+// in the mounted filesystem.
+func mountError(err error, name string) error {
+	switch e := err.(type) {
+	case *os.PathError:
+		return &os.PathError{Op: e.Op, Path: name, Err: e.Err}
+	default:
+		return err
+	}
+}
+
+// Create creates or truncates the named file.
+func (m *MountFs) Create(name string) (afero.File, error) {
+	return m.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+}
+
+// Mkdir creates a new directory with the specified name and permission
+// bits (before umask).
+func (m *MountFs) Mkdir(name string, perm os.FileMode) error {
+	mnt, path := m.find(name)
+	if mnt.dir == cleanMountPath(name) {
+		return &os.PathError{Op: "mkdir", Path: name, Err: os.ErrExist}
+	}
+	return mountError(mnt.fs.Mkdir(path, perm), name)
+}
+
+// MkdirAll creates a directory named path, along with any necessary parents.
+func (m *MountFs) MkdirAll(path string, perm os.FileMode) error {
+	mnt, mntPath := m.find(path)
+	return mountError(mnt.fs.MkdirAll(mntPath, perm), path)
+}
+
+// Open opens the named file for reading.
+func (m *MountFs) Open(name string) (afero.File, error) {
+	return m.OpenFile(name, os.O_RDONLY, 0)
+}
+
+// OpenFile opens a file using the given flags and the given mode. Directory
+// listings include the mount points below the directory.
+func (m *MountFs) OpenFile(name string, flag int, perm os.FileMode) (afero.File, error) {
+	path := cleanMountPath(name)
+	mnt, mntPath := m.find(name)
+	below := m.mountsBelow(path)
+
+	file, err := mnt.fs.OpenFile(mntPath, flag, perm)
+	if err != nil {
+		if len(below) == 0 || !os.IsNotExist(err) || flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE) != 0 {
+			return nil, mountError(err, name)
+		}
+		// Directories containing a mount point always exist
+		file = mem.NewReadOnlyFileHandle(mem.CreateDir(path))
+	}
+	if len(below) == 0 {
+		return &namedFile{File: file, name: name}, nil
+	}
+	return &mountDir{File: file, fs: m, name: name, path: path, below: below}, nil
+}
+
+// Remove removes the named file or (empty) directory.
+func (m *MountFs) Remove(name string) error {
+	if m.isBusy(name) {
+		return &os.PathError{Op: "remove", Path: name, Err: syscall.EBUSY}
+	}
+	mnt, path := m.find(name)
+	return mountError(mnt.fs.Remove(path), name)
+}
+
+// RemoveAll removes path and any children it contains. When path is, or
+// contains, a mount point, the error is EBUSY and nothing is removed.
+func (m *MountFs) RemoveAll(path string) error {
+	if m.isBusy(path) {
+		return &os.PathError{Op: "removeall", Path: path, Err: syscall.EBUSY}
+	}
+	mnt, mntPath := m.find(path)
+	return mountError(mnt.fs.RemoveAll(mntPath), path)
+}
+
+// Rename renames (moves) oldname to newname. When the names are on different
+// mounts, the error is a *os.LinkError wrapping EXDEV, unless
+// EnableCrossMountRename was used.
+func (m *MountFs) Rename(oldname, newname string) error {
+	if m.isBusy(oldname) || m.isBusy(newname) {
+		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: syscall.EBUSY}
+	}
+
+	oldMnt, oldpath := m.find(oldname)
+	newMnt, newpath := m.find(newname)
+	if oldMnt.dir == newMnt.dir {
+		if err := oldMnt.fs.Rename(oldpath, newpath); err != nil {
+			return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: underlyingError(err)}
+		}
+		return nil
+	}
+
+	m.mu.RLock()
+	copyAcrossMounts := m.copyAcrossMounts
+	m.mu.RUnlock()
+	if !copyAcrossMounts {
+		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: syscall.EXDEV}
+	}
+
+	if err := copyTree(oldMnt.fs, oldpath, newMnt.fs, newpath); err != nil {
+		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: underlyingError(err)}
+	}
+	return mountError(oldMnt.fs.RemoveAll(oldpath), oldname)
+}
+
+// copyTree copies the file or directory at src in one filesystem to dest in
+// another, preserving the mode and modification time. Symbolic links are
+// copied as links, when both filesystems support them.
+func copyTree(srcFs afero.Fs, src string, destFs afero.Fs, dest string) error {
+	fi, err := lstatIfPossible(srcFs, src)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case fi.Mode()&os.ModeSymlink != 0:
+		reader, ok := srcFs.(afero.LinkReader)
+		linker, ok2 := destFs.(afero.Linker)
+		if !ok || !ok2 {
+			return &os.LinkError{Op: "symlink", Old: src, New: dest, Err: afero.ErrNoSymlink}
+		}
+		target, err := reader.ReadlinkIfPossible(src)
+		if err != nil {
+			return err
+		}
+		return linker.SymlinkIfPossible(target, dest)
+	case fi.IsDir():
+		if err := destFs.MkdirAll(dest, fi.Mode().Perm()); err != nil {
+			return err
+		}
+		infos, err := afero.ReadDir(srcFs, src)
+		if err != nil {
+			return err
+		}
+		for _, child := range infos {
+			if err := copyTree(srcFs, filepath.Join(src, child.Name()), destFs, filepath.Join(dest, child.Name())); err != nil {
+				return err
+			}
+		}
+	default:
+		if err := copyFile(srcFs, src, destFs, dest, fi.Mode().Perm()); err != nil {
+			return err
+		}
+	}
+	return destFs.Chtimes(dest, fi.ModTime(), fi.ModTime())
+}
+
+// copyFile copies the contents of a regular file between filesystems.
+func copyFile(srcFs afero.Fs, src string, destFs afero.Fs, dest string, perm os.FileMode) error {
+	in, err := srcFs.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := destFs.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// lstatIfPossible describes the file without following a symbolic link, when
+// supported by the filesystem.
+func lstatIfPossible(fs afero.Fs, name string) (os.FileInfo, error) {
+	if lstater, ok := fs.(afero.Lstater); ok {
+		fi, _, err := lstater.LstatIfPossible(name)
+		return fi, err
+	}
+	return fs.Stat(name)
+}
+
+// Stat returns a FileInfo describing the named file.
+func (m *MountFs) Stat(name string) (os.FileInfo, error) {
+	fi, _, err := m.stat(name, func(fs afero.Fs, path string) (os.FileInfo, bool, error) {
+		fi, err := fs.Stat(path)
+		return fi, false, err
+	})
+	return fi, err
+}
+
+// LstatIfPossible returns a FileInfo describing the named file, without
+// following a symbolic link when supported by the mounted filesystem.
+func (m *MountFs) LstatIfPossible(name string) (os.FileInfo, bool, error) {
+	return m.stat(name, func(fs afero.Fs, path string) (os.FileInfo, bool, error) {
+		if lstater, ok := fs.(afero.Lstater); ok {
+			return lstater.LstatIfPossible(path)
+		}
+		fi, err := fs.Stat(path)
+		return fi, false, err
+	})
+}
+
+// stat describes the named file using statFn on the mounted filesystem. Mount
+// points are named after the mount point, and the directories containing a
+// mount point always exist.
+func (m *MountFs) stat(name string, statFn func(afero.Fs, string) (os.FileInfo, bool, error)) (os.FileInfo, bool, error) {
+	path := cleanMountPath(name)
+	mnt, mntPath := m.find(name)
+
+	fi, lstatCalled, err := statFn(mnt.fs, mntPath)
+	if err != nil {
+		if os.IsNotExist(err) && len(m.mountsBelow(path)) > 0 {
+			return mem.GetFileInfo(mem.CreateDir(path)), lstatCalled, nil
+		}
+		return nil, lstatCalled, mountError(err, name)
+	}
+	if mnt.dir == path && fi.Name() != filepath.Base(path) {
+		fi = namedFileInfo{FileInfo: fi, name: filepath.Base(path)}
+	}
+	return fi, lstatCalled, nil
+}
+
+// SymlinkIfPossible creates newname as a symbolic link to oldname, when
+// supported by the filesystem mounted at newname. The target is stored as
+// given.
+func (m *MountFs) SymlinkIfPossible(oldname, newname string) error {
+	mnt, path := m.find(newname)
+	linker, ok := mnt.fs.(afero.Linker)
+	if !ok {
+		return &os.LinkError{Op: "symlink", Old: oldname, New: newname, Err: afero.ErrNoSymlink}
+	}
+	return linker.SymlinkIfPossible(oldname, path)
+}
+
+// ReadlinkIfPossible returns the destination of the named symbolic link, when
+// supported by the mounted filesystem.
+func (m *MountFs) ReadlinkIfPossible(name string) (string, error) {
+	mnt, path := m.find(name)
+	reader, ok := mnt.fs.(afero.LinkReader)
+	if !ok {
+		return "", &os.PathError{Op: "readlink", Path: name, Err: afero.ErrNoReadlink}
+	}
+	target, err := reader.ReadlinkIfPossible(path)
+	return target, mountError(err, name)
+}
+
+// Name of this FileSystem.
+func (m *MountFs) Name() string {
+	return "MountFs"
+}
+
+// Chmod changes the mode of the named file to mode.
+func (m *MountFs) Chmod(name string, mode os.FileMode) error {
+	mnt, path := m.find(name)
+	return mountError(mnt.fs.Chmod(path, mode), name)
+}
+
+// Chown changes the uid and gid of the named file.
+func (m *MountFs) Chown(name string, uid, gid int) error {
+	mnt, path := m.find(name)
+	return mountError(mnt.fs.Chown(path, uid, gid), name)
+}
+
+// Chtimes changes the access and modification times of the named file.
+func (m *MountFs) Chtimes(name string, atime time.Time, mtime time.Time) error {
+	mnt, path := m.find(name)
+	return mountError(mnt.fs.Chtimes(path, atime, mtime), name)
+}
+
+// mountDir is a directory containing mount points. Its listing includes the
+// mount points, and the directories leading to them.
+type mountDir struct {
+	afero.File
+
+	fs *MountFs
+
+	// name that the directory was opened with.
+	name string
+
+	// path of the directory in the MountFs.
+	path string
+
+	// below are the mounts below the directory.
+	below []mount
+
+	// entries holds the remaining directory entries once Readdir has been
+	// called.
+	entries []os.FileInfo
+	readDir bool
+}
+
+func (d *mountDir) Name() string {
+	return d.name
+}
+
+func (d *mountDir) Readdir(count int) ([]os.FileInfo, error) {
+	if !d.readDir {
+		entries, err := d.File.Readdir(-1)
+		if err != nil {
+			return nil, err
+		}
+		d.entries = d.mergeMounts(entries)
+		d.readDir = true
+	}
+
+	if count <= 0 {
+		entries := d.entries
+		d.entries = nil
+		return entries, nil
+	}
+	if len(d.entries) == 0 {
+		return nil, io.EOF
+	}
+	if count > len(d.entries) {
+		count = len(d.entries)
+	}
+	entries := d.entries[:count]
+	d.entries = d.entries[count:]
+	return entries, nil
+}
+
+func (d *mountDir) Readdirnames(n int) ([]string, error) {
+	infos, err := d.Readdir(n)
+	names := make([]string, len(infos))
+	for i, fi := range infos {
+		names[i] = fi.Name()
+	}
+	return names, err
+}
+
+// mergeMounts adds the mount points directly below the directory to its
+// entries, replacing any file with the same name, and the directories that
+// lead to mount points further down.
+func (d *mountDir) mergeMounts(entries []os.FileInfo) []os.FileInfo {
+	index := make(map[string]int, len(entries))
+	for i, fi := range entries {
+		index[fi.Name()] = i
+	}
+
+	for _, mnt := range d.below {
+		rel := strings.TrimPrefix(strings.TrimPrefix(mnt.dir, d.path), string(filepath.Separator))
+		child := rel
+		if i := strings.IndexRune(rel, filepath.Separator); i >= 0 {
+			child = rel[:i]
+		}
+		childPath := filepath.Join(d.path, child)
+
+		i, exists := index[child]
+		if exists && childPath != mnt.dir {
+			// The directory leading to the mount point is already listed
+			continue
+		}
+		fi, err := d.fs.Stat(childPath)
+		if err != nil {
+			continue
+		}
+		if exists {
+			entries[i] = fi
+		} else {
+			index[child] = len(entries)
+			entries = append(entries, fi)
+		}
+	}
+	return entries
+}
@@ -0,0 +1,146 @@
+package aferox
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMountFs(t *testing.T) {
+	root := afero.NewMemMapFs()
+	tmp := afero.NewMemMapFs()
+	bin := afero.NewMemMapFs()
+	require.NoError(t, afero.WriteFile(bin, "/porter", []byte("#!/bin/sh"), 0755))
+
+	m := NewMountFs(root)
+	require.NoError(t, m.Mount("/tmp", tmp))
+	require.NoError(t, m.Mount("/usr/bin", afero.NewReadOnlyFs(bin)))
+	a := NewAferox("/tmp", m)
+
+	t.Run("longest prefix", func(t *testing.T) {
+		require.NoError(t, a.WriteFile("scratch.txt", []byte("hello"), 0644))
+		exists, _ := afero.Exists(tmp, "/scratch.txt")
+		assert.True(t, exists, "expected the file to be written to the /tmp mount")
+		exists, _ = afero.Exists(root, "/tmp/scratch.txt")
+		assert.False(t, exists, "expected the file to not be written to the root mount")
+
+		data, err := a.ReadFile("/usr/bin/porter")
+		require.NoError(t, err)
+		assert.Equal(t, "#!/bin/sh", string(data))
+	})
+
+	t.Run("read-only mount", func(t *testing.T) {
+		err := a.WriteFile("/usr/bin/mixin", nil, 0755)
+		require.Error(t, err)
+	})
+
+	t.Run("merged listings", func(t *testing.T) {
+		require.NoError(t, a.MkdirAll("/home", 0755))
+
+		names := func(dir string) []string {
+			infos, err := a.ReadDir(dir)
+			require.NoError(t, err)
+			var names []string
+			for _, fi := range infos {
+				names = append(names, fi.Name())
+				assert.True(t, fi.IsDir(), "expected %s to be a directory", fi.Name())
+			}
+			return names
+		}
+		assert.Equal(t, []string{"home", "tmp", "usr"}, names("/"))
+		assert.Equal(t, []string{"bin"}, names("/usr"))
+
+		fi, err := a.Stat("/usr")
+		require.NoError(t, err)
+		assert.True(t, fi.IsDir())
+
+		fi, err = a.Stat("/usr/bin")
+		require.NoError(t, err)
+		assert.Equal(t, "bin", fi.Name())
+	})
+
+	t.Run("working directory", func(t *testing.T) {
+		a := NewAferox("/", m)
+		require.NoError(t, a.Chdir("/usr/bin"))
+		exists, _ := a.Exists("porter")
+		assert.True(t, exists)
+	})
+
+	t.Run("mount points are busy", func(t *testing.T) {
+		err := a.RemoveAll("/usr")
+		assert.True(t, errors.Is(err, syscall.EBUSY), "expected EBUSY, got %v", err)
+
+		err = a.Rename("/tmp", "/temp")
+		assert.True(t, errors.Is(err, syscall.EBUSY), "expected EBUSY, got %v", err)
+	})
+
+	t.Run("errors use the full path", func(t *testing.T) {
+		_, err := a.Stat("/tmp/missing")
+		require.Error(t, err)
+		pathErr, ok := err.(*os.PathError)
+		require.True(t, ok, "expected a *os.PathError, got %T", err)
+		assert.Equal(t, "/tmp/missing", pathErr.Path)
+	})
+}
+
+func TestMountFs_Rename(t *testing.T) {
+	root := afero.NewMemMapFs()
+	tmp := afero.NewMemMapFs()
+	m := NewMountFs(root)
+	require.NoError(t, m.Mount("/tmp", tmp))
+	require.NoError(t, afero.WriteFile(tmp, "/build/out.txt", []byte("output"), 0600))
+
+	err := m.Rename("/tmp/build", "/build")
+	require.Error(t, err)
+	_, ok := err.(*os.LinkError)
+	assert.True(t, ok, "expected a *os.LinkError, got %T", err)
+	assert.True(t, errors.Is(err, syscall.EXDEV), "expected EXDEV, got %v", err)
+
+	require.NoError(t, m.Rename("/tmp/build/out.txt", "/tmp/build/app.txt"), "renaming within a mount should succeed")
+
+	m.EnableCrossMountRename()
+	require.NoError(t, m.Rename("/tmp/build", "/dist"))
+	data, err := afero.ReadFile(root, "/dist/app.txt")
+	require.NoError(t, err)
+	assert.Equal(t, "output", string(data))
+	fi, err := root.Stat("/dist/app.txt")
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), fi.Mode().Perm())
+
+	exists, _ := afero.Exists(tmp, "/build")
+	assert.False(t, exists, "expected the source to be removed")
+}
+
+func TestMountFs_BasePathFs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "aferox")
+	require.NoError(t, err, "create temp directory failed")
+	defer os.RemoveAll(dir)
+
+	m := NewMountFs(afero.NewMemMapFs())
+	require.NoError(t, m.Mount("/home/user/.porter", afero.NewBasePathFs(afero.NewOsFs(), dir)))
+	a := NewAferox("/home/user", m)
+
+	require.NoError(t, a.WriteFile(".porter/config.toml", []byte("debug = true"), 0644))
+	data, err := ioutil.ReadFile(filepath.Join(dir, "config.toml"))
+	require.NoError(t, err)
+	assert.Equal(t, "debug = true", string(data))
+
+	// The directories leading to the mount exist
+	isDir, err := a.IsDir("/home/user")
+	require.NoError(t, err)
+	assert.True(t, isDir)
+
+	require.NoError(t, m.Unmount("/home/user/.porter"))
+	exists, _ := a.Exists(".porter/config.toml")
+	assert.False(t, exists)
+
+	err = m.Unmount("/")
+	assert.True(t, errors.Is(err, syscall.EINVAL), "expected EINVAL, got %v", err)
+}
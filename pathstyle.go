@@ -0,0 +1,388 @@
+package aferox
+
+import (
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// PathStyle determines how Fsx interprets paths, independent of the operating
+// system that the program is running on. This allows verifying how a program
+// handles Windows paths on Linux, and vice versa.
+type PathStyle int
+
+const (
+	// HostPathStyle follows the conventions of the operating system that the
+	// program is running on. This is the default.
+	HostPathStyle PathStyle = iota
+
+	// POSIXPathStyle uses slash separated paths with a single root, "/".
+	// Paths are case sensitive.
+	POSIXPathStyle
+
+	// WindowsPathStyle uses paths with drive letters, such as C:\Users, or
+	// UNC paths, such as \\server\share\dir. Both \ and / are separators, and
+	// the result of resolving a path always uses \. Each drive has a separate
+	// working directory, so that C:file is resolved against the last working
+	// directory on drive C:, and \file is rooted on the drive of the current
+	// working directory. Paths are compared case insensitively.
+	WindowsPathStyle
+)
+
+func (s PathStyle) String() string {
+	switch s {
+	case POSIXPathStyle:
+		return "posix"
+	case WindowsPathStyle:
+		return "windows"
+	default:
+		return "host"
+	}
+}
+
+// windows determines if the style uses Windows paths.
+func (s PathStyle) windows() bool {
+	switch s {
+	case POSIXPathStyle:
+		return false
+	case WindowsPathStyle:
+		return true
+	default:
+		return runtime.GOOS == "windows"
+	}
+}
+
+// native determines if the style matches the paths of the operating system
+// that the program is running on, so that paths don't need to be converted
+// for the wrapped filesystem.
+func (s PathStyle) native() bool {
+	return s.windows() == (runtime.GOOS == "windows")
+}
+
+// separator returns the separator used when building paths.
+func (s PathStyle) separator() string {
+	if s.windows() {
+		return `\`
+	}
+	return "/"
+}
+
+// isSeparator determines if the character separates path segments.
+func (s PathStyle) isSeparator(c byte) bool {
+	return c == '/' || (c == '\\' && s.windows())
+}
+
+// hasSeparator determines if the path contains a path separator, or on
+// Windows a volume name.
+func (s PathStyle) hasSeparator(p string) bool {
+	if s.windows() {
+		return strings.ContainsAny(p, `/\:`)
+	}
+	return strings.Contains(p, "/")
+}
+
+// listSeparator returns the separator used in lists of paths, such as PATH.
+func (s PathStyle) listSeparator() string {
+	if s.windows() {
+		return ";"
+	}
+	return ":"
+}
+
+// splitList splits a list of paths, such as PATH, like filepath.SplitList.
+func (s PathStyle) splitList(list string) []string {
+	if list == "" {
+		return []string{}
+	}
+	if !s.windows() {
+		return strings.Split(list, ":")
+	}
+
+	// Separators inside of quotes are part of the path on Windows
+	var entries []string
+	var entry strings.Builder
+	quoted := false
+	for i := 0; i < len(list); i++ {
+		switch c := list[i]; {
+		case c == '"':
+			quoted = !quoted
+		case c == ';' && !quoted:
+			entries = append(entries, entry.String())
+			entry.Reset()
+		default:
+			entry.WriteByte(c)
+		}
+	}
+	return append(entries, entry.String())
+}
+
+// volumeName returns the leading volume name of a Windows path, such as "C:"
+// or `\\server\share`. POSIX paths don't have a volume name.
+func (s PathStyle) volumeName(p string) string {
+	if !s.windows() {
+		return ""
+	}
+
+	if len(p) >= 2 && p[1] == ':' && isDriveLetter(p[0]) {
+		return p[:2]
+	}
+	// A UNC path starts with two separators, followed by the server and share
+	if len(p) >= 5 && s.isSeparator(p[0]) && s.isSeparator(p[1]) && !s.isSeparator(p[2]) && p[2] != '.' {
+		for n := 3; n < len(p)-1; n++ {
+			if !s.isSeparator(p[n]) {
+				continue
+			}
+			n++
+			if s.isSeparator(p[n]) || p[n] == '.' {
+				break
+			}
+			for ; n < len(p); n++ {
+				if s.isSeparator(p[n]) {
+					break
+				}
+			}
+			return p[:n]
+		}
+	}
+	return ""
+}
+
+func isDriveLetter(c byte) bool {
+	return ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z')
+}
+
+// isUNC determines if the volume name is a UNC share instead of a drive.
+func (s PathStyle) isUNC(volume string) bool {
+	return len(volume) > 2
+}
+
+// isAbs determines if the path is absolute. On Windows a path such as \foo
+// or C:foo isn't absolute, because it depends on the working directory.
+func (s PathStyle) isAbs(p string) bool {
+	if !s.windows() {
+		return strings.HasPrefix(p, "/")
+	}
+
+	volume := s.volumeName(p)
+	if volume == "" {
+		return false
+	}
+	if s.isUNC(volume) {
+		return true
+	}
+	return len(p) > len(volume) && s.isSeparator(p[len(volume)])
+}
+
+// clean returns the shortest path name equivalent to the path, like
+// filepath.Clean. Windows paths always use \ as the separator.
+func (s PathStyle) clean(p string) string {
+	if !s.windows() {
+		return path.Clean(p)
+	}
+
+	volume := s.volumeName(p)
+	rest := strings.Replace(p[len(volume):], `\`, "/", -1)
+	volume = strings.Replace(volume, "/", `\`, -1)
+	if rest == "" {
+		if s.isUNC(volume) {
+			return volume
+		}
+		return volume + "."
+	}
+	return volume + strings.Replace(path.Clean(rest), "/", `\`, -1)
+}
+
+// join joins any number of path elements into a single path, like
+// filepath.Join.
+func (s PathStyle) join(elem ...string) string {
+	var nonEmpty []string
+	for _, e := range elem {
+		if e != "" {
+			nonEmpty = append(nonEmpty, e)
+		}
+	}
+	if len(nonEmpty) == 0 {
+		return ""
+	}
+
+	// Joining C: and foo refers to foo in the working directory of drive C:
+	if s.windows() && len(nonEmpty[0]) == 2 && s.volumeName(nonEmpty[0]) == nonEmpty[0] && len(nonEmpty) > 1 {
+		return s.clean(nonEmpty[0] + strings.Join(nonEmpty[1:], s.separator()))
+	}
+	return s.clean(strings.Join(nonEmpty, s.separator()))
+}
+
+// dir returns all but the last element of path, like filepath.Dir.
+func (s PathStyle) dir(p string) string {
+	volume := s.volumeName(p)
+	i := len(p) - 1
+	for i >= len(volume) && !s.isSeparator(p[i]) {
+		i--
+	}
+	return s.clean(p[:i+1])
+}
+
+// ext returns the file name extension used by path, like filepath.Ext.
+func (s PathStyle) ext(p string) string {
+	for i := len(p) - 1; i >= 0 && !s.isSeparator(p[i]); i-- {
+		if p[i] == '.' {
+			return p[i:]
+		}
+	}
+	return ""
+}
+
+// equal determines if two cleaned paths refer to the same file name, which is
+// case insensitive on Windows.
+func (s PathStyle) equal(a string, b string) bool {
+	if s.windows() {
+		return strings.EqualFold(a, b)
+	}
+	return a == b
+}
+
+// driveKey normalizes a volume name for looking up the working directory of
+// a drive.
+func (s PathStyle) driveKey(volume string) string {
+	return strings.ToUpper(volume)
+}
+
+// resolve returns the absolute representation of path, resolved against the
+// working directory dir. On Windows, drives holds the last working directory
+// of each drive, by driveKey.
+func (s PathStyle) resolve(dir string, drives map[string]string, p string) string {
+	if !s.windows() {
+		if s.isAbs(p) {
+			return s.clean(p)
+		}
+		return s.clean(dir + "/" + p)
+	}
+
+	volume := s.volumeName(p)
+	switch {
+	case s.isAbs(p):
+		return s.clean(p)
+	case volume != "":
+		// A drive relative path, such as C:foo
+		return s.clean(s.driveDir(dir, drives, volume) + `\` + p[len(volume):])
+	case p != "" && s.isSeparator(p[0]):
+		// A rooted path, such as \foo, is on the drive of the working directory
+		return s.clean(s.volumeName(dir) + p)
+	default:
+		return s.clean(dir + `\` + p)
+	}
+}
+
+// driveDir returns the working directory for a drive.
+func (s PathStyle) driveDir(dir string, drives map[string]string, volume string) string {
+	if s.equal(volume, s.volumeName(dir)) {
+		return dir
+	}
+	if driveDir, ok := drives[s.driveKey(volume)]; ok {
+		return driveDir
+	}
+	return volume + `\`
+}
+
+// escapesRoot determines if path, resolved against dir, refers to a location
+// above the root directory.
+func (s PathStyle) escapesRoot(dir string, p string) bool {
+	fullPath := p
+	if !s.isAbs(p) && s.volumeName(p) == "" && !(p != "" && s.isSeparator(p[0])) {
+		fullPath = dir + s.separator() + p
+	}
+
+	depth := 0
+	for _, name := range s.split(fullPath[len(s.volumeName(fullPath)):]) {
+		switch name {
+		case ".":
+		case "..":
+			if depth == 0 {
+				return true
+			}
+			depth--
+		default:
+			depth++
+		}
+	}
+	return false
+}
+
+// split splits a path into its segments, ignoring empty segments.
+func (s PathStyle) split(p string) []string {
+	return strings.FieldsFunc(p, func(r rune) bool {
+		return r < 0x80 && s.isSeparator(uint8(r))
+	})
+}
+
+// splitRoot splits an absolute path into its root, such as / or C:\, and the
+// rest of the path.
+func (s PathStyle) splitRoot(p string) (string, string) {
+	root := s.volumeName(p) + s.separator()
+	if len(p) <= len(root) {
+		return root, ""
+	}
+	return root, p[len(root):]
+}
+
+// convert changes an absolute path from another style into this style, so
+// that it refers to the same file in the wrapped filesystem. Rooted paths
+// without a drive are placed on drive C:.
+func (s PathStyle) convert(from PathStyle, p string) string {
+	if from.windows() == s.windows() {
+		return s.clean(p)
+	}
+	if hostPath, ok := s.fromHost(from.toHost(p)); ok {
+		return hostPath
+	}
+	if s.windows() {
+		return s.resolve(`C:\`, nil, p)
+	}
+	return s.resolve("/", nil, strings.Replace(p, `\`, "/", -1))
+}
+
+// toHost converts an absolute path in this style into the path used by the
+// wrapped filesystem, which uses the paths of the operating system.
+//
+// Windows paths on other operating systems are stored under the root, with
+// C:\Users stored as /C:/Users, and \\server\share\dir as
+// /UNC/server/share/dir. POSIX paths on Windows are rooted on the current
+// drive.
+func (s PathStyle) toHost(p string) string {
+	if s.native() {
+		return p
+	}
+	if !s.windows() {
+		return filepath.FromSlash(p)
+	}
+
+	volume := s.volumeName(p)
+	rest := strings.Replace(p[len(volume):], `\`, "/", -1)
+	if s.isUNC(volume) {
+		return path.Join("/UNC", strings.Replace(volume[2:], `\`, "/", -1), rest)
+	}
+	return path.Join("/", s.driveKey(volume), rest)
+}
+
+// fromHost converts a path used by the wrapped filesystem into an absolute
+// path in this style, reversing toHost. The bool reports whether the path
+// can be represented in this style.
+func (s PathStyle) fromHost(p string) (string, bool) {
+	if s.native() {
+		return p, true
+	}
+	if !s.windows() {
+		return path.Clean("/" + filepath.ToSlash(p[len(filepath.VolumeName(p)):])), true
+	}
+
+	segments := strings.Split(strings.TrimPrefix(path.Clean(p), "/"), "/")
+	switch {
+	case len(segments[0]) == 2 && s.volumeName(segments[0]) == segments[0]:
+		return s.clean(segments[0] + `\` + strings.Join(segments[1:], `\`)), true
+	case segments[0] == "UNC" && len(segments) >= 3:
+		return s.clean(`\\` + strings.Join(segments[1:], `\`)), true
+	default:
+		return "", false
+	}
+}
@@ -0,0 +1,177 @@
+package aferox
+
+import (
+	"io/fs"
+	"os"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPathStyle_resolve(t *testing.T) {
+	drives := map[string]string{"D:": `D:\src`}
+
+	testcases := []struct {
+		name  string
+		style PathStyle
+		dir   string
+		path  string
+		want  string
+	}{
+		{name: "posix relative", style: POSIXPathStyle, dir: "/home", path: "me/../you", want: "/home/you"},
+		{name: "posix absolute", style: POSIXPathStyle, dir: "/home", path: "/tmp/", want: "/tmp"},
+		{name: "posix backslash", style: POSIXPathStyle, dir: "/home", path: `a\b`, want: `/home/a\b`},
+		{name: "windows relative", style: WindowsPathStyle, dir: `C:\Users`, path: "me/docs", want: `C:\Users\me\docs`},
+		{name: "windows absolute", style: WindowsPathStyle, dir: `C:\Users`, path: `E:/tmp/../temp`, want: `E:\temp`},
+		{name: "windows rooted", style: WindowsPathStyle, dir: `C:\Users`, path: `\tmp`, want: `C:\tmp`},
+		{name: "windows rooted slash", style: WindowsPathStyle, dir: `C:\Users`, path: `/tmp`, want: `C:\tmp`},
+		{name: "windows same drive relative", style: WindowsPathStyle, dir: `C:\Users`, path: `c:me`, want: `C:\Users\me`},
+		{name: "windows other drive relative", style: WindowsPathStyle, dir: `C:\Users`, path: `D:app`, want: `D:\src\app`},
+		{name: "windows unvisited drive", style: WindowsPathStyle, dir: `C:\Users`, path: `E:app`, want: `E:\app`},
+		{name: "windows parent of root", style: WindowsPathStyle, dir: `C:\`, path: `..\..`, want: `C:\`},
+		{name: "unc", style: WindowsPathStyle, dir: `C:\Users`, path: `//server/share/dir/../file`, want: `\\server\share\file`},
+		{name: "unc rooted", style: WindowsPathStyle, dir: `\\server\share\dir`, path: `\file`, want: `\\server\share\file`},
+		{name: "unc parent of root", style: WindowsPathStyle, dir: `\\server\share`, path: `..`, want: `\\server\share\`},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.want, tc.style.resolve(tc.dir, drives, tc.path))
+		})
+	}
+}
+
+func TestPathStyle_toHost(t *testing.T) {
+	if !POSIXPathStyle.native() {
+		t.Skip("Windows paths are only converted on other operating systems")
+	}
+
+	testcases := []struct {
+		path string
+		host string
+	}{
+		{path: `C:\`, host: "/C:"},
+		{path: `c:\Users\me`, host: "/C:/Users/me"},
+		{path: `\\server\share\dir`, host: "/UNC/server/share/dir"},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.path, func(t *testing.T) {
+			host := WindowsPathStyle.toHost(tc.path)
+			assert.Equal(t, tc.host, host)
+
+			path, ok := WindowsPathStyle.fromHost(host)
+			require.True(t, ok)
+			assert.True(t, WindowsPathStyle.equal(WindowsPathStyle.clean(tc.path), path), "expected %s, got %s", tc.path, path)
+		})
+	}
+
+	_, ok := WindowsPathStyle.fromHost("/tmp")
+	assert.False(t, ok, "/tmp can't be represented as a Windows path")
+}
+
+func TestPathStyle_dir(t *testing.T) {
+	assert.Equal(t, "/home", POSIXPathStyle.dir("/home/me"))
+	assert.Equal(t, "/", POSIXPathStyle.dir("/home"))
+	assert.Equal(t, ".", POSIXPathStyle.dir("me"))
+	assert.Equal(t, `C:\Users`, WindowsPathStyle.dir(`C:/Users\me`))
+	assert.Equal(t, `C:\`, WindowsPathStyle.dir(`C:\Users`))
+	assert.Equal(t, `C:.`, WindowsPathStyle.dir(`C:me`))
+	assert.Equal(t, `\\server\share\`, WindowsPathStyle.dir(`\\server\share\dir`))
+}
+
+func TestPathStyle_splitList(t *testing.T) {
+	assert.Equal(t, []string{"/bin", "", "/usr/bin"}, POSIXPathStyle.splitList("/bin::/usr/bin"))
+	assert.Equal(t, []string{`C:\bin`, `C:\Program Files;x\bin`}, WindowsPathStyle.splitList(`C:\bin;"C:\Program Files;x\bin"`))
+	assert.Empty(t, WindowsPathStyle.splitList(""))
+}
+
+func TestFsx_WindowsPathStyle(t *testing.T) {
+	a := NewAferoxWithEnv("/", NewCaseInsensitiveFs(afero.NewMemMapFs()), NewEnv(nil))
+	a.SetPathStyle(WindowsPathStyle)
+	require.NoError(t, a.MkdirAll(`C:\Users\me`, 0755))
+	require.NoError(t, a.MkdirAll(`D:\src`, 0755))
+	assert.Equal(t, `C:\`, a.Getwd())
+
+	require.NoError(t, a.Chdir(`c:/users/../Users/me`))
+	assert.Equal(t, `c:\Users\me`, a.Getwd())
+
+	// Each drive remembers its working directory
+	require.NoError(t, a.Chdir(`D:\src`))
+	assert.Equal(t, `c:\Users\me\notes.txt`, a.Abs(`C:notes.txt`))
+	assert.Equal(t, `D:\tmp`, a.Abs(`/tmp`))
+	require.NoError(t, a.Chdir(`C:`))
+	assert.Equal(t, `c:\Users\me`, a.Getwd())
+
+	// Files are stored under the drive in the wrapped filesystem, and names are reported as Windows paths
+	f, err := a.Create("notes.txt")
+	require.NoError(t, err)
+	defer f.Close()
+	assert.Equal(t, `c:\Users\me\notes.txt`, f.Name())
+	exists, _ := afero.Exists(a.Fs.fs.(*CaseInsensitiveFs).fs, "/C:/Users/me/notes.txt")
+	assert.True(t, exists, "expected the file to be stored under /C:")
+
+	_, err = a.Stat(`D:\missing`)
+	require.Error(t, err)
+	pathErr, ok := err.(*os.PathError)
+	require.True(t, ok, "expected a *os.PathError, got %T", err)
+	assert.Equal(t, `D:\missing`, pathErr.Path)
+
+	require.NoError(t, a.WriteFile(`\\server\share\readme.txt`, []byte("hello"), 0644))
+	data, err := a.ReadFile(`//server/share/readme.txt`)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(data))
+}
+
+func TestFsx_SetPathStyle(t *testing.T) {
+	f := NewFsx("/home/me", afero.NewMemMapFs())
+	f.SetPathStyle(WindowsPathStyle)
+	assert.Equal(t, `C:\home\me`, f.Getwd())
+
+	f.SetPathStyle(POSIXPathStyle)
+	assert.Equal(t, "/C:/home/me", f.Getwd(), "expected the working directory to refer to the same directory")
+}
+
+func TestAferox_WindowsPathStyle(t *testing.T) {
+	a := NewAferoxWithEnv("/", NewCaseInsensitiveFs(afero.NewMemMapFs()), NewEnv(nil))
+	a.SetPathStyle(WindowsPathStyle)
+	require.NoError(t, a.Setenv("Path", `C:\Windows;D:\src`))
+	require.NoError(t, a.Setenv("PATHEXT", ".COM;.EXE"))
+	require.NoError(t, a.WriteFile(`D:\src\app.exe`, nil, 0644))
+
+	t.Run("env", func(t *testing.T) {
+		assert.Equal(t, `C:\Windows;D:\src`, a.Getenv("PATH"))
+	})
+
+	t.Run("LookPathExec", func(t *testing.T) {
+		path, err := a.LookPathExec("app")
+		require.NoError(t, err)
+		assert.Equal(t, `D:\src\app.exe`, path)
+	})
+
+	t.Run("LookPath", func(t *testing.T) {
+		path, ok := a.LookPathEnv("APP")
+		require.True(t, ok)
+		assert.Equal(t, `D:\src\app.exe`, path)
+	})
+
+	t.Run("IOFS", func(t *testing.T) {
+		require.NoError(t, a.WriteFile(`C:\Users\me\notes.txt`, []byte("notes"), 0644))
+		require.NoError(t, a.Chdir(`C:\Users`))
+		data, err := fs.ReadFile(a.IOFS(), "me/notes.txt")
+		require.NoError(t, err)
+		assert.Equal(t, "notes", string(data))
+	})
+
+	t.Run("TempDir", func(t *testing.T) {
+		assert.Equal(t, `C:\Windows\Temp`, a.Env.TempDir())
+
+		require.NoError(t, a.Setenv("TEMP", `D:\tmp`))
+		require.NoError(t, a.MkdirAll(`D:\tmp`, 0755))
+		dir, err := a.TempDir("", "test")
+		require.NoError(t, err)
+		assert.Regexp(t, `^D:\\tmp\\test\d+$`, dir)
+	})
+}
@@ -0,0 +1,197 @@
+package aferox
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/afero"
+)
+
+// WritePolicy restricts which paths can be modified through an Fsx, for
+// example to guarantee that an inspection command never writes. Paths are
+// checked after they are resolved with Abs, so relative paths and the working
+// directory are taken into account.
+//
+// When there are allowed or denied directories, paths are checked again with
+// their symbolic links resolved, so a link can't be used to write outside of
+// the allowed directories, or into a denied one. A link that points outside
+// of a jail is never writable. The link itself is checked, and not its
+// target, when it is removed, renamed or created. The directories are
+// resolved when the policy is set, so links created later below them are
+// followed, but links to them are not.
+//
+// Creating, writing, truncating, removing, renaming, and changing the mode,
+// owner or times of a file are all writes. A write that isn't allowed fails
+// with a *os.PathError wrapping os.ErrPermission, without calling the wrapped
+// filesystem. Processes started with Aferox.Command on the host filesystem
+// are not restricted.
+type WritePolicy struct {
+	// ReadOnly rejects every write.
+	ReadOnly bool
+
+	// Allow limits writes to the listed directories, and the files below them.
+	// When empty, every path is writable unless it is denied.
+	Allow []string
+
+	// Deny rejects writes to the listed directories, and the files below them,
+	// even when they are allowed.
+	Deny []string
+
+	// Audit is called for every write that is checked, with the operation, the
+	// absolute path and whether the write was allowed.
+	Audit func(op string, path string, allowed bool)
+}
+
+// resolve returns a copy of the policy with the directories resolved against
+// the working directory. Directories that are, or are below, a symbolic link
+// are listed both as given and with the links resolved.
+func (p *WritePolicy) resolve(r pathResolver) *WritePolicy {
+	if p == nil {
+		return nil
+	}
+
+	resolved := *p
+	resolved.Allow = resolveDirs(r, p.Allow)
+	resolved.Deny = resolveDirs(r, p.Deny)
+	return &resolved
+}
+
+// resolveDirs returns the absolute paths of the directories, followed by the
+// paths with their symbolic links resolved when they are different.
+func resolveDirs(r pathResolver, dirs []string) []string {
+	resolved := make([]string, 0, len(dirs))
+	for _, dir := range dirs {
+		resolved = append(resolved, r.abs(dir))
+	}
+	for _, dir := range resolved[:len(dirs)] {
+		if realDir, ok := r.evalSymlinks(dir, true); ok && !r.style.equal(realDir, dir) {
+			resolved = append(resolved, realDir)
+		}
+	}
+	return resolved
+}
+
+// check returns an error when the policy doesn't allow writing to the
+// absolute path. When there are allowed or denied directories, the path with
+// its symbolic links resolved, returned by resolve, is checked as well. It is
+// safe to call on a nil WritePolicy, which allows everything.
+func (p *WritePolicy) check(style PathStyle, op string, name string, path string, resolve func() (string, bool)) error {
+	if p == nil {
+		return nil
+	}
+
+	allowed := p.allows(style, path)
+	if allowed && (len(p.Allow) > 0 || len(p.Deny) > 0) {
+		realPath, ok := resolve()
+		allowed = ok && p.allows(style, realPath)
+	}
+	if p.Audit != nil {
+		p.Audit(op, path, allowed)
+	}
+	if !allowed {
+		return &os.PathError{Op: op, Path: name, Err: os.ErrPermission}
+	}
+	return nil
+}
+
+// allows determines if the policy allows writing to the absolute path.
+func (p *WritePolicy) allows(style PathStyle, path string) bool {
+	return !p.ReadOnly && (len(p.Allow) == 0 || hasAnyPathPrefix(style, path, p.Allow)) && !hasAnyPathPrefix(style, path, p.Deny)
+}
+
+// followsLink determines if the operation op acts on the target of a symbolic
+// link, rather than on the link itself.
+func followsLink(op string) bool {
+	switch op {
+	case "remove", "removeall", "rename", "symlink":
+		return false
+	}
+	return true
+}
+
+// evalSymlinks resolves the symbolic links in the absolute path, as seen by
+// the caller, using the wrapped Fs. The last element of the path is only
+// resolved when follow is set, and the elements below a file that doesn't
+// exist are kept as they are. The bool reports whether the resolved path is
+// visible to the caller, a link that points outside of a jail is not.
+func (r pathResolver) evalSymlinks(path string, follow bool) (string, bool) {
+	lstater, ok := r.fs.(afero.Lstater)
+	if !ok {
+		return path, true
+	}
+	reader, ok := r.fs.(afero.LinkReader)
+	if !ok {
+		return path, true
+	}
+
+	// Start from the root of the jail, which may itself be below a link on
+	// the host
+	var resolved, remaining string
+	realPath := r.jail.realPath(r.style.toHost(path))
+	if r.jail != nil {
+		resolved, remaining = r.jail.Root, strings.TrimPrefix(realPath[len(r.jail.Root):], string(filepath.Separator))
+	} else {
+		resolved, remaining = HostPathStyle.splitRoot(realPath)
+	}
+
+	for hops := 0; remaining != ""; {
+		var name string
+		if i := strings.IndexRune(remaining, filepath.Separator); i >= 0 {
+			name, remaining = remaining[:i], remaining[i+1:]
+		} else {
+			name, remaining = remaining, ""
+		}
+		if name == "" {
+			continue
+		}
+
+		next := filepath.Join(resolved, name)
+		if remaining == "" && !follow {
+			resolved = next
+			break
+		}
+		fi, _, err := lstater.LstatIfPossible(next)
+		if err != nil || fi.Mode()&os.ModeSymlink == 0 {
+			resolved = next
+			continue
+		}
+
+		hops++
+		if hops > maxSymlinkHops {
+			return "", false
+		}
+		target, err := reader.ReadlinkIfPossible(next)
+		if err != nil {
+			return "", false
+		}
+		if !filepath.IsAbs(target) {
+			target = filepath.Join(resolved, target)
+		}
+		resolved, remaining = HostPathStyle.splitRoot(filepath.Join(target, remaining))
+	}
+	return r.virtualPath(resolved)
+}
+
+// hasAnyPathPrefix determines if path is, or is below, any of the directories.
+func hasAnyPathPrefix(style PathStyle, path string, dirs []string) bool {
+	for _, dir := range dirs {
+		if style.hasPathPrefix(path, dir) {
+			return true
+		}
+	}
+	return false
+}
+
+// hasPathPrefix determines if the cleaned path is dir, or is below dir.
+func (s PathStyle) hasPathPrefix(path string, dir string) bool {
+	if len(path) < len(dir) || !s.equal(path[:len(dir)], dir) {
+		return false
+	}
+	return len(path) == len(dir) || s.isSeparator(path[len(dir)]) || s.isSeparator(dir[len(dir)-1])
+}
+
+// isWriteFlag determines if opening a file with the flags modifies it.
+func isWriteFlag(flag int) bool {
+	return flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC|os.O_APPEND) != 0
+}
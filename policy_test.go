@@ -0,0 +1,158 @@
+package aferox
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func assertPermissionError(t *testing.T, err error, path string) {
+	require.Error(t, err)
+	pathErr, ok := err.(*os.PathError)
+	require.True(t, ok, "expected a *os.PathError, got %T", err)
+	assert.Equal(t, path, pathErr.Path)
+	assert.True(t, errors.Is(err, os.ErrPermission), "expected os.ErrPermission, got %v", err)
+}
+
+func TestFsx_SetWritePolicy_ReadOnly(t *testing.T) {
+	a := NewAferox("/home/me", afero.NewMemMapFs())
+	require.NoError(t, a.WriteFile("porter.yaml", []byte("name: mybuns"), 0644))
+	a.SetWritePolicy(&WritePolicy{ReadOnly: true})
+
+	err := a.WriteFile("porter.yaml", nil, 0644)
+	assertPermissionError(t, err, "porter.yaml")
+
+	err = a.Mkdir("/tmp", 0755)
+	assertPermissionError(t, err, "/tmp")
+
+	err = a.Chmod("porter.yaml", 0600)
+	assertPermissionError(t, err, "porter.yaml")
+
+	err = a.RemoveAll("/home")
+	assertPermissionError(t, err, "/home")
+
+	data, err := a.ReadFile("porter.yaml")
+	require.NoError(t, err, "reads should be allowed")
+	assert.Equal(t, "name: mybuns", string(data))
+
+	f, err := a.OpenFile("porter.yaml", os.O_RDONLY, 0)
+	require.NoError(t, err, "opening a file to read it should be allowed")
+	f.Close()
+
+	a.SetWritePolicy(nil)
+	require.NoError(t, a.WriteFile("porter.yaml", nil, 0644))
+}
+
+func TestFsx_SetWritePolicy_Allow(t *testing.T) {
+	a := NewAferox("/home/me", afero.NewMemMapFs())
+	require.NoError(t, a.MkdirAll("/home/me/.porter/cache", 0755))
+	require.NoError(t, a.MkdirAll("/tmp", 0755))
+
+	var audit []string
+	a.SetWritePolicy(&WritePolicy{
+		Allow: []string{".porter", "/tmp/"},
+		Deny:  []string{".porter/cache"},
+		Audit: func(op string, path string, allowed bool) {
+			if !allowed {
+				audit = append(audit, op+" "+path)
+			}
+		},
+	})
+
+	// Directories are resolved when the policy is set
+	require.NoError(t, a.Chdir("/tmp"))
+
+	require.NoError(t, a.WriteFile("/home/me/.porter/config.toml", nil, 0644))
+	require.NoError(t, a.WriteFile("scratch.txt", nil, 0644))
+
+	err := a.WriteFile("/home/me/.porter/cache/bundle.json", nil, 0644)
+	assertPermissionError(t, err, "/home/me/.porter/cache/bundle.json")
+
+	err = a.WriteFile("/home/me/.porter.bak", nil, 0644)
+	assertPermissionError(t, err, "/home/me/.porter.bak")
+
+	t.Run("rename checks both paths", func(t *testing.T) {
+		err := a.Rename("scratch.txt", "/home/me/scratch.txt")
+		assertPermissionError(t, err, "/home/me/scratch.txt")
+
+		require.NoError(t, a.Rename("scratch.txt", "/home/me/.porter/scratch.txt"))
+	})
+
+	assert.Equal(t, []string{
+		"open /home/me/.porter/cache/bundle.json",
+		"open /home/me/.porter.bak",
+		"rename /home/me/scratch.txt",
+	}, audit)
+}
+
+func TestFsx_SetWritePolicy_Fork(t *testing.T) {
+	f := NewFsx("/", afero.NewMemMapFs())
+	f.SetWritePolicy(&WritePolicy{ReadOnly: true})
+
+	_, err := f.fork("/tmp").Create("out.txt")
+	assertPermissionError(t, err, "out.txt")
+}
+
+func TestFsx_SetWritePolicy_Symlink(t *testing.T) {
+	a := NewAferox("/home/me", NewSymlinkFs(afero.NewMemMapFs()))
+	require.NoError(t, a.MkdirAll("/home/me/.porter/cache", 0755))
+	require.NoError(t, a.MkdirAll("/etc", 0755))
+	require.NoError(t, a.Fs.SymlinkIfPossible("/etc", ".porter/etc"))
+	require.NoError(t, a.Fs.SymlinkIfPossible("cache", ".porter/cached"))
+	require.NoError(t, a.Fs.SymlinkIfPossible("/etc/passwd", ".porter/passwd"))
+	require.NoError(t, a.MkdirAll("/home/me/porter-data", 0755))
+	require.NoError(t, a.Fs.SymlinkIfPossible("/home/me/porter-data", "/home/me/.porter/data"))
+
+	a.SetWritePolicy(&WritePolicy{
+		Allow: []string{".porter"},
+		Deny:  []string{".porter/cache"},
+	})
+
+	err := a.WriteFile(".porter/etc/passwd", nil, 0644)
+	assertPermissionError(t, err, ".porter/etc/passwd")
+
+	err = a.WriteFile(".porter/passwd", nil, 0644)
+	assertPermissionError(t, err, ".porter/passwd")
+
+	err = a.WriteFile(".porter/cached/index.json", nil, 0644)
+	assertPermissionError(t, err, ".porter/cached/index.json")
+
+	err = a.WriteFile(".porter/data/out.txt", nil, 0644)
+	assertPermissionError(t, err, ".porter/data/out.txt")
+
+	require.NoError(t, a.WriteFile(".porter/config.yaml", nil, 0644))
+	require.NoError(t, a.Remove(".porter/passwd"), "removing the link itself should be allowed")
+	exists, _ := a.Exists("/etc")
+	assert.True(t, exists, "the target of a removed link should be left alone")
+}
+
+func TestFsx_SetWritePolicy_SymlinkInAllowedDir(t *testing.T) {
+	a := NewAferox("/", NewSymlinkFs(afero.NewMemMapFs()))
+	require.NoError(t, a.MkdirAll("/data/porter", 0755))
+	require.NoError(t, a.Fs.SymlinkIfPossible("/data/porter", "/porter"))
+
+	a.SetWritePolicy(&WritePolicy{Allow: []string{"/porter"}})
+
+	require.NoError(t, a.WriteFile("/porter/config.yaml", nil, 0644), "writing through an allowed link should be allowed")
+	err := a.WriteFile("/data/other.txt", nil, 0644)
+	assertPermissionError(t, err, "/data/other.txt")
+}
+
+func TestFsx_SetWritePolicy_SymlinkOutOfJail(t *testing.T) {
+	fs := NewSymlinkFs(afero.NewMemMapFs())
+	require.NoError(t, fs.MkdirAll(xplat("/jail/home"), 0755))
+	require.NoError(t, fs.MkdirAll(xplat("/outside"), 0755))
+	require.NoError(t, fs.SymlinkIfPossible(xplat("/outside"), xplat("/jail/home/out")))
+
+	a, err := NewJailedAferox(Jail{Root: xplat("/jail")}, "/home", fs)
+	require.NoError(t, err, "NewJailedAferox failed")
+	a.SetWritePolicy(&WritePolicy{Allow: []string{"/home"}})
+
+	require.NoError(t, a.WriteFile("notes.txt", nil, 0644))
+	err = a.WriteFile("out/notes.txt", nil, 0644)
+	assertPermissionError(t, err, "out/notes.txt")
+}
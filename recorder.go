@@ -0,0 +1,265 @@
+package aferox
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Recorder keeps a log of the calls made through an Fsx, to see exactly what
+// code did to the filesystem, for example when a test fails. Use
+// Fsx.SetRecorder to start recording. Reads and writes on an opened file are
+// not recorded, only the call that opened it.
+//
+// The zero value is an empty Recorder ready to use. A Recorder is safe for
+// concurrent use, and can be shared by several Fsx.
+type Recorder struct {
+	mu  sync.Mutex
+	ops Operations
+}
+
+// Operation is a single call recorded by a Recorder.
+type Operation struct {
+	// Op is the name of the Fsx method that was called, such as "OpenFile" or
+	// "Rename". Changing the working directory is recorded as "Chdir", except
+	// with ChdirUnchecked, which is recorded as "ChdirUnchecked".
+	Op string
+
+	// Path is the path as given to the method.
+	Path string
+
+	// AbsPath is Path after it is resolved with Abs.
+	AbsPath string
+
+	// NewPath is the second path as given to Rename and SymlinkIfPossible,
+	// the new name or the link.
+	NewPath string
+
+	// NewAbsPath is NewPath after it is resolved with Abs.
+	NewAbsPath string
+
+	// Flag holds the flags used to open the file, for Create and OpenFile.
+	Flag int
+
+	// Perm holds the permission bits, or the mode for Chmod.
+	Perm os.FileMode
+
+	// Result describes the value returned by the method, such as the mode
+	// returned by Stat, or the target returned by ReadlinkIfPossible. It is
+	// empty when the method only returns an error.
+	Result string
+
+	// Err is the error returned by the method.
+	Err error
+
+	// Duration is how long the call took.
+	Duration time.Duration
+
+	// Dir is the working directory used to resolve the paths.
+	Dir string
+
+	// style is used to compare paths.
+	style PathStyle
+}
+
+// IsWrite determines if the operation modifies the filesystem, or would have
+// if it succeeded.
+func (o Operation) IsWrite() bool {
+	switch o.Op {
+	case "Create", "Mkdir", "MkdirAll", "Remove", "RemoveAll", "Rename", "Chmod", "Chown", "Chtimes", "SymlinkIfPossible":
+		return true
+	case "OpenFile":
+		return isWriteFlag(o.Flag)
+	default:
+		return false
+	}
+}
+
+// String formats the operation like the method call, followed by the
+// absolute paths and the result, for example:
+//
+//	OpenFile("porter.yaml", O_WRONLY|O_CREATE|O_TRUNC, 0644) in /home/me -> /home/me/porter.yaml: ok [15µs]
+func (o Operation) String() string {
+	args := []string{fmt.Sprintf("%q", o.Path)}
+	paths := o.AbsPath
+	switch o.Op {
+	case "Rename", "SymlinkIfPossible":
+		args = append(args, fmt.Sprintf("%q", o.NewPath))
+		paths += ", " + o.NewAbsPath
+	case "OpenFile":
+		args = append(args, formatFlag(o.Flag), fmt.Sprintf("%#o", uint32(o.Perm)))
+	case "Mkdir", "MkdirAll", "Chmod":
+		args = append(args, fmt.Sprintf("%#o", uint32(o.Perm)))
+	}
+
+	result := "ok"
+	if o.Err != nil {
+		result = o.Err.Error()
+	} else if o.Result != "" {
+		result = o.Result
+	}
+	return fmt.Sprintf("%s(%s) in %s -> %s: %s [%s]", o.Op, strings.Join(args, ", "), o.Dir, paths, result, o.Duration)
+}
+
+// formatFlag formats the flags passed to OpenFile, like O_WRONLY|O_CREATE.
+func formatFlag(flag int) string {
+	var names []string
+	switch flag & (os.O_RDONLY | os.O_WRONLY | os.O_RDWR) {
+	case os.O_WRONLY:
+		names = append(names, "O_WRONLY")
+	case os.O_RDWR:
+		names = append(names, "O_RDWR")
+	default:
+		names = append(names, "O_RDONLY")
+	}
+
+	flags := []struct {
+		flag int
+		name string
+	}{
+		{os.O_APPEND, "O_APPEND"},
+		{os.O_CREATE, "O_CREATE"},
+		{os.O_EXCL, "O_EXCL"},
+		{os.O_SYNC, "O_SYNC"},
+		{os.O_TRUNC, "O_TRUNC"},
+	}
+	for _, f := range flags {
+		if flag&f.flag != 0 {
+			names = append(names, f.name)
+		}
+	}
+	return strings.Join(names, "|")
+}
+
+// Operations is a list of recorded operations, in the order they completed.
+type Operations []Operation
+
+// Filter returns the operations for which keep returns true.
+func (ops Operations) Filter(keep func(Operation) bool) Operations {
+	var filtered Operations
+	for _, op := range ops {
+		if keep(op) {
+			filtered = append(filtered, op)
+		}
+	}
+	return filtered
+}
+
+// Writes returns the operations that modify the filesystem.
+func (ops Operations) Writes() Operations {
+	return ops.Filter(Operation.IsWrite)
+}
+
+// Reads returns the operations that don't modify the filesystem.
+func (ops Operations) Reads() Operations {
+	return ops.Filter(func(op Operation) bool {
+		return !op.IsWrite()
+	})
+}
+
+// Failed returns the operations that returned an error.
+func (ops Operations) Failed() Operations {
+	return ops.Filter(func(op Operation) bool {
+		return op.Err != nil
+	})
+}
+
+// Path returns the operations on the absolute path, including renames from
+// or to it.
+func (ops Operations) Path(path string) Operations {
+	return ops.Filter(func(op Operation) bool {
+		return op.style.equal(op.AbsPath, op.style.clean(path)) ||
+			(op.NewAbsPath != "" && op.style.equal(op.NewAbsPath, op.style.clean(path)))
+	})
+}
+
+// Under returns the operations on the absolute directory, and on the files
+// below it.
+func (ops Operations) Under(dir string) Operations {
+	return ops.Filter(func(op Operation) bool {
+		dir := op.style.clean(dir)
+		return op.style.hasPathPrefix(op.AbsPath, dir) ||
+			(op.NewAbsPath != "" && op.style.hasPathPrefix(op.NewAbsPath, dir))
+	})
+}
+
+// String formats the operations one per line, numbered in the order they
+// completed.
+func (ops Operations) String() string {
+	var b strings.Builder
+	for i, op := range ops {
+		fmt.Fprintf(&b, "%3d %s\n", i+1, op)
+	}
+	return b.String()
+}
+
+// Operations returns a copy of the recorded operations.
+func (r *Recorder) Operations() Operations {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append(Operations(nil), r.ops...)
+}
+
+// WasWritten determines if the absolute path was modified, or if there was an
+// attempt to modify it.
+func (r *Recorder) WasWritten(path string) bool {
+	return len(r.Operations().Writes().Path(path)) > 0
+}
+
+// Reset discards the recorded operations.
+func (r *Recorder) Reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.ops = nil
+}
+
+// String formats the recorded operations, see Operations.String.
+func (r *Recorder) String() string {
+	return r.Operations().String()
+}
+
+// add records an operation.
+func (r *Recorder) add(op Operation) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.ops = append(r.ops, op)
+}
+
+// record is deferred by the Fsx methods to record the call once it returns.
+// err points to the error returned by the method.
+func (r pathResolver) record(op Operation, start time.Time, err *error) {
+	r.recordResult(op, start, err, nil)
+}
+
+// recordResult records the call like record, with result describing the
+// value returned by the method.
+func (r pathResolver) recordResult(op Operation, start time.Time, err *error, result func() string) {
+	if r.recorder == nil {
+		return
+	}
+
+	op.Duration = time.Since(start)
+	op.Dir = r.dir
+	op.style = r.style
+	if op.AbsPath == "" {
+		op.AbsPath = r.abs(op.Path)
+	}
+	if op.Op == "Rename" || op.Op == "SymlinkIfPossible" {
+		op.NewAbsPath = r.abs(op.NewPath)
+	}
+	op.Err = *err
+	if op.Err == nil && result != nil {
+		op.Result = result()
+	}
+	r.recorder.add(op)
+}
+
+// describeFileInfo describes the result of Stat for a Recorder.
+func describeFileInfo(fi os.FileInfo) string {
+	if fi == nil {
+		return ""
+	}
+	return fi.Mode().String()
+}
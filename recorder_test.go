@@ -0,0 +1,125 @@
+package aferox
+
+import (
+	"os"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFsx_SetRecorder(t *testing.T) {
+	a := NewAferox("/home/me", afero.NewMemMapFs())
+	require.NoError(t, a.MkdirAll("/tmp", 0755))
+
+	rec := &Recorder{}
+	a.SetRecorder(rec)
+
+	require.NoError(t, a.WriteFile("porter.yaml", []byte("name: mybuns"), 0644))
+	require.NoError(t, a.Chdir("/tmp"))
+	_, err := a.ReadFile("/home/me/porter.yaml")
+	require.NoError(t, err)
+	_, err = a.Stat("missing.txt")
+	require.Error(t, err)
+	require.NoError(t, a.Rename("/home/me/porter.yaml", "porter.yaml"))
+	a.ChdirUnchecked("../tmp")
+
+	ops := rec.Operations()
+	require.Len(t, ops, 6)
+
+	write := ops[0]
+	assert.Equal(t, "OpenFile", write.Op)
+	assert.Equal(t, "porter.yaml", write.Path)
+	assert.Equal(t, "/home/me/porter.yaml", write.AbsPath)
+	assert.Equal(t, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, write.Flag)
+	assert.Equal(t, os.FileMode(0644), write.Perm)
+	assert.Equal(t, "/home/me", write.Dir)
+	assert.NoError(t, write.Err)
+
+	chdir := ops[1]
+	assert.Equal(t, "Chdir", chdir.Op)
+	assert.Equal(t, "/home/me", chdir.Dir)
+
+	stat := ops[3]
+	assert.Equal(t, "Stat", stat.Op)
+	assert.Equal(t, "/tmp/missing.txt", stat.AbsPath)
+	assert.Equal(t, "/tmp", stat.Dir)
+	assert.True(t, os.IsNotExist(stat.Err), "expected the error to be recorded, got %v", stat.Err)
+
+	rename := ops[4]
+	assert.Equal(t, "/home/me/porter.yaml", rename.AbsPath)
+	assert.Equal(t, "porter.yaml", rename.NewPath)
+	assert.Equal(t, "/tmp/porter.yaml", rename.NewAbsPath)
+
+	chdirUnchecked := ops[5]
+	assert.Equal(t, "ChdirUnchecked", chdirUnchecked.Op)
+	assert.Equal(t, "../tmp", chdirUnchecked.Path)
+	assert.Equal(t, "/tmp", chdirUnchecked.AbsPath)
+	assert.Equal(t, "/tmp", chdirUnchecked.Dir)
+
+	t.Run("queries", func(t *testing.T) {
+		assert.True(t, rec.WasWritten("/home/me/porter.yaml"))
+		assert.True(t, rec.WasWritten("/tmp/porter.yaml"), "expected the destination of a rename to be written")
+		assert.False(t, rec.WasWritten("/tmp/missing.txt"))
+
+		reads := ops.Reads().Under("/home")
+		require.Len(t, reads, 1)
+		assert.Equal(t, "Open", reads[0].Op)
+
+		assert.Len(t, ops.Failed(), 1)
+		assert.Len(t, ops.Path("/tmp/missing.txt"), 1)
+	})
+
+	t.Run("forked filesystems share the recorder", func(t *testing.T) {
+		rec.Reset()
+		_, err := a.Fs.fork("/").Stat("tmp")
+		require.NoError(t, err)
+		ops := rec.Operations()
+		require.Len(t, ops, 1)
+		assert.Equal(t, "drwxr-xr-x", ops[0].Result)
+	})
+
+	t.Run("stop recording", func(t *testing.T) {
+		rec.Reset()
+		a.SetRecorder(nil)
+		_, err := a.Stat("porter.yaml")
+		require.NoError(t, err)
+		assert.Empty(t, rec.Operations())
+	})
+}
+
+func TestFsx_SetRecorder_InDir(t *testing.T) {
+	a := NewAferox("/home/me", afero.NewMemMapFs())
+	require.NoError(t, a.MkdirAll("/tmp", 0755))
+	rec := &Recorder{}
+	a.SetRecorder(rec)
+
+	assert.Panics(t, func() {
+		a.InDir("/tmp", func() error {
+			panic("oops")
+		})
+	})
+
+	ops := rec.Operations()
+	require.Len(t, ops, 2)
+	assert.Equal(t, "Chdir", ops[0].Op)
+	assert.Equal(t, "/tmp", ops[0].AbsPath)
+	assert.Equal(t, "Chdir", ops[1].Op, "expected the change back to be recorded")
+	assert.Equal(t, "/home/me", ops[1].AbsPath)
+	assert.Equal(t, "/tmp", ops[1].Dir)
+}
+
+func TestOperations_String(t *testing.T) {
+	ops := Operations{
+		{Op: "OpenFile", Path: "porter.yaml", AbsPath: "/home/me/porter.yaml", Flag: os.O_WRONLY | os.O_CREATE | os.O_TRUNC, Perm: 0644, Dir: "/home/me"},
+		{Op: "Rename", Path: "a", AbsPath: "/tmp/a", NewPath: "b", NewAbsPath: "/tmp/b", Dir: "/tmp", Err: &os.LinkError{Op: "rename", Old: "/tmp/a", New: "/tmp/b", Err: os.ErrNotExist}},
+		{Op: "Stat", Path: "/tmp", AbsPath: "/tmp", Result: "drwxr-xr-x", Dir: "/"},
+	}
+
+	want := `  1 OpenFile("porter.yaml", O_WRONLY|O_CREATE|O_TRUNC, 0644) in /home/me -> /home/me/porter.yaml: ok [0s]
+  2 Rename("a", "b") in /tmp -> /tmp/a, /tmp/b: rename /tmp/a /tmp/b: file does not exist [0s]
+  3 Stat("/tmp") in / -> /tmp: drwxr-xr-x [0s]
+`
+	assert.Equal(t, want, ops.String())
+}
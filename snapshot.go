@@ -0,0 +1,256 @@
+package aferox
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"time"
+)
+
+// ErrSnapshotHostFs is returned by Snapshot when the filesystem is the host
+// filesystem and isn't jailed, since capturing it would read every file on the
+// host, and restoring it would remove every file created on the host since.
+var ErrSnapshotHostFs = errors.New("refusing to snapshot the whole host filesystem")
+
+// chmodMode is the part of a file mode that can be changed with Chmod.
+const chmodMode = os.ModePerm | os.ModeSetuid | os.ModeSetgid | os.ModeSticky
+
+// Snapshot is the state of an Aferox filesystem captured by Aferox.Snapshot or
+// Aferox.SnapshotDir, which can be restored later with Aferox.Restore. The
+// contents of files are held in memory, so snapshots are meant for small
+// trees, such as test fixtures.
+type Snapshot struct {
+	// root is the absolute path of the directory that was captured.
+	root string
+
+	// style of the paths of the filesystem that was captured.
+	style PathStyle
+
+	// dir is the working directory.
+	dir string
+
+	// entries holds every file below root, including root, in the order that
+	// they were walked, so directories come before the files in them.
+	entries []snapshotEntry
+}
+
+// snapshotEntry is a file, directory or symbolic link in a Snapshot.
+type snapshotEntry struct {
+	// rel is the path relative to the root of the snapshot.
+	rel string
+
+	mode    os.FileMode
+	modTime time.Time
+
+	// data is the contents of a file.
+	data []byte
+
+	// target is the destination of a symbolic link, as returned by
+	// Fsx.ReadlinkIfPossible.
+	target string
+
+	// storedTarget is the destination of a symbolic link exactly as it is
+	// stored in the wrapped Fs, so that a relative link is restored relative
+	// to its own directory.
+	storedTarget string
+}
+
+// Dir returns the working directory when the snapshot was taken.
+func (s *Snapshot) Dir() string {
+	return s.dir
+}
+
+// Snapshot captures the complete tree of the filesystem: the contents, modes
+// and modification times of every file and directory, the targets of
+// symbolic links, and the working directory. When the filesystem is jailed,
+// or uses afero.BasePathFs, only the tree visible through it is captured, so
+// use one of them for a scratch directory on the host filesystem. The host
+// filesystem itself is rejected with ErrSnapshotHostFs, use SnapshotDir to
+// capture a directory on it instead. With Windows
+// paths, only the drive of the working directory is captured. Use SnapshotDir
+// to capture only part of the tree.
+//
+// The contents of every file are copied when the snapshot is taken, even on
+// an afero.MemMapFs. Sharing them copy-on-write isn't possible: MemMapFs keeps
+// the data of a file in an unexported field of mem.FileData, and writes
+// modify it in place, so there is no way to hold on to the data without
+// copying it, or to be told before it changes. Restore only writes the files
+// that changed, so restoring is cheap, while taking a snapshot reads the whole
+// tree.
+func (a Aferox) Snapshot() (*Snapshot, error) {
+	if a.Fs.resolver().jail == nil && isHostFs(a.Fs.fs) {
+		return nil, ErrSnapshotHostFs
+	}
+	return a.SnapshotDir(a.Fs.PathStyle().separator())
+}
+
+// SnapshotDir captures the tree below dir, resolved against the working
+// directory, like Snapshot. Restore leaves the files outside of the tree
+// alone.
+func (a Aferox) SnapshotDir(dir string) (*Snapshot, error) {
+	root := a.Abs(dir)
+	entries, err := a.captureTree(root, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &Snapshot{root: root, style: a.Fs.PathStyle(), dir: a.Getwd(), entries: entries}, nil
+}
+
+// captureTree reads every file below the absolute root, including root,
+// except those matching the exclude patterns, see WalkDirOptions.Exclude.
+func (a Aferox) captureTree(root string, exclude []string) ([]snapshotEntry, error) {
+	style := a.Fs.PathStyle()
+	var entries []snapshotEntry
+	opts := WalkDirOptions{RelativePaths: true, Exclude: exclude}
+	err := a.WalkDir(context.Background(), root, opts, func(rel string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		entry := snapshotEntry{rel: rel, mode: fi.Mode(), modTime: fi.ModTime()}
+		path := style.join(root, rel)
+		switch {
+		case fi.Mode()&os.ModeSymlink != 0:
+			if entry.target, err = a.Fs.ReadlinkIfPossible(path); err == nil {
+				entry.storedTarget, err = a.Fs.readlinkStored(path)
+			}
+		case fi.Mode().IsRegular():
+			entry.data, err = a.ReadFile(path)
+		}
+		if err != nil {
+			return err
+		}
+		entries = append(entries, entry)
+		return nil
+	})
+	return entries, err
+}
+
+// Restore rolls the tree captured by a snapshot back to its state when the
+// snapshot was taken, and changes the working directory back. Files created
+// in the tree since the snapshot are removed, and only the files that changed
+// are written again, so restoring a large tree after a few changes is cheap.
+// Files outside of the tree are left alone.
+func (a Aferox) Restore(s *Snapshot) error {
+	style := a.Fs.PathStyle()
+	current := make(map[string]os.FileInfo)
+	var order []string
+	opts := WalkDirOptions{RelativePaths: true}
+	err := a.WalkDir(context.Background(), s.root, opts, func(rel string, d fs.DirEntry, err error) error {
+		if err != nil {
+			// The root is created again when it was removed
+			if rel == "." && os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+		current[rel] = fi
+		order = append(order, rel)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	wanted := make(map[string]snapshotEntry, len(s.entries))
+	for _, entry := range s.entries {
+		wanted[entry.rel] = entry
+	}
+
+	// Remove what didn't exist, or was a different type of file, starting
+	// with the deepest files
+	for i := len(order) - 1; i >= 0; i-- {
+		rel := order[i]
+		entry, ok := wanted[rel]
+		if ok && entry.mode.Type() == current[rel].Mode().Type() {
+			continue
+		}
+		if err := a.RemoveAll(style.join(s.root, rel)); err != nil {
+			return err
+		}
+		delete(current, rel)
+	}
+
+	for _, entry := range s.entries {
+		if err := a.restoreEntry(style.join(s.root, entry.rel), entry, current[entry.rel]); err != nil {
+			return err
+		}
+	}
+
+	// Writing to a directory changes its modification time, so restore them
+	// last, starting with the deepest directories
+	for i := len(s.entries) - 1; i >= 0; i-- {
+		entry := s.entries[i]
+		if !entry.mode.IsDir() {
+			continue
+		}
+		path := style.join(s.root, entry.rel)
+		if err := a.Chtimes(path, entry.modTime, entry.modTime); err != nil {
+			return err
+		}
+	}
+
+	a.Fs.restoreDir(s.dir)
+	return nil
+}
+
+// restoreEntry restores a file, directory or symbolic link at path. The
+// current file is nil when it doesn't exist.
+func (a Aferox) restoreEntry(path string, entry snapshotEntry, current os.FileInfo) error {
+	switch {
+	case entry.mode&os.ModeSymlink != 0:
+		if current != nil {
+			if target, err := a.Fs.readlinkStored(path); err == nil && target == entry.storedTarget {
+				return nil
+			}
+			if err := a.Remove(path); err != nil {
+				return err
+			}
+		}
+		return a.Fs.symlinkStored(entry.storedTarget, path)
+
+	case entry.mode.IsDir():
+		// The parents of the root may have been removed as well
+		if current == nil {
+			if err := a.MkdirAll(path, entry.mode.Perm()); err != nil {
+				return err
+			}
+		}
+
+	default:
+		if current == nil || !a.sameFile(path, entry, current) {
+			if err := a.WriteFile(path, entry.data, entry.mode.Perm()); err != nil {
+				return err
+			}
+		}
+		if err := a.Chtimes(path, entry.modTime, entry.modTime); err != nil {
+			return err
+		}
+	}
+
+	if current == nil || current.Mode()&chmodMode != entry.mode&chmodMode {
+		return a.Chmod(path, entry.mode&chmodMode)
+	}
+	return nil
+}
+
+// sameFile determines if the file at path still has the contents captured
+// by a snapshot. The contents are only compared when the size and
+// modification time haven't changed.
+func (a Aferox) sameFile(path string, entry snapshotEntry, current os.FileInfo) bool {
+	if current.Size() != int64(len(entry.data)) || !current.ModTime().Equal(entry.modTime) {
+		return false
+	}
+	data, err := a.ReadFile(path)
+	return err == nil && bytes.Equal(data, entry.data)
+}
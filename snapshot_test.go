@@ -0,0 +1,166 @@
+package aferox
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testSnapshotRestore(t *testing.T, a Aferox) {
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	require.NoError(t, a.MkdirAll("/home/me/.porter", 0755))
+	require.NoError(t, a.WriteFile("/home/me/.porter/config.toml", []byte("debug = true"), 0600))
+	require.NoError(t, a.WriteFile("/home/me/porter.yaml", []byte("name: mybuns"), 0644))
+	require.NoError(t, a.Chtimes("/home/me/porter.yaml", mtime, mtime))
+	require.NoError(t, a.Chdir("/home/me"))
+
+	snap, err := a.Snapshot()
+	require.NoError(t, err)
+	assert.Equal(t, "/home/me", snap.Dir())
+
+	// Change everything captured by the snapshot
+	require.NoError(t, a.WriteFile("porter.yaml", []byte("name: changed"), 0644))
+	require.NoError(t, a.Chmod(".porter/config.toml", 0644))
+	require.NoError(t, a.RemoveAll(".porter"))
+	require.NoError(t, a.MkdirAll("/tmp/cache", 0755))
+	require.NoError(t, a.WriteFile("/tmp/cache/bundle.json", nil, 0644))
+	require.NoError(t, a.Chdir("/tmp"))
+
+	require.NoError(t, a.Restore(snap))
+	assert.Equal(t, "/home/me", a.Getwd())
+
+	data, err := a.ReadFile("porter.yaml")
+	require.NoError(t, err)
+	assert.Equal(t, "name: mybuns", string(data))
+	fi, err := a.Stat("porter.yaml")
+	require.NoError(t, err)
+	assert.True(t, fi.ModTime().Equal(mtime), "expected the modification time to be restored, got %s", fi.ModTime())
+
+	data, err = a.ReadFile(".porter/config.toml")
+	require.NoError(t, err)
+	assert.Equal(t, "debug = true", string(data))
+	if runtime.GOOS != "windows" {
+		fi, err = a.Stat(".porter/config.toml")
+		require.NoError(t, err)
+		assert.Equal(t, os.FileMode(0600), fi.Mode().Perm())
+	}
+
+	exists, _ := a.Exists("/tmp")
+	assert.False(t, exists, "expected files created after the snapshot to be removed")
+
+	// A snapshot can be restored more than once
+	require.NoError(t, a.WriteFile("porter.yaml", []byte("name: again"), 0644))
+	require.NoError(t, a.Restore(snap))
+	data, err = a.ReadFile("porter.yaml")
+	require.NoError(t, err)
+	assert.Equal(t, "name: mybuns", string(data))
+}
+
+func TestAferox_Snapshot(t *testing.T) {
+	a := NewAferox("/", afero.NewMemMapFs())
+	testSnapshotRestore(t, a)
+}
+
+func TestAferox_Snapshot_OsFs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "aferox")
+	require.NoError(t, err, "create temp directory failed")
+	defer os.RemoveAll(dir)
+
+	a, err := NewJailedAferox(Jail{Root: dir}, "/", afero.NewOsFs())
+	require.NoError(t, err, "NewJailedAferox failed")
+	testSnapshotRestore(t, a)
+}
+
+func TestAferox_Snapshot_HostFs(t *testing.T) {
+	a := NewAferox("/", afero.NewOsFs())
+	_, err := a.Snapshot()
+	assert.Equal(t, ErrSnapshotHostFs, err, "expected the unjailed host filesystem to be rejected")
+
+	a = NewAferox("/", NewCaseInsensitiveFs(afero.NewOsFs()))
+	_, err = a.Snapshot()
+	assert.Equal(t, ErrSnapshotHostFs, err, "expected wrappers around the host filesystem to be rejected")
+}
+
+func TestAferox_SnapshotDir(t *testing.T) {
+	a := NewAferox("/home/me", afero.NewMemMapFs())
+	require.NoError(t, a.WriteFile("/home/me/porter.yaml", []byte("name: mybuns"), 0644))
+
+	snap, err := a.SnapshotDir(".")
+	require.NoError(t, err)
+
+	require.NoError(t, a.WriteFile("porter.yaml", []byte("name: changed"), 0644))
+	require.NoError(t, a.WriteFile("/tmp/scratch.txt", nil, 0644))
+
+	require.NoError(t, a.Restore(snap))
+	data, err := a.ReadFile("/home/me/porter.yaml")
+	require.NoError(t, err)
+	assert.Equal(t, "name: mybuns", string(data))
+	exists, _ := a.Exists("/tmp/scratch.txt")
+	assert.True(t, exists, "expected files outside of the snapshot to be left alone")
+}
+
+func TestAferox_Restore_RemovedRoot(t *testing.T) {
+	a := NewAferox("/home/me", afero.NewMemMapFs())
+	require.NoError(t, a.WriteFile("/home/me/porter.yaml", []byte("name: mybuns"), 0644))
+
+	snap, err := a.SnapshotDir(".")
+	require.NoError(t, err)
+
+	require.NoError(t, a.RemoveAll("/home"))
+	require.NoError(t, a.Restore(snap))
+	data, err := a.ReadFile("/home/me/porter.yaml")
+	require.NoError(t, err)
+	assert.Equal(t, "name: mybuns", string(data))
+}
+
+func TestAferox_Restore_Symlink(t *testing.T) {
+	a := NewAferox("/", NewSymlinkFs(afero.NewMemMapFs()))
+	require.NoError(t, a.WriteFile("/config.toml", nil, 0644))
+	require.NoError(t, a.Fs.SymlinkIfPossible("/config.toml", "/current"))
+
+	snap, err := a.Snapshot()
+	require.NoError(t, err)
+
+	require.NoError(t, a.Remove("/current"))
+	require.NoError(t, a.WriteFile("/other.toml", nil, 0644))
+	require.NoError(t, a.Fs.SymlinkIfPossible("/other.toml", "/current"))
+
+	require.NoError(t, a.Restore(snap))
+	target, err := a.Fs.ReadlinkIfPossible("/current")
+	require.NoError(t, err)
+	assert.Equal(t, "/config.toml", target)
+}
+
+func TestAferox_Restore_RelativeSymlink(t *testing.T) {
+	dir, err := ioutil.TempDir("", "aferox")
+	require.NoError(t, err, "create temp directory failed")
+	defer os.RemoveAll(dir)
+
+	a, err := NewJailedAferox(Jail{Root: dir}, "/", afero.NewOsFs())
+	require.NoError(t, err, "NewJailedAferox failed")
+	require.NoError(t, a.MkdirAll("/sub", 0755))
+	require.NoError(t, a.WriteFile("/sub/b", []byte("b"), 0644))
+	if err := os.Symlink("b", filepath.Join(dir, "sub", "link")); err != nil {
+		t.Skipf("symbolic links are not supported: %s", err)
+	}
+
+	snap, err := a.Snapshot()
+	require.NoError(t, err)
+
+	require.NoError(t, a.RemoveAll("/sub"))
+	require.NoError(t, a.Restore(snap))
+
+	target, err := os.Readlink(filepath.Join(dir, "sub", "link"))
+	require.NoError(t, err)
+	assert.Equal(t, "b", target, "expected the link to stay relative to its directory")
+	data, err := a.ReadFile("/sub/link")
+	require.NoError(t, err)
+	assert.Equal(t, "b", string(data))
+}
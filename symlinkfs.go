@@ -0,0 +1,427 @@
+package aferox
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+var _ afero.Fs = &SymlinkFs{}
+var _ afero.Symlinker = &SymlinkFs{}
+
+// maxSymlinkHops is the number of symbolic links that are followed while
+// resolving a single path before giving up with ELOOP. It matches the limit
+// used by Linux.
+const maxSymlinkHops = 40
+
+// SymlinkFs emulates symbolic links on top of a filesystem that doesn't
+// support them, such as afero.MemMapFs. Links are stored as metadata alongside
+// an empty placeholder file in the wrapped Fs, so that they show up in
+// directory listings and collide with other files just like a real link.
+//
+// Paths are resolved one component at a time, so a link may appear anywhere
+// in a path and a ".." after a link refers to the parent of its target, as on
+// POSIX. Relative link targets are resolved against the directory containing
+// the link. Paths are compared lexically, so callers
+// should use absolute paths, which is what Fsx always provides.
+type SymlinkFs struct {
+	fs afero.Fs
+
+	mu    sync.RWMutex
+	links map[string]symlink
+}
+
+type symlink struct {
+	target  string
+	modTime time.Time
+}
+
+// NewSymlinkFs creates a wrapper around a filesystem representation that
+// emulates symbolic links.
+func NewSymlinkFs(fs afero.Fs) *SymlinkFs {
+	return &SymlinkFs{
+		fs:    fs,
+		links: make(map[string]symlink),
+	}
+}
+
+// resolve returns the path in the wrapped Fs for name, after following every
+// symbolic link in it. When followLast is false, a link in the final
+// component of the path is not followed.
+func (s *SymlinkFs) resolve(name string, followLast bool) (string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	resolved, remaining := "", name
+	if filepath.IsAbs(name) {
+		resolved, remaining = HostPathStyle.splitRoot(name)
+	}
+
+	hops := 0
+	for remaining != "" {
+		component := remaining
+		remaining = ""
+		if i := strings.IndexRune(component, filepath.Separator); i >= 0 {
+			component, remaining = component[:i], component[i+1:]
+		}
+
+		switch component {
+		case "", ".":
+			continue
+		case "..":
+			// resolved never contains a link, so this is the parent of the link target
+			resolved = filepath.Join(resolved, "..")
+			continue
+		}
+
+		next := filepath.Join(resolved, component)
+		link, ok := s.links[next]
+		last := strings.Trim(remaining, string(filepath.Separator)) == ""
+		if !ok || (last && !followLast) {
+			resolved = next
+			continue
+		}
+
+		hops++
+		if hops > maxSymlinkHops {
+			return "", syscall.ELOOP
+		}
+
+		// Expand the link in place of the component. A relative target is
+		// resolved against the directory containing the link.
+		target := link.target
+		if filepath.IsAbs(target) {
+			resolved, target = HostPathStyle.splitRoot(target)
+		}
+		remaining = target + string(filepath.Separator) + remaining
+	}
+
+	if resolved == "" {
+		return ".", nil
+	}
+	return resolved, nil
+}
+
+// lookupLink returns the symbolic link stored at the already resolved path.
+func (s *SymlinkFs) lookupLink(path string) (symlink, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	link, ok := s.links[path]
+	return link, ok
+}
+
+// forgetLinks removes the metadata for any link at or below path.
+func (s *SymlinkFs) forgetLinks(path string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for name := range s.links {
+		if isPathOrChild(name, path) {
+			delete(s.links, name)
+		}
+	}
+}
+
+// moveLinks renames the metadata for any link at or below oldpath.
+func (s *SymlinkFs) moveLinks(oldpath, newpath string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for name, link := range s.links {
+		if isPathOrChild(name, oldpath) {
+			delete(s.links, name)
+			s.links[newpath+strings.TrimPrefix(name, oldpath)] = link
+		}
+	}
+}
+
+// isPathOrChild determines if name is path, or is contained in path.
+func isPathOrChild(name string, path string) bool {
+	if name == path {
+		return true
+	}
+	return strings.HasPrefix(name, path) && (os.IsPathSeparator(name[len(path)]) || os.IsPathSeparator(path[len(path)-1]))
+}
+
+// Create creates or truncates the named file, following symbolic links.
+func (s *SymlinkFs) Create(name string) (afero.File, error) {
+	return s.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+}
+
+// Mkdir creates a new directory with the specified name and permission
+// bits (before umask).
+func (s *SymlinkFs) Mkdir(name string, perm os.FileMode) error {
+	path, err := s.resolve(name, false)
+	if err != nil {
+		return &os.PathError{Op: "mkdir", Path: name, Err: err}
+	}
+	if _, ok := s.lookupLink(path); ok {
+		return &os.PathError{Op: "mkdir", Path: name, Err: os.ErrExist}
+	}
+	return s.fs.Mkdir(path, perm)
+}
+
+// MkdirAll creates a directory named path, along with any necessary parents,
+// following symbolic links.
+func (s *SymlinkFs) MkdirAll(path string, perm os.FileMode) error {
+	resolved, err := s.resolve(path, true)
+	if err != nil {
+		return &os.PathError{Op: "mkdir", Path: path, Err: err}
+	}
+	return s.fs.MkdirAll(resolved, perm)
+}
+
+// Open opens the named file for reading, following symbolic links.
+func (s *SymlinkFs) Open(name string) (afero.File, error) {
+	return s.OpenFile(name, os.O_RDONLY, 0)
+}
+
+// OpenFile opens a file using the given flags and the given mode, following
+// symbolic links. When the named file is a dangling link and O_CREATE is
+// specified, the target of the link is created.
+func (s *SymlinkFs) OpenFile(name string, flag int, perm os.FileMode) (afero.File, error) {
+	if flag&os.O_CREATE != 0 && flag&os.O_EXCL != 0 {
+		linkPath, err := s.resolve(name, false)
+		if err != nil {
+			return nil, &os.PathError{Op: "open", Path: name, Err: err}
+		}
+		if _, ok := s.lookupLink(linkPath); ok {
+			return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrExist}
+		}
+	}
+
+	path, err := s.resolve(name, true)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: name, Err: err}
+	}
+	file, err := s.fs.OpenFile(path, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return &symlinkFile{File: file, fs: s, name: name, path: path}, nil
+}
+
+// Remove removes the named file or (empty) directory. When the named file is
+// a symbolic link, the link is removed and not its target.
+func (s *SymlinkFs) Remove(name string) error {
+	path, err := s.resolve(name, false)
+	if err != nil {
+		return &os.PathError{Op: "remove", Path: name, Err: err}
+	}
+	if err := s.fs.Remove(path); err != nil {
+		return err
+	}
+	s.forgetLinks(path)
+	return nil
+}
+
+// RemoveAll removes path and any children it contains. Symbolic links are
+// removed and not followed.
+func (s *SymlinkFs) RemoveAll(path string) error {
+	resolved, err := s.resolve(path, false)
+	if err != nil {
+		return &os.PathError{Op: "removeall", Path: path, Err: err}
+	}
+	if err := s.fs.RemoveAll(resolved); err != nil {
+		return err
+	}
+	s.forgetLinks(resolved)
+	return nil
+}
+
+// Rename renames (moves) oldname to newname. When oldname is a symbolic link,
+// the link is moved and not its target.
+func (s *SymlinkFs) Rename(oldname, newname string) error {
+	oldpath, err := s.resolve(oldname, false)
+	if err != nil {
+		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: err}
+	}
+	newpath, err := s.resolve(newname, false)
+	if err != nil {
+		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: err}
+	}
+	if err := s.fs.Rename(oldpath, newpath); err != nil {
+		return err
+	}
+	if oldpath != newpath {
+		s.forgetLinks(newpath)
+		s.moveLinks(oldpath, newpath)
+	}
+	return nil
+}
+
+// Stat returns a FileInfo describing the named file, following symbolic links.
+func (s *SymlinkFs) Stat(name string) (os.FileInfo, error) {
+	path, err := s.resolve(name, true)
+	if err != nil {
+		return nil, &os.PathError{Op: "stat", Path: name, Err: err}
+	}
+	return s.fs.Stat(path)
+}
+
+// LstatIfPossible returns a FileInfo describing the named file. If the file is
+// a symbolic link, the returned FileInfo describes the link and not its target.
+func (s *SymlinkFs) LstatIfPossible(name string) (os.FileInfo, bool, error) {
+	path, err := s.resolve(name, false)
+	if err != nil {
+		return nil, true, &os.PathError{Op: "lstat", Path: name, Err: err}
+	}
+	if link, ok := s.lookupLink(path); ok {
+		return newSymlinkInfo(filepath.Base(path), link), true, nil
+	}
+	if lstater, ok := s.fs.(afero.Lstater); ok {
+		fi, _, err := lstater.LstatIfPossible(path)
+		return fi, true, err
+	}
+	fi, err := s.fs.Stat(path)
+	return fi, true, err
+}
+
+// SymlinkIfPossible creates newname as a symbolic link to oldname. The target
+// is stored as given, so a relative target is resolved against the directory
+// containing the link when it is followed.
+func (s *SymlinkFs) SymlinkIfPossible(oldname, newname string) error {
+	path, err := s.resolve(newname, false)
+	if err != nil {
+		return &os.LinkError{Op: "symlink", Old: oldname, New: newname, Err: err}
+	}
+
+	// Reserve the name in the wrapped Fs, which also validates that the
+	// parent directory exists and that nothing is already at that path.
+	placeholder, err := s.fs.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0777)
+	if err != nil {
+		return &os.LinkError{Op: "symlink", Old: oldname, New: newname, Err: underlyingError(err)}
+	}
+	placeholder.Close()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.links[path] = symlink{target: oldname, modTime: time.Now()}
+	return nil
+}
+
+// ReadlinkIfPossible returns the destination of the named symbolic link.
+func (s *SymlinkFs) ReadlinkIfPossible(name string) (string, error) {
+	path, err := s.resolve(name, false)
+	if err != nil {
+		return "", &os.PathError{Op: "readlink", Path: name, Err: err}
+	}
+	link, ok := s.lookupLink(path)
+	if !ok {
+		if _, err := s.fs.Stat(path); err != nil {
+			return "", err
+		}
+		return "", &os.PathError{Op: "readlink", Path: name, Err: syscall.EINVAL}
+	}
+	return link.target, nil
+}
+
+// Name of this FileSystem.
+func (s *SymlinkFs) Name() string {
+	return "SymlinkFs"
+}
+
+// Chmod changes the mode of the named file to mode, following symbolic links.
+func (s *SymlinkFs) Chmod(name string, mode os.FileMode) error {
+	path, err := s.resolve(name, true)
+	if err != nil {
+		return &os.PathError{Op: "chmod", Path: name, Err: err}
+	}
+	return s.fs.Chmod(path, mode)
+}
+
+// Chown changes the uid and gid of the named file, following symbolic links.
+func (s *SymlinkFs) Chown(name string, uid, gid int) error {
+	path, err := s.resolve(name, true)
+	if err != nil {
+		return &os.PathError{Op: "chown", Path: name, Err: err}
+	}
+	return s.fs.Chown(path, uid, gid)
+}
+
+// Chtimes changes the access and modification times of the named file,
+// following symbolic links.
+func (s *SymlinkFs) Chtimes(name string, atime time.Time, mtime time.Time) error {
+	path, err := s.resolve(name, true)
+	if err != nil {
+		return &os.PathError{Op: "chtimes", Path: name, Err: err}
+	}
+	return s.fs.Chtimes(path, atime, mtime)
+}
+
+// underlyingError returns the error wrapped by a *os.PathError, so that it can
+// be reported again with a different operation.
+func underlyingError(err error) error {
+	if pathErr, ok := err.(*os.PathError); ok {
+		return pathErr.Err
+	}
+	return err
+}
+
+// symlinkFile is a file opened through a SymlinkFs. It reports the name that
+// it was opened with, and describes symbolic links in directory listings.
+type symlinkFile struct {
+	afero.File
+
+	fs *SymlinkFs
+
+	// name that the file was opened with.
+	name string
+
+	// path to the file in the wrapped Fs.
+	path string
+}
+
+func (f *symlinkFile) Name() string {
+	return f.name
+}
+
+func (f *symlinkFile) Readdir(count int) ([]os.FileInfo, error) {
+	infos, err := f.File.Readdir(count)
+	for i, fi := range infos {
+		if link, ok := f.fs.lookupLink(filepath.Join(f.path, fi.Name())); ok {
+			infos[i] = newSymlinkInfo(fi.Name(), link)
+		}
+	}
+	return infos, err
+}
+
+// symlinkInfo describes an emulated symbolic link.
+type symlinkInfo struct {
+	name string
+	link symlink
+}
+
+func newSymlinkInfo(name string, link symlink) symlinkInfo {
+	return symlinkInfo{name: name, link: link}
+}
+
+func (fi symlinkInfo) Name() string {
+	return fi.name
+}
+
+func (fi symlinkInfo) Size() int64 {
+	return int64(len(fi.link.target))
+}
+
+func (fi symlinkInfo) Mode() os.FileMode {
+	return os.ModeSymlink | 0777
+}
+
+func (fi symlinkInfo) ModTime() time.Time {
+	return fi.link.modTime
+}
+
+func (fi symlinkInfo) IsDir() bool {
+	return false
+}
+
+func (fi symlinkInfo) Sys() interface{} {
+	return nil
+}
@@ -0,0 +1,210 @@
+package aferox
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSymlinkFs_Symlink(t *testing.T) {
+	a := NewAferox("/home", NewSymlinkFs(afero.NewMemMapFs()))
+	err := a.WriteFile("/home/me/target.txt", []byte("target"), 0644)
+	require.NoError(t, err, "WriteFile failed")
+
+	t.Run("absolute target", func(t *testing.T) {
+		err := a.Fs.SymlinkIfPossible("/home/me/target.txt", "/home/abs.txt")
+		require.NoError(t, err, "SymlinkIfPossible failed")
+
+		contents, err := a.ReadFile("abs.txt")
+		require.NoError(t, err, "ReadFile failed")
+		assert.Equal(t, "target", string(contents))
+
+		target, err := a.Fs.ReadlinkIfPossible("abs.txt")
+		require.NoError(t, err, "ReadlinkIfPossible failed")
+		assert.Equal(t, "/home/me/target.txt", target)
+	})
+
+	t.Run("relative target", func(t *testing.T) {
+		s := NewSymlinkFs(afero.NewMemMapFs())
+		err := afero.WriteFile(s, "/home/me/target.txt", []byte("target"), 0644)
+		require.NoError(t, err, "WriteFile failed")
+
+		err = s.SymlinkIfPossible("me/target.txt", "/home/rel.txt")
+		require.NoError(t, err, "SymlinkIfPossible failed")
+
+		contents, err := afero.ReadFile(s, "/home/rel.txt")
+		require.NoError(t, err, "ReadFile failed")
+		assert.Equal(t, "target", string(contents))
+	})
+
+	t.Run("directory in path", func(t *testing.T) {
+		err := a.Fs.SymlinkIfPossible("/home/me", "/home/medir")
+		require.NoError(t, err, "SymlinkIfPossible failed")
+
+		contents, err := a.ReadFile("medir/target.txt")
+		require.NoError(t, err, "ReadFile failed")
+		assert.Equal(t, "target", string(contents))
+
+		err = a.WriteFile("medir/new.txt", []byte("new"), 0644)
+		require.NoError(t, err, "WriteFile failed")
+		exists, _ := a.Exists("/home/me/new.txt")
+		assert.True(t, exists, "expected the file to be created in the link target")
+	})
+
+	t.Run("name already exists", func(t *testing.T) {
+		err := a.Fs.SymlinkIfPossible("/home/me/target.txt", "/home/me/target.txt")
+		require.Error(t, err)
+		assert.True(t, os.IsExist(err), "expected an already exists error, got %v", err)
+	})
+}
+
+func TestSymlinkFs_Lstat(t *testing.T) {
+	a := NewAferox("/home", NewSymlinkFs(afero.NewMemMapFs()))
+	err := a.WriteFile("target.txt", []byte("target"), 0644)
+	require.NoError(t, err, "WriteFile failed")
+	err = a.Fs.SymlinkIfPossible("target.txt", "link.txt")
+	require.NoError(t, err, "SymlinkIfPossible failed")
+
+	fi, lstatCalled, err := a.Fs.LstatIfPossible("link.txt")
+	require.NoError(t, err, "LstatIfPossible failed")
+	assert.True(t, lstatCalled)
+	assert.Equal(t, "link.txt", fi.Name())
+	assert.Equal(t, os.ModeSymlink, fi.Mode()&os.ModeSymlink)
+
+	fi, err = a.Stat("link.txt")
+	require.NoError(t, err, "Stat failed")
+	assert.False(t, fi.Mode()&os.ModeSymlink != 0, "Stat should follow the link")
+	assert.Equal(t, int64(len("target")), fi.Size())
+
+	items, err := a.ReadDir(".")
+	require.NoError(t, err, "ReadDir failed")
+	require.Len(t, items, 2)
+	assert.Equal(t, "link.txt", items[0].Name())
+	assert.Equal(t, os.ModeSymlink, items[0].Mode()&os.ModeSymlink, "expected the link to be listed as a symlink")
+	assert.Equal(t, "target.txt", items[1].Name())
+}
+
+func TestSymlinkFs_Dangling(t *testing.T) {
+	a := NewAferox("/home", NewSymlinkFs(afero.NewMemMapFs()))
+	err := a.MkdirAll("/home", 0755)
+	require.NoError(t, err, "MkdirAll failed")
+	err = a.Fs.SymlinkIfPossible("/home/missing.txt", "link.txt")
+	require.NoError(t, err, "SymlinkIfPossible failed")
+
+	_, err = a.Stat("link.txt")
+	assert.True(t, os.IsNotExist(err), "expected a not exist error, got %v", err)
+
+	_, _, err = a.Fs.LstatIfPossible("link.txt")
+	require.NoError(t, err, "LstatIfPossible should not follow the link")
+
+	_, err = a.Fs.OpenFile("link.txt", os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	assert.True(t, os.IsExist(err), "expected an already exists error, got %v", err)
+
+	err = a.WriteFile("link.txt", []byte("created"), 0644)
+	require.NoError(t, err, "WriteFile failed")
+	contents, err := a.ReadFile("missing.txt")
+	require.NoError(t, err, "ReadFile failed")
+	assert.Equal(t, "created", string(contents))
+}
+
+func TestSymlinkFs_Loop(t *testing.T) {
+	a := NewAferox("/home", NewSymlinkFs(afero.NewMemMapFs()))
+	err := a.MkdirAll("/home", 0755)
+	require.NoError(t, err, "MkdirAll failed")
+	err = a.Fs.SymlinkIfPossible("b", "a")
+	require.NoError(t, err, "SymlinkIfPossible failed")
+	err = a.Fs.SymlinkIfPossible("a", "b")
+	require.NoError(t, err, "SymlinkIfPossible failed")
+
+	_, err = a.Stat("a")
+	require.Error(t, err)
+	assert.True(t, errors.Is(err, syscall.ELOOP), "expected ELOOP, got %v", err)
+
+	_, err = a.Fs.ReadlinkIfPossible("a")
+	require.NoError(t, err, "ReadlinkIfPossible should not follow the link")
+}
+
+func TestSymlinkFs_RemoveRename(t *testing.T) {
+	a := NewAferox("/home", NewSymlinkFs(afero.NewMemMapFs()))
+	err := a.WriteFile("target.txt", []byte("target"), 0644)
+	require.NoError(t, err, "WriteFile failed")
+	err = a.Fs.SymlinkIfPossible("/home/target.txt", "link.txt")
+	require.NoError(t, err, "SymlinkIfPossible failed")
+
+	err = a.Rename("link.txt", "moved.txt")
+	require.NoError(t, err, "Rename failed")
+	target, err := a.Fs.ReadlinkIfPossible("moved.txt")
+	require.NoError(t, err, "ReadlinkIfPossible failed")
+	assert.Equal(t, "/home/target.txt", target)
+	_, _, err = a.Fs.LstatIfPossible("link.txt")
+	assert.True(t, os.IsNotExist(err), "expected the old link to be gone, got %v", err)
+
+	err = a.Remove("moved.txt")
+	require.NoError(t, err, "Remove failed")
+	_, _, err = a.Fs.LstatIfPossible("moved.txt")
+	assert.True(t, os.IsNotExist(err), "expected the link to be removed, got %v", err)
+
+	exists, _ := a.Exists("target.txt")
+	assert.True(t, exists, "removing the link should not remove its target")
+}
+
+func TestSymlinkFs_Readlink(t *testing.T) {
+	s := NewSymlinkFs(afero.NewMemMapFs())
+	_, err := s.Create("/file.txt")
+	require.NoError(t, err, "Create failed")
+
+	_, err = s.ReadlinkIfPossible("/file.txt")
+	assert.True(t, errors.Is(err, syscall.EINVAL), "expected EINVAL for a regular file, got %v", err)
+
+	_, err = s.ReadlinkIfPossible("/missing.txt")
+	assert.True(t, os.IsNotExist(err), "expected a not exist error, got %v", err)
+}
+
+func TestSymlinkFs_Name(t *testing.T) {
+	s := NewSymlinkFs(afero.NewMemMapFs())
+	assert.Equal(t, "SymlinkFs", s.Name())
+}
+
+func TestSymlinkFs_DotDotAfterLink(t *testing.T) {
+	s := NewSymlinkFs(afero.NewMemMapFs())
+	err := afero.WriteFile(s, "/srv/data/config.txt", []byte("config"), 0644)
+	require.NoError(t, err, "WriteFile failed")
+	err = afero.WriteFile(s, "/srv/other.txt", []byte("other"), 0644)
+	require.NoError(t, err, "WriteFile failed")
+	err = s.MkdirAll("/home", 0755)
+	require.NoError(t, err, "MkdirAll failed")
+	err = s.SymlinkIfPossible("/srv/data", "/home/data")
+	require.NoError(t, err, "SymlinkIfPossible failed")
+
+	contents, err := afero.ReadFile(s, "/home/data/../other.txt")
+	require.NoError(t, err, "ReadFile should resolve .. against the link target")
+	assert.Equal(t, "other", string(contents))
+}
+
+func TestSymlinkFs_MaxHops(t *testing.T) {
+	a := NewAferox("/home", NewSymlinkFs(afero.NewMemMapFs()))
+	err := a.WriteFile("target.txt", []byte("target"), 0644)
+	require.NoError(t, err, "WriteFile failed")
+
+	// link0 -> link1 -> ... -> link40 -> target.txt
+	err = a.Fs.SymlinkIfPossible("target.txt", fmt.Sprintf("link%d", maxSymlinkHops))
+	require.NoError(t, err, "SymlinkIfPossible failed")
+	for i := maxSymlinkHops - 1; i >= 0; i-- {
+		err = a.Fs.SymlinkIfPossible(fmt.Sprintf("link%d", i+1), fmt.Sprintf("link%d", i))
+		require.NoError(t, err, "SymlinkIfPossible failed")
+	}
+
+	contents, err := a.ReadFile("link1")
+	require.NoError(t, err, "ReadFile should follow exactly %d links", maxSymlinkHops)
+	assert.Equal(t, "target", string(contents))
+
+	_, err = a.ReadFile("link0")
+	require.Error(t, err)
+	assert.True(t, errors.Is(err, syscall.ELOOP), "expected ELOOP, got %v", err)
+}
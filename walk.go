@@ -0,0 +1,221 @@
+package aferox
+
+import (
+	"context"
+	"io/fs"
+	"os"
+	"strings"
+	"syscall"
+)
+
+// WalkDirOptions customize how Aferox.WalkDir walks a file tree.
+type WalkDirOptions struct {
+	// RelativePaths reports paths relative to the root of the walk, with the
+	// root itself reported as ".". By default, paths are the root as given
+	// joined with the path of the file below it, like filepath.WalkDir.
+	RelativePaths bool
+
+	// Include limits which files are reported to those matching at least one
+	// of the patterns. Directories are always walked, and reported, so that
+	// files below them can be matched. Patterns use the syntax of Glob, and are
+	// matched against the path relative to the root.
+	Include []string
+
+	// Exclude skips files and directories matching any of the patterns,
+	// directories that are excluded are not walked. Patterns use the syntax of
+	// Glob, and are matched against the path relative to the root.
+	Exclude []string
+
+	// FollowSymlinks walks into directories referenced by symbolic links.
+	// A link to one of the directories containing it is reported, but not
+	// walked, to avoid cycles.
+	FollowSymlinks bool
+}
+
+// WalkDir walks the file tree rooted at root, calling fn for each file or
+// directory in the tree, including root, in lexical order. The root is
+// resolved against the working directory.
+//
+// The walk stops with the context's error when ctx is cancelled. Errors and
+// fs.SkipDir returned by fn are handled just like fs.WalkDir. Unless
+// FollowSymlinks is set, symbolic links are reported but not followed.
+// Use in place of filepath.WalkDir.
+func (a Aferox) WalkDir(ctx context.Context, root string, opts WalkDirOptions, fn fs.WalkDirFunc) error {
+	for _, pattern := range append(append([]string{}, opts.Include...), opts.Exclude...) {
+		if _, err := globMatch(a.Fs.PathStyle(), pattern, "", GlobOptions{}); err != nil {
+			return err
+		}
+	}
+
+	w := walker{a: a, ctx: ctx, opts: opts, style: a.Fs.PathStyle(), root: root, fn: fn}
+	path := a.Abs(root)
+	fi, err := w.stat(path)
+	if err != nil {
+		err = fn(w.reportPath("."), nil, err)
+	} else {
+		err = w.walk(path, ".", dirEntry{fi}, nil)
+	}
+	if err == fs.SkipDir {
+		return nil
+	}
+	return err
+}
+
+// walker holds the state of a call to WalkDir.
+type walker struct {
+	a    Aferox
+	ctx  context.Context
+	opts WalkDirOptions
+
+	// style of the paths, so that paths are reported like the Fsx reports
+	// them.
+	style PathStyle
+
+	root string
+	fn   fs.WalkDirFunc
+}
+
+// walk reports the file at path, and everything below it when it is a
+// directory. The path relative to the root is rel. The resolved paths of the
+// directories containing path are in ancestors when following symbolic links.
+func (w walker) walk(path string, rel string, d fs.DirEntry, ancestors []string) error {
+	if err := w.ctx.Err(); err != nil {
+		return err
+	}
+
+	if rel != "." && w.matchAny(w.opts.Exclude, rel) {
+		return nil
+	}
+	if !d.IsDir() {
+		if len(w.opts.Include) > 0 && !w.matchAny(w.opts.Include, rel) {
+			return nil
+		}
+		return w.fn(w.reportPath(rel), d, nil)
+	}
+
+	if err := w.fn(w.reportPath(rel), d, nil); err != nil {
+		if err == fs.SkipDir {
+			return nil
+		}
+		return err
+	}
+
+	if w.opts.FollowSymlinks {
+		realPath, err := w.a.EvalSymlinks(path)
+		if err == nil {
+			for _, ancestor := range ancestors {
+				if ancestor == realPath {
+					return nil
+				}
+			}
+			ancestors = append(ancestors, realPath)
+		}
+	}
+
+	infos, err := w.a.ReadDir(path)
+	if err != nil {
+		// Report the error a second time for the directory, like fs.WalkDir
+		if err := w.fn(w.reportPath(rel), d, err); err != nil {
+			if err == fs.SkipDir {
+				return nil
+			}
+			return err
+		}
+	}
+
+	for _, fi := range infos {
+		childPath := w.style.join(path, fi.Name())
+		if w.opts.FollowSymlinks && fi.Mode()&os.ModeSymlink != 0 {
+			if target, err := w.a.Stat(childPath); err == nil {
+				fi = namedFileInfo{FileInfo: target, name: fi.Name()}
+			}
+		}
+
+		err := w.walk(childPath, w.style.join(rel, fi.Name()), dirEntry{fi}, ancestors)
+		if err != nil {
+			// Skip the remaining files in the directory when SkipDir is returned for a file
+			if err == fs.SkipDir {
+				break
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// stat describes the root of the walk, following a symbolic link only when
+// FollowSymlinks is set.
+func (w walker) stat(path string) (os.FileInfo, error) {
+	if w.opts.FollowSymlinks {
+		return w.a.Stat(path)
+	}
+	fi, _, err := w.a.Fs.LstatIfPossible(path)
+	return fi, err
+}
+
+// reportPath converts a path relative to the root into the path given to fn.
+func (w walker) reportPath(rel string) string {
+	if w.opts.RelativePaths {
+		return rel
+	}
+	return w.style.join(w.root, rel)
+}
+
+// matchAny determines if the relative path matches any of the patterns.
+func (w walker) matchAny(patterns []string, rel string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := globMatch(w.style, pattern, rel, GlobOptions{}); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// EvalSymlinks returns the path name after the evaluation of any symbolic
+// links. The path is resolved against the working directory, and the result
+// is an absolute path. Link targets are joined lexically, so ".." in a target
+// is resolved before following any symbolic links in it.
+// Use in place of filepath.EvalSymlinks.
+func (a Aferox) EvalSymlinks(path string) (string, error) {
+	style := a.Fs.PathStyle()
+	isSeparator := func(r rune) bool {
+		return r < 0x80 && style.isSeparator(uint8(r))
+	}
+
+	resolved, remaining := style.splitRoot(a.Abs(path))
+	for hops := 0; remaining != ""; {
+		var name string
+		if i := strings.IndexFunc(remaining, isSeparator); i >= 0 {
+			name, remaining = remaining[:i], remaining[i+1:]
+		} else {
+			name, remaining = remaining, ""
+		}
+		if name == "" {
+			continue
+		}
+
+		next := style.join(resolved, name)
+		fi, _, err := a.Fs.LstatIfPossible(next)
+		if err != nil {
+			return "", err
+		}
+		if fi.Mode()&os.ModeSymlink == 0 {
+			resolved = next
+			continue
+		}
+
+		hops++
+		if hops > maxSymlinkHops {
+			return "", &os.PathError{Op: "lstat", Path: path, Err: syscall.ELOOP}
+		}
+		target, err := a.Fs.ReadlinkIfPossible(next)
+		if err != nil {
+			return "", err
+		}
+		if !style.isAbs(target) {
+			target = style.join(resolved, target)
+		}
+		resolved, remaining = style.splitRoot(style.join(target, remaining))
+	}
+	return resolved, nil
+}
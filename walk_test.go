@@ -0,0 +1,214 @@
+package aferox
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// collectWalk walks the root and returns the reported paths.
+func collectWalk(t *testing.T, a Aferox, root string, opts WalkDirOptions) []string {
+	var walked []string
+	err := a.WalkDir(context.Background(), root, opts, func(path string, d fs.DirEntry, err error) error {
+		require.NoError(t, err)
+		walked = append(walked, filepath.ToSlash(path))
+		return nil
+	})
+	require.NoError(t, err, "WalkDir failed")
+	return walked
+}
+
+func TestAferox_WalkDir(t *testing.T) {
+	a := NewAferox("/home", NewSymlinkFs(afero.NewMemMapFs()))
+	writeTestFiles(t, a.Fs, map[string]string{
+		"/home/bundle/porter.yaml":              "",
+		"/home/bundle/charts/mysql/Chart.yaml":  "",
+		"/home/bundle/charts/mysql/values.yaml": "",
+		"/home/bundle/.cnab/bundle.json":        "",
+		"/home/bundle/scripts/install.sh":       "",
+	})
+
+	t.Run("root as given", func(t *testing.T) {
+		walked := collectWalk(t, a, "bundle/charts", WalkDirOptions{})
+		assert.Equal(t, []string{"bundle/charts", "bundle/charts/mysql", "bundle/charts/mysql/Chart.yaml", "bundle/charts/mysql/values.yaml"}, walked)
+	})
+
+	t.Run("relative paths", func(t *testing.T) {
+		walked := collectWalk(t, a, "bundle", WalkDirOptions{RelativePaths: true})
+		assert.Equal(t, []string{".", ".cnab", ".cnab/bundle.json", "charts", "charts/mysql",
+			"charts/mysql/Chart.yaml", "charts/mysql/values.yaml", "porter.yaml", "scripts", "scripts/install.sh"}, walked)
+	})
+
+	t.Run("include", func(t *testing.T) {
+		walked := collectWalk(t, a, "bundle", WalkDirOptions{RelativePaths: true, Include: []string{"**/*.yaml"}})
+		assert.Equal(t, []string{".", ".cnab", "charts", "charts/mysql", "charts/mysql/Chart.yaml", "charts/mysql/values.yaml", "porter.yaml", "scripts"}, walked)
+	})
+
+	t.Run("exclude", func(t *testing.T) {
+		walked := collectWalk(t, a, "bundle", WalkDirOptions{RelativePaths: true, Exclude: []string{".cnab", "charts/**/values.yaml", "{scripts,missing}"}})
+		assert.Equal(t, []string{".", "charts", "charts/mysql", "charts/mysql/Chart.yaml", "porter.yaml"}, walked)
+	})
+
+	t.Run("bad pattern", func(t *testing.T) {
+		err := a.WalkDir(context.Background(), "bundle", WalkDirOptions{Include: []string{"["}}, func(string, fs.DirEntry, error) error {
+			return nil
+		})
+		assert.Equal(t, filepath.ErrBadPattern, err)
+	})
+
+	t.Run("skip dir", func(t *testing.T) {
+		var walked []string
+		err := a.WalkDir(context.Background(), "bundle", WalkDirOptions{RelativePaths: true}, func(path string, d fs.DirEntry, err error) error {
+			walked = append(walked, filepath.ToSlash(path))
+			if path == "charts" {
+				return fs.SkipDir
+			}
+			if path == filepath.FromSlash(".cnab/bundle.json") {
+				// Skip the rest of the files in .cnab
+				return fs.SkipDir
+			}
+			return nil
+		})
+		require.NoError(t, err, "WalkDir failed")
+		assert.Equal(t, []string{".", ".cnab", ".cnab/bundle.json", "charts", "porter.yaml", "scripts", "scripts/install.sh"}, walked)
+	})
+
+	t.Run("missing root", func(t *testing.T) {
+		var gotErr error
+		err := a.WalkDir(context.Background(), "missing", WalkDirOptions{}, func(path string, d fs.DirEntry, err error) error {
+			gotErr = err
+			return err
+		})
+		require.Error(t, err)
+		assert.Equal(t, gotErr, err)
+	})
+}
+
+func TestAferox_WalkDir_Cancel(t *testing.T) {
+	a := NewAferox("/home", NewSymlinkFs(afero.NewMemMapFs()))
+	writeTestFiles(t, a.Fs, map[string]string{
+		"/home/bundle/porter.yaml":             "",
+		"/home/bundle/charts/mysql/Chart.yaml": "",
+		"/home/bundle/.cnab/bundle.json":       "",
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var walked []string
+	err := a.WalkDir(ctx, "bundle", WalkDirOptions{RelativePaths: true}, func(path string, d fs.DirEntry, err error) error {
+		walked = append(walked, filepath.ToSlash(path))
+		if path == "charts" {
+			cancel()
+		}
+		return nil
+	})
+	assert.True(t, errors.Is(err, context.Canceled), "expected the walk to be cancelled, got %v", err)
+	assert.Equal(t, []string{".", ".cnab", ".cnab/bundle.json", "charts"}, walked)
+}
+
+func TestAferox_WalkDir_Symlinks(t *testing.T) {
+	a := NewAferox("/home", NewSymlinkFs(afero.NewMemMapFs()))
+	writeTestFiles(t, a.Fs, map[string]string{
+		"/home/bundle/charts/mysql/Chart.yaml":  "",
+		"/home/bundle/charts/mysql/values.yaml": "",
+		"/home/bundle/scripts/install.sh":       "",
+	})
+	require.NoError(t, a.Fs.SymlinkIfPossible("/home/bundle/charts", "/home/bundle/scripts/charts"))
+	require.NoError(t, a.Fs.SymlinkIfPossible("/home/bundle", "/home/bundle/charts/mysql/loop"))
+
+	t.Run("not followed", func(t *testing.T) {
+		var links []string
+		err := a.WalkDir(context.Background(), "bundle/scripts", WalkDirOptions{RelativePaths: true}, func(path string, d fs.DirEntry, err error) error {
+			require.NoError(t, err)
+			if d.Type()&fs.ModeSymlink != 0 {
+				links = append(links, path)
+			}
+			return nil
+		})
+		require.NoError(t, err, "WalkDir failed")
+		assert.Equal(t, []string{"charts"}, links)
+	})
+
+	t.Run("followed", func(t *testing.T) {
+		walked := collectWalk(t, a, "bundle/scripts", WalkDirOptions{RelativePaths: true, FollowSymlinks: true, Exclude: []string{"**/.cnab", "**/porter.yaml"}})
+		assert.Equal(t, []string{".",
+			"charts", "charts/mysql", "charts/mysql/Chart.yaml",
+			// loop points back to bundle, so it is walked until reaching scripts again
+			"charts/mysql/loop", "charts/mysql/loop/charts", "charts/mysql/loop/scripts",
+			"charts/mysql/values.yaml", "install.sh"}, walked)
+	})
+}
+
+func TestAferox_EvalSymlinks(t *testing.T) {
+	a := NewAferox("/home", NewSymlinkFs(afero.NewMemMapFs()))
+	writeTestFiles(t, a.Fs, map[string]string{
+		"/home/bundle/charts/mysql/Chart.yaml": "",
+		"/home/bundle/scripts/install.sh":      "",
+	})
+	require.NoError(t, a.Fs.SymlinkIfPossible("/home/bundle/charts", "/home/bundle/scripts/charts"))
+
+	path, err := a.EvalSymlinks("bundle/scripts/charts/mysql")
+	require.NoError(t, err, "EvalSymlinks failed")
+	assert.Equal(t, xplat("/home/bundle/charts/mysql"), path)
+
+	_, err = a.EvalSymlinks("bundle/missing")
+	assert.Error(t, err)
+}
+
+func TestAferox_WalkDir_WindowsPathStyle(t *testing.T) {
+	a := NewAferoxWithEnv("/", NewSymlinkFs(afero.NewMemMapFs()), NewEnv(nil))
+	a.SetPathStyle(WindowsPathStyle)
+	require.NoError(t, a.WriteFile(`C:\src\app\cmd\main.go`, nil, 0644))
+	require.NoError(t, a.WriteFile(`C:\src\app\README.md`, nil, 0644))
+
+	walk := func(opts WalkDirOptions) []string {
+		var walked []string
+		err := a.WalkDir(context.Background(), `C:\src`, opts, func(path string, d fs.DirEntry, err error) error {
+			require.NoError(t, err)
+			walked = append(walked, path)
+			return nil
+		})
+		require.NoError(t, err, "WalkDir failed")
+		return walked
+	}
+
+	assert.Equal(t, []string{
+		`C:\src`,
+		`C:\src\app`,
+		`C:\src\app\README.md`,
+		`C:\src\app\cmd`,
+		`C:\src\app\cmd\main.go`,
+	}, walk(WalkDirOptions{}))
+
+	assert.Equal(t, []string{
+		".",
+		"app",
+		`app\cmd`,
+		`app\cmd\main.go`,
+	}, walk(WalkDirOptions{RelativePaths: true, Include: []string{"**/*.go"}}))
+}
+
+func TestAferox_EvalSymlinks_WindowsPathStyle(t *testing.T) {
+	fs := NewSymlinkFs(afero.NewMemMapFs())
+	a := NewAferoxWithEnv("/", fs, NewEnv(nil))
+	a.SetPathStyle(WindowsPathStyle)
+	require.NoError(t, a.WriteFile(`C:\src\app\cmd\main.go`, nil, 0644))
+	require.NoError(t, a.Fs.SymlinkIfPossible(`C:\src\app`, `C:\src\current`))
+	require.NoError(t, a.Chdir(`C:\src`))
+
+	path, err := a.EvalSymlinks(`current\cmd\main.go`)
+	require.NoError(t, err, "EvalSymlinks failed")
+	assert.Equal(t, `C:\src\app\cmd\main.go`, path)
+
+	// A relative target is resolved against the directory of the link
+	require.NoError(t, fs.SymlinkIfPossible(`..\app`, WindowsPathStyle.toHost(`C:\src\app\self`)))
+	path, err = a.EvalSymlinks(`C:\src\app\self\cmd`)
+	require.NoError(t, err, "EvalSymlinks failed")
+	assert.Equal(t, `C:\src\app\cmd`, path)
+}